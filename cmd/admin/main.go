@@ -0,0 +1,344 @@
+// cmd/admin/main.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"grls/internal/config"
+	"grls/internal/currency"
+	"grls/internal/infrastructure/cache"
+	"grls/internal/infrastructure/db"
+	"grls/internal/infrastructure/repository"
+	"grls/internal/reconcile"
+	"grls/internal/store"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = `usage: admin <command> [args]
+
+commands:
+  dlq list [-limit N]      tampilkan entry dead-letter (dlq:wallet)
+  dlq replay <id>          sisipkan ulang entry DLQ di head antrian user, lalu hapus dari DLQ
+                           (QUEUE_BACKEND=stream: hanya kalau antrian user kosong)
+  dlq discard <id>         hapus entry DLQ tanpa replay
+  dlq events [-limit N]    event stream:wallet yang gagal dipersist ke Postgres (dlq:events:wallet,
+                           WALLET_MODE=redis); susulkan dengan reconcile -repair postgres
+
+  quarantine list [-limit N]         tampilkan payload rusak (quarantine:wallet)
+  quarantine reinject <id> <json>    sisipkan payload hasil perbaikan di head q:{user}
+                                     (hanya QUEUE_BACKEND=list)
+                                     (<json> = "-" untuk baca dari stdin)
+
+  shards                   pembagian shard ready ke worker hidup + panjang ready per shard
+                           (hanya QUEUE_BACKEND=list)
+
+  reconcile [-repair none|redis|postgres] [-dry-run=false] [-timeout 10m]
+                           bandingkan balance:{wN}:<user>:<CUR> dengan wallets.balance, laporan JSON.
+                           -repair redis = salin Postgres ke Redis, postgres = ADJUSTMENT dari Redis.
+                           Default dry-run: repair hanya ditulis dengan -dry-run=false
+
+  keyspace-migrate [-from legacy|N] [-to N] [-dry-run=false]
+                           pindahkan balance/tx/op/attempts + dlq/quarantine ke layout shard baru
+                           (-from default = layout tercatat, atau legacy; -to default = QUEUE_SHARDS).
+                           Semua instance harus mati dan antrian kosong. Default dry-run
+
+id DLQ = <user_id>:<tx_id>, id karantina = <user_id>:<unix ms>
+`
+
+// parkingAdmin: operasi dlq:wallet & quarantine:wallet yang dimiliki semua backend Queue
+type parkingAdmin interface {
+	ListDeadLetters(ctx context.Context, limit int) ([]store.DeadLetter, error)
+	ReplayDeadLetter(ctx context.Context, id string) (store.EnqueueResult, error)
+	DiscardDeadLetter(ctx context.Context, id string) error
+	ListQuarantined(ctx context.Context, limit int) ([]store.Quarantined, error)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := config.Load()
+	rdb, err := cache.ConnectRedis(ctx, *cfg.Redis)
+	if err != nil {
+		fatalf("redis connect: %v", err)
+	}
+	defer rdb.Close()
+	// sebelum NewQueue: constructor stream backend sudah membuat key di layout QUEUE_SHARDS
+	if os.Args[1] == "keyspace-migrate" {
+		runKeyspaceMigrate(cfg, rdb, os.Args[2:])
+		return
+	}
+	if err := store.CheckKeyspace(ctx, rdb, cfg.Worker.QueueShards); err != nil {
+		fatalf("redis keyspace: %v", err)
+	}
+	queue, err := store.NewQueue(rdb, cfg.Worker.QueueBackend, cfg.Worker.QueueShards)
+	if err != nil {
+		fatalf("queue backend: %v", err)
+	}
+	parked, ok := queue.(parkingAdmin)
+	if !ok {
+		fatalf("queue backend %q has no dlq/quarantine store", cfg.Worker.QueueBackend)
+	}
+
+	switch os.Args[1] {
+	case "dlq":
+		runDLQ(ctx, cfg, rdb, parked, os.Args[2:])
+	case "quarantine":
+		runQuarantine(ctx, cfg, parked, os.Args[2:])
+	case "shards":
+		runShards(ctx, queue)
+	case "reconcile":
+		runReconcile(cfg, rdb, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runDLQ(ctx context.Context, cfg *config.Config, rdb redis.UniversalClient, queue parkingAdmin, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("dlq list", flag.ExitOnError)
+		limit := fs.Int("limit", 100, "maximum entries")
+		_ = fs.Parse(args[1:])
+
+		items, err := queue.ListDeadLetters(ctx, *limit)
+		if err != nil {
+			fatalf("dlq list: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(items)
+
+	case "events":
+		fs := flag.NewFlagSet("dlq events", flag.ExitOnError)
+		limit := fs.Int("limit", 100, "maximum entries")
+		_ = fs.Parse(args[1:])
+
+		items, err := store.NewRedisWalletStore(rdb, cfg.Worker.QueueShards).ListDeadEvents(ctx, *limit)
+		if err != nil {
+			fatalf("dlq events: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(items)
+
+	case "replay":
+		id := requireArg(args, "dlq replay <id>")
+		res, err := queue.ReplayDeadLetter(ctx, id)
+		if err != nil {
+			fatalf("dlq replay %s: %v", id, err)
+		}
+		fmt.Printf("replayed %s (queue position=%d, acquired=%v)\n", id, res.Position, res.Acquired)
+
+	case "discard":
+		id := requireArg(args, "dlq discard <id>")
+		if err := queue.DiscardDeadLetter(ctx, id); err != nil {
+			fatalf("dlq discard %s: %v", id, err)
+		}
+		fmt.Printf("discarded %s\n", id)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runQuarantine(ctx context.Context, cfg *config.Config, queue parkingAdmin, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("quarantine list", flag.ExitOnError)
+		limit := fs.Int("limit", 100, "maximum entries")
+		_ = fs.Parse(args[1:])
+
+		items, err := queue.ListQuarantined(ctx, *limit)
+		if err != nil {
+			fatalf("quarantine list: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(items)
+
+	case "reinject":
+		id := requireArg(args, "quarantine reinject <id> <json>")
+		if len(args) < 3 {
+			fatalf("usage: admin quarantine reinject <id> <json>")
+		}
+		raw := args[2]
+		if raw == "-" {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fatalf("read stdin: %v", err)
+			}
+			raw = string(b)
+		}
+
+		var p store.OperationPayload
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			fatalf("payload still invalid: %v", err)
+		}
+		// stream tidak bisa disisipi sebelum entry tertua, jadi reinject di head hanya untuk list
+		lq, ok := queue.(*store.RedisQueue)
+		if !ok {
+			fatalf("quarantine reinject needs QUEUE_BACKEND=list")
+		}
+		acquired, err := lq.ReinjectQuarantined(ctx, id, p)
+		if err != nil {
+			fatalf("quarantine reinject %s: %v", id, err)
+		}
+
+		// audit trail Postgres; payload sudah di queue, jadi kegagalan di sini cukup dilaporkan
+		dbWrite, err := db.ConnectDBWrite(cfg.DB)
+		if err == nil {
+			repo := repository.NewWalletRepository(dbWrite, dbWrite)
+			err = repo.MarkReinjected(ctx, id, raw)
+			db.CloseDBWrite()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: reinjected but audit row not updated: %v\n", err)
+		}
+		fmt.Printf("reinjected %s at head of q:{%s} (acquired=%v)\n", id, p.UserID, acquired)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runShards(ctx context.Context, queue store.Queue) {
+	lq, ok := queue.(*store.RedisQueue)
+	if !ok {
+		fatalf("shards needs QUEUE_BACKEND=list")
+	}
+	live, err := lq.LiveWorkers(ctx)
+	if err != nil {
+		fatalf("live workers: %v", err)
+	}
+	plan := store.AssignShards(lq.Keys.Shards, live)
+
+	fmt.Printf("shards=%d live_workers=%d\n", lq.Keys.Shards, len(live))
+	for s := 0; s < lq.Keys.Shards; s++ {
+		n, err := lq.ReadyLen(ctx, s)
+		if err != nil {
+			fatalf("ready len shard %d: %v", s, err)
+		}
+		var owners []string
+		for _, w := range live {
+			for _, ws := range plan[w] {
+				if ws == s {
+					owners = append(owners, w)
+				}
+			}
+		}
+		fmt.Printf("%s len=%d workers=%s\n", lq.ReadyKeyName(s), n, strings.Join(owners, ","))
+	}
+}
+
+func runReconcile(cfg *config.Config, rdb redis.UniversalClient, args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.String("repair", "none", "side to repair: none|redis|postgres")
+	dryRun := fs.Bool("dry-run", true, "report repairs without writing")
+	timeout := fs.Duration("timeout", 10*time.Minute, "maximum run time")
+	_ = fs.Parse(args)
+
+	dir, err := reconcile.ParseDirection(*repair)
+	if err != nil {
+		fatalf("reconcile: %v", err)
+	}
+	dbWrite, err := db.ConnectDBWrite(cfg.DB)
+	if err != nil {
+		fatalf("db connect: %v", err)
+	}
+	defer db.CloseDBWrite()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	repo := repository.NewWalletRepository(dbWrite, dbWrite)
+	currencies := currency.NewRegistry(repo)
+	if err := currencies.Refresh(ctx); err != nil {
+		fatalf("currency registry: %v", err)
+	}
+	rc := reconcile.New(store.NewRedisWalletStore(rdb, cfg.Worker.QueueShards), repo)
+	rc.Decimals = currencies.Decimals
+	rep, err := rc.Run(ctx, reconcile.Options{Repair: dir, DryRun: *dryRun})
+	if err != nil {
+		fatalf("reconcile: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
+
+func runKeyspaceMigrate(cfg *config.Config, rdb redis.UniversalClient, args []string) {
+	fs := flag.NewFlagSet("keyspace-migrate", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "current layout: legacy|<shards> (default: recorded, else legacy)")
+	toFlag := fs.Int("to", cfg.Worker.QueueShards, "target QUEUE_SHARDS")
+	dryRun := fs.Bool("dry-run", true, "report without writing")
+	timeout := fs.Duration("timeout", 10*time.Minute, "maximum run time")
+	_ = fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	from := store.Layout{Legacy: true, Shards: 1}
+	if *fromFlag != "" {
+		l, err := store.ParseLayout(*fromFlag)
+		if err != nil {
+			fatalf("keyspace-migrate: %v", err)
+		}
+		from = l
+	} else if l, found, err := store.ReadLayout(ctx, rdb); err != nil {
+		fatalf("keyspace-migrate: %v", err)
+	} else if found {
+		from = l
+	}
+	to := store.ShardedLayout(*toFlag)
+	if from == to {
+		fatalf("keyspace-migrate: redis already uses %s shards", to)
+	}
+
+	rep, err := store.MigrateKeyspace(ctx, rdb, from, to, *dryRun)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+	if err != nil {
+		fatalf("keyspace-migrate: %v", err)
+	}
+}
+
+func requireArg(args []string, form string) string {
+	if len(args) < 2 || args[1] == "" {
+		fatalf("usage: admin %s", form)
+	}
+	return args[1]
+}
+
+func fatalf(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(1)
+}
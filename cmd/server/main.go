@@ -5,23 +5,32 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"grls/internal/async" // <-- goroutine processor FIFO
 	"grls/internal/config"
+	"grls/internal/currency"
 	grpcserver "grls/internal/grpc"
 	"grls/internal/infrastructure/cache"
 	"grls/internal/infrastructure/db"
 	"grls/internal/infrastructure/repository"
-	"grls/internal/store" // <-- RedisQueue (Lua enqueue/release)
+	"grls/internal/metrics"
+	"grls/internal/reconcile"
+	"grls/internal/store" // <-- Queue backend (list+Lua / streams)
+	"grls/internal/tracing"
+	"grls/internal/warmup"
 	"grls/pkg/graceful"
 	"grls/pkg/logger"
 
 	"github.com/jpillora/overseer"
 	"github.com/jpillora/overseer/fetcher"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -44,6 +53,15 @@ func program(state overseer.State) {
 	cfg := config.Load()
 	logger.InitLogFile(cfg.App.LogFilePath)
 
+	// --- Tracing (OTLP) ---
+	shutdownTracing, err := tracing.Init(ctx, *cfg.Tracing)
+	if err != nil {
+		logger.Fatal("❌ Tracing init: " + err.Error())
+	}
+	if cfg.Tracing.Enabled {
+		logger.Infof("✅ Tracing enabled (otlp=%s)", cfg.Tracing.Endpoint)
+	}
+
 	// --- DB connections ---
 	dbWrite, err := db.ConnectDBWrite(cfg.DB)
 	if err != nil {
@@ -54,33 +72,161 @@ func program(state overseer.State) {
 		logger.Fatal("❌ Failed DB read: " + err.Error())
 	}
 	logger.Infof("✅ DB connected (write=%s, read=%s)", cfg.DB.DBWrite.Name, cfg.DB.DBRead.Name)
+	for _, gdb := range []*gorm.DB{dbWrite, dbRead} {
+		if err := gdb.Use(tracing.GormPlugin{}); err != nil {
+			logger.Warnf("gorm tracing plugin: %v", err)
+		}
+	}
 
 	// --- Redis connection ---
+	if err := cache.CheckClusterShards(cfg.Redis.Mode, cfg.Worker.QueueShards); err != nil {
+		logger.Fatal("❌ Redis config: " + err.Error())
+	}
 	rdb, err := cache.ConnectRedis(ctx, *cfg.Redis)
 	if err != nil {
 		logger.Fatal("❌ Redis connect: " + err.Error())
 	}
 	logger.Info("✅ Redis connected")
+	rdb.AddHook(tracing.ScriptHook{})
+	// layout key harus cocok dengan QUEUE_SHARDS sebelum queue (sched group) dibuat
+	if err := store.CheckKeyspace(ctx, rdb, cfg.Worker.QueueShards); err != nil {
+		logger.Fatal("❌ Redis keyspace: " + err.Error())
+	}
 
 	// --- Dependencies ---
 	repo := repository.NewWalletRepository(dbWrite, dbRead)
-	queue := store.NewRedisQueue(rdb) // pakai Lua enqueue/release
+	queue, err := store.NewQueue(rdb, cfg.Worker.QueueBackend, cfg.Worker.QueueShards) // list (Lua) | stream
+	if err != nil {
+		logger.Fatal("❌ Queue backend: " + err.Error())
+	}
+	logger.Infof("✅ Queue backend: %s", cfg.Worker.QueueBackend)
+	walletStore := store.NewRedisWalletStore(rdb, cfg.Worker.QueueShards)
 
-	// --- Start async processor (BRPOP ready:wallet -> DB -> release) ---
-	proc := async.NewProcessor(rdb, repo, queue)
-	go proc.Run(ctx)
+	// --- Currency registry (tabel currencies, di-cache; amount API = minor unit) ---
+	currencies := currency.NewRegistry(repo)
+	if err := currencies.Refresh(ctx); err != nil {
+		logger.Fatal("❌ Currency registry: " + err.Error())
+	}
+	logger.Info("✅ Currency registry loaded")
 
-	// --- Start gRPC server ---
+	// --- Start async processor (N worker: Claim head -> DB -> Ack/Nack) ---
+	// Tetap jalan di mode redis untuk menghabiskan sisa q:{user} dari mode queue
+	proc := async.NewProcessor(rdb, repo, queue, currencies)
+	proc.Start(ctx, cfg.Worker.WorkerCount)
+
+	// --- Mode redis: persister stream:wallet -> Postgres ---
+	var persister *async.Persister
+	switch cfg.Worker.WalletMode {
+	case grpcserver.ModeQueue:
+	case grpcserver.ModeRedis:
+		persister = async.NewPersister(walletStore, repo, currencies)
+		persister.Start(ctx)
+	default:
+		logger.Fatal("❌ Unknown WALLET_MODE: " + cfg.Worker.WalletMode)
+	}
+	logger.Infof("✅ Wallet mode: %s", cfg.Worker.WalletMode)
+
+	// jobs: goroutine latar yang memakai DB/Redis, ditunggu sebelum koneksi ditutup
+	var jobs sync.WaitGroup
+
+	if cfg.Worker.CurrencyRefreshInterval > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			currencies.RefreshEvery(ctx, time.Duration(cfg.Worker.CurrencyRefreshInterval)*time.Second)
+		}()
+	}
+
+	// --- Rekonsiliasi periodik Redis ↔ Postgres ---
+	if cfg.Worker.ReconcileInterval > 0 {
+		dir, err := reconcile.ParseDirection(cfg.Worker.ReconcileRepair)
+		if err != nil {
+			logger.Fatal("❌ RECONCILE_REPAIR: " + err.Error())
+		}
+		// Redis → Postgres menulis ADJUSTMENT ke buku besar: hanya lewat `admin reconcile`
+		// yang dijalankan manusia, job periodik cukup melaporkan
+		if dir == reconcile.RepairToPostgres && !cfg.Worker.ReconcileDryRun {
+			logger.Fatal("❌ RECONCILE_REPAIR=postgres requires RECONCILE_DRY_RUN=true; use `admin reconcile -repair postgres -dry-run=false`")
+		}
+		rc := reconcile.New(walletStore, repo)
+		rc.Decimals = currencies.Decimals
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			rc.RunEvery(ctx, time.Duration(cfg.Worker.ReconcileInterval)*time.Second,
+				reconcile.Options{Repair: dir, DryRun: cfg.Worker.ReconcileDryRun})
+		}()
+		logger.Infof("✅ Reconcile every %ds (repair=%s dry_run=%v)", cfg.Worker.ReconcileInterval, dir, cfg.Worker.ReconcileDryRun)
+	}
+
+	// --- Metrics (Prometheus) ---
 	var wg sync.WaitGroup
+	if cfg.App.MetricsPort != "" {
+		metrics.RegisterRedisPool(rdb)
+		if sqlDB, err := dbWrite.DB(); err == nil {
+			metrics.RegisterDBStats("write", sqlDB)
+		}
+		if sqlDB, err := dbRead.DB(); err == nil {
+			metrics.RegisterDBStats("read", sqlDB)
+		}
+		metrics.StartQueueSampler(ctx, queue, 5*time.Second)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metrics.Serve(ctx, ":"+cfg.App.MetricsPort)
+		}()
+	}
+
+	// --- Health + warm-up (mode redis: NOT_SERVING sampai saldo Redis terisi dari Postgres) ---
+	healthServer := health.NewServer()
+	var ready atomic.Bool
+	if cfg.Worker.WalletMode == grpcserver.ModeRedis && cfg.Worker.WarmupEnabled {
+		setServing(healthServer, healthgrpc.HealthCheckResponse_NOT_SERVING)
+		w := warmup.New(walletStore, repo)
+		w.TxWindow = time.Duration(cfg.Worker.WarmupTxWindowHours) * time.Hour
+		w.Decimals = currencies.Decimals
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			st, err := w.RunUntilDone(ctx)
+			if err != nil {
+				return // shutdown sebelum selesai
+			}
+			logger.Infof("✅ Warm-up done wallets=%d balances=%d skipped=%d tx_ids=%d in %s",
+				st.Wallets, st.Balances, st.Skipped, st.TxIDs, st.Duration)
+			ready.Store(true)
+			setServing(healthServer, healthgrpc.HealthCheckResponse_SERVING)
+		}()
+	} else {
+		ready.Store(true)
+		setServing(healthServer, healthgrpc.HealthCheckResponse_SERVING)
+	}
+
+	// --- Start gRPC server ---
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		startGRPCServer(ctx, queue, state.Listener)
+		startGRPCServer(ctx, grpcserver.Deps{
+			Mode:        cfg.Worker.WalletMode,
+			Queue:       queue,
+			Repo:        repo,
+			WalletStore: walletStore,
+			APIVersion:  cfg.App.APIVersion,
+			Ready:       ready.Load,
+			Currencies:  currencies,
+		}, healthServer, state.Listener)
 	}()
 
 	// Block sampai ada signal cancel
 	<-ctx.Done()
 
+	// Drain: tunggu item in-flight selesai sebelum DB/Redis ditutup
+	proc.Wait()
+	if persister != nil {
+		persister.Wait()
+	}
+	jobs.Wait()
+
 	// Cleanup
 	db.CloseDBWrite()
 	db.CloseDBRead()
@@ -88,21 +234,33 @@ func program(state overseer.State) {
 
 	logger.Info("🛑 Waiting for all shutdown gracefully...")
 	wg.Wait()
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Warnf("tracing shutdown: %v", err)
+	}
+	flushCancel()
 	logger.Info("✅ Cleanup done. Exiting.")
 }
 
+// setServing: status health server-wide dan wallet.v1.WalletService
+func setServing(hs *health.Server, st healthgrpc.HealthCheckResponse_ServingStatus) {
+	hs.SetServingStatus("", st)
+	hs.SetServingStatus("wallet.v1.WalletService", st)
+}
+
 // startGRPCServer menjalankan gRPC di listener yang diberikan, lengkap dengan health & reflection.
 // Berhenti gracefully saat ctx.Done().
-func startGRPCServer(ctx context.Context, queue *store.RedisQueue, listener net.Listener) {
-	s := grpc.NewServer()
+func startGRPCServer(ctx context.Context, deps grpcserver.Deps, healthServer *health.Server, listener net.Listener) {
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor()),
+	)
 
-	// Register wallet service (enqueue-only)
-	grpcserver.RegisterWalletService(s, queue)
+	// Register wallet service (write via queue, read via dbRead/cache)
+	grpcserver.RegisterWalletService(s, deps)
 
-	// Health service
-	healthServer := health.NewServer()
-	healthServer.SetServingStatus("", healthgrpc.HealthCheckResponse_SERVING)
-	healthServer.SetServingStatus("wallet.v1.WalletService", healthgrpc.HealthCheckResponse_SERVING)
+	// Health service (status diatur program: warm-up)
 	healthgrpc.RegisterHealthServer(s, healthServer)
 
 	// Reflection (dev tools: grpcurl/evans)
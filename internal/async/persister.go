@@ -0,0 +1,310 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/metrics"
+	"grls/internal/model"
+	"grls/internal/store"
+	"grls/pkg/logger"
+)
+
+// Persister: mode WALLET_MODE=redis. Saldo sudah final di Redis (deposit.lua), persister
+// menyalin event stream:wallet:{wN} ke Postgres lewat consumer group store.EventGroup.
+// Satu goroutine per shard supaya XREADGROUP blocking tidak lintas slot.
+//
+// Checkpoint = last-delivered-id group + PEL: event di-XACK hanya setelah commit, jadi
+// crash di mana pun menyisakan event di PEL dan diulang (idempotent di Postgres lewat
+// unique (user_id, tx_id)). Duplikat yang barisnya lebih tua dari event berarti Redis
+// mengkredit ulang tx_id lama: kredit itu dibatalkan (doubleCredit).
+// PEL milik persister yang mati diambil alih dengan XAUTOCLAIM.
+// Event yang gagal MaxDeliveries kali diparkir ke dlq:events:wallet lalu di-ACK supaya
+// tidak memenuhi PEL selamanya.
+type Persister struct {
+	Store         *store.RedisWalletStore
+	Repo          Repository
+	Currencies    Currencies
+	Consumer      string // nama consumer di group, unik per proses
+	Batch         int64
+	Block         time.Duration
+	DBExecTO      time.Duration
+	RetryInterval time.Duration // jeda sebelum event gagal commit (PEL sendiri) dicoba lagi
+	MaxDeliveries int64         // delivery (XPENDING) sebelum event gagal diparkir; 0 = tidak pernah
+	ClaimIdle     time.Duration // event pending lebih lama dari ini dianggap milik consumer mati
+	ClaimInterval time.Duration
+	LagInterval   time.Duration // interval sampel metrics lag/pending
+
+	wg sync.WaitGroup
+}
+
+func NewPersister(ws *store.RedisWalletStore, repo Repository, cur Currencies) *Persister {
+	host, _ := os.Hostname()
+	return &Persister{
+		Store:         ws,
+		Repo:          repo,
+		Currencies:    cur,
+		Consumer:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+		Batch:         100,
+		Block:         5 * time.Second,
+		DBExecTO:      2 * time.Second,
+		RetryInterval: time.Second,
+		MaxDeliveries: 10,
+		ClaimIdle:     30 * time.Second,
+		ClaimInterval: 10 * time.Second,
+		LagInterval:   5 * time.Second,
+	}
+}
+
+func (p *Persister) Start(ctx context.Context) {
+	for shard := 0; shard < p.Store.Keys.Shards; shard++ {
+		if err := p.Store.EnsureEventGroup(context.Background(), shard); err != nil {
+			logger.Warnf("persister group shard=%d: %v", shard, err)
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(ctx, shard)
+		}()
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.sampleLag(ctx)
+	}()
+	logger.Infof("stream persister started (shards=%d consumer=%s)", p.Store.Keys.Shards, p.Consumer)
+}
+
+// Wait: tunggu batch yang sedang ditulis selesai; panggil sebelum DB ditutup
+func (p *Persister) Wait() {
+	p.wg.Wait()
+	logger.Info("stream persister stopped")
+}
+
+func (p *Persister) run(ctx context.Context, shard int) {
+	// zero time: PEL sisa (restart dengan consumer sama) & klaim dicek di putaran pertama
+	var lastRetry, lastClaim time.Time
+	failed := 0
+	// cursor PEL: tiap retry melanjutkan dari halaman sebelumnya, jadi event gagal di
+	// belakang Batch event pertama tetap dicoba (dan bisa diparkir)
+	cursor := "0"
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if time.Since(lastClaim) >= p.ClaimInterval {
+			lastClaim = time.Now()
+			p.reclaim(shard)
+		}
+		if time.Since(lastRetry) >= p.RetryInterval {
+			lastRetry = time.Now()
+			pending, err := p.Store.PendingEvents(context.Background(), shard, p.Consumer, cursor, p.Batch)
+			if err != nil {
+				logger.Warnf("persister pending shard=%d: %v", shard, err)
+			}
+			failed = p.persistBatch(shard, pending)
+			switch {
+			case err != nil:
+			case int64(len(pending)) < p.Batch:
+				cursor = "0"
+			default:
+				// halaman penuh: masih ada sisa PEL setelahnya
+				cursor = pending[len(pending)-1].ID
+				failed = max(failed, 1)
+			}
+		}
+
+		// sama seperti Processor.run: blocking read tidak ikut ctx shutdown.
+		// Blok dibatasi RetryInterval selama masih ada PEL supaya retry tidak menunggu Block penuh.
+		block := p.Block
+		if failed > 0 {
+			block = min(block, p.RetryInterval)
+		}
+		events, err := p.Store.ReadEvents(context.Background(), shard, p.Consumer, p.Batch, block)
+		if err != nil {
+			logger.Warnf("persister read shard=%d: %v", shard, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		failed += p.persistBatch(shard, events)
+	}
+}
+
+// reclaim: ambil alih PEL consumer yang idle > ClaimIdle lalu bersihkan consumer kosong
+func (p *Persister) reclaim(shard int) {
+	ctx := context.Background()
+	claimed, err := p.Store.ClaimStaleEvents(ctx, shard, p.Consumer, p.ClaimIdle, p.Batch)
+	if err != nil {
+		logger.Warnf("persister reclaim shard=%d: %v", shard, err)
+	}
+	if len(claimed) > 0 {
+		metrics.ReclaimedEvents.Add(float64(len(claimed)))
+		logger.Warnf("persister reclaimed %d idle events shard=%d", len(claimed), shard)
+		p.persistBatch(shard, claimed)
+	}
+	if _, err := p.Store.PruneEventConsumers(ctx, shard, p.Consumer, p.ClaimIdle); err != nil {
+		logger.Warnf("persister prune consumers shard=%d: %v", shard, err)
+	}
+}
+
+// persistBatch: commit per event, ACK yang berhasil sekaligus. Yang gagal tetap di PEL
+// kecuali jatah delivery habis (diparkir); return jumlah yang tetap di PEL.
+func (p *Persister) persistBatch(shard int, events []store.WalletEvent) (failed int) {
+	if len(events) == 0 {
+		return 0
+	}
+	acks := make([]string, 0, len(events))
+	errs := map[string]error{}
+	var failedEvents []store.WalletEvent
+	for _, ev := range events {
+		if err := p.persist(ev); err != nil {
+			metrics.PersistedEvents.WithLabelValues("error").Inc()
+			logger.Errorf("persist err shard=%d id=%s user=%s tx=%s: %v", shard, ev.ID, ev.UserID, ev.TxID, err)
+			errs[ev.ID] = err
+			failedEvents = append(failedEvents, ev)
+			continue
+		}
+		acks = append(acks, ev.ID)
+	}
+	if err := p.Store.AckEvents(context.Background(), shard, acks...); err != nil {
+		// belum ter-ACK → diulang dari PEL, aman karena idempotent
+		logger.Warnf("persister ack shard=%d: %v", shard, err)
+	}
+	return len(failedEvents) - p.deadLetter(shard, failedEvents, errs)
+}
+
+// deadLetter: parkir event gagal yang sudah dikirim >= MaxDeliveries kali (event rusak
+// tanpa menunggu jatah habis); return jumlahnya
+func (p *Persister) deadLetter(shard int, events []store.WalletEvent, errs map[string]error) int {
+	if p.MaxDeliveries <= 0 || len(events) == 0 {
+		return 0
+	}
+	ctx := context.Background()
+	ids := make([]string, len(events))
+	for i, ev := range events {
+		ids[i] = ev.ID
+	}
+	deliveries, err := p.Store.EventDeliveries(ctx, shard, ids...)
+	if err != nil {
+		logger.Warnf("persister deliveries shard=%d: %v", shard, err)
+		return 0
+	}
+	parked := 0
+	for _, ev := range events {
+		n := deliveries[ev.ID]
+		if n < p.MaxDeliveries && !errors.Is(errs[ev.ID], errUnpersistable) {
+			continue
+		}
+		if err := p.Store.DeadLetterEvent(ctx, ev, errs[ev.ID], n); err != nil {
+			logger.Warnf("persister dead-letter shard=%d id=%s: %v", shard, ev.ID, err)
+			continue
+		}
+		parked++
+		metrics.DeadLetteredEvents.Inc()
+		logger.Errorf("persist dead-letter shard=%d id=%s user=%s tx=%s after %d deliveries: %v", shard, ev.ID, ev.UserID, ev.TxID, n, errs[ev.ID])
+	}
+	return parked
+}
+
+func (p *Persister) sampleLag(ctx context.Context) {
+	t := time.NewTicker(p.LagInterval)
+	defer t.Stop()
+	for {
+		for shard := 0; shard < p.Store.Keys.Shards; shard++ {
+			lag, pending, err := p.Store.EventLag(ctx, shard)
+			if err != nil {
+				continue
+			}
+			label := strconv.Itoa(shard)
+			metrics.PersisterLag.WithLabelValues(label).Set(float64(lag))
+			metrics.PersisterPending.WithLabelValues(label).Set(float64(pending))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// errUnpersistable: event yang tidak mungkin ditulis ke Postgres (user_id/type rusak).
+// Saldo Redis sudah berubah, jadi tidak boleh di-ACK diam-diam: langsung diparkir.
+var errUnpersistable = errors.New("not a persistable deposit")
+
+// persist: tulis satu event ke Postgres. nil = boleh di-ACK (termasuk duplikat)
+func (p *Persister) persist(ev store.WalletEvent) error {
+	userID, err := store.ParseUserID(ev.UserID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnpersistable, err)
+	}
+	if ev.Type != "DEPOSIT" {
+		return fmt.Errorf("%w: type %q", errUnpersistable, ev.Type)
+	}
+
+	// saldo Redis sudah berubah: currency yang belum terdaftar tidak di-skip, event
+	// tetap di PEL dan diulang (sampai MaxDeliveries, lalu diparkir)
+	amount, err := p.Currencies.ToDecimal(ev.Currency, ev.Amount)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.DBExecTO)
+	defer cancel()
+	existing, err := p.Repo.UpsertDepositDecimal(ctx, repository.OperationInput{
+		TxID:     ev.TxID,
+		UserID:   userID,
+		Currency: ev.Currency,
+		Amount:   amount,
+		Meta:     ev.Meta,
+	})
+	switch {
+	case err == nil:
+		metrics.PersistedEvents.WithLabelValues("applied").Inc()
+	case errors.Is(err, repository.ErrDuplicateTx) && doubleCredit(ev, existing):
+		return p.reverse(ctx, ev, existing)
+	case errors.Is(err, repository.ErrDuplicateTx):
+		// sudah commit sebelumnya (crash/ACK gagal/diklaim ulang) → cukup ACK
+		metrics.PersistedEvents.WithLabelValues("duplicate").Inc()
+		return nil
+	}
+	return err
+}
+
+// creditSkew: toleransi beda jam Redis (ts event) vs Postgres (created_at)
+const creditSkew = time.Minute
+
+// doubleCredit: baris Postgres lebih tua dari event → bukan replay event ini, tapi tx_id
+// lama yang tidak dikenal Redis (di luar warmup.Warmer.TxWindow setelah Redis kosong)
+// sehingga deposit.lua mengkredit ulang.
+func doubleCredit(ev store.WalletEvent, existing *model.WalletTransaction) bool {
+	if existing == nil || ev.TS == 0 {
+		return false
+	}
+	return existing.CreatedAt.Before(time.UnixMilli(ev.TS).Add(-creditSkew))
+}
+
+// reverse: batalkan kredit Redis yang tidak pernah masuk Postgres; aman diulang dari PEL
+func (p *Persister) reverse(ctx context.Context, ev store.WalletEvent, existing *model.WalletTransaction) error {
+	reversed, bal, err := p.Store.ReverseDeposit(ctx, ev)
+	if err != nil {
+		return fmt.Errorf("reverse double credit: %w", err)
+	}
+	if !reversed {
+		// sudah dibatalkan sebelum crash/ACK gagal
+		metrics.PersistedEvents.WithLabelValues("duplicate").Inc()
+		return nil
+	}
+	metrics.PersistedEvents.WithLabelValues("reversed").Inc()
+	logger.Warnf("persister reversed double credit user=%s cur=%s tx=%s amt=%d (postgres row %s) balance=%d",
+		ev.UserID, ev.Currency, ev.TxID, ev.Amount, existing.CreatedAt.Format(time.RFC3339), bal)
+	return nil
+}
@@ -0,0 +1,310 @@
+package async
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/store"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+func newTestWalletStore(t *testing.T, shards int) (*store.RedisWalletStore, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return store.NewRedisWalletStore(rdb, shards), rdb
+}
+
+func newTestPersister(ws *store.RedisWalletStore, repo Repository) *Persister {
+	p := NewPersister(ws, repo, testCurrencies)
+	p.Consumer = "test"
+	p.Block = 20 * time.Millisecond
+	p.RetryInterval = 5 * time.Millisecond
+	p.ClaimInterval = 5 * time.Millisecond
+	p.LagInterval = 5 * time.Millisecond
+	return p
+}
+
+func startPersister(t *testing.T, p *Persister) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	t.Cleanup(func() { cancel(); p.Wait() })
+}
+
+func pendingEvents(t *testing.T, rdb redis.UniversalClient, ws *store.RedisWalletStore) int64 {
+	t.Helper()
+	var n int64
+	for shard := 0; shard < ws.Keys.Shards; shard++ {
+		pend, err := rdb.XPending(context.Background(), ws.StreamKeyName(shard), store.EventGroup).Result()
+		if err != nil {
+			t.Fatalf("xpending shard %d: %v", shard, err)
+		}
+		n += pend.Count
+	}
+	return n
+}
+
+func TestPersisterCopiesStreamEventsToRepository(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 2)
+	repo := repository.NewMemoryWalletRepository()
+	ctx := context.Background()
+
+	// event sebelum persister jalan tetap ikut (group mulai dari "0")
+	for _, d := range []struct {
+		user, tx string
+		amt      int64
+	}{{"1", "a", 10}, {"2", "b", 20}, {"1", "a", 10}} {
+		if _, err := ws.Deposit(ctx, d.user, "USD", d.tx, d.amt, map[string]any{"src": "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	startPersister(t, newTestPersister(ws, repo))
+
+	if _, err := ws.Deposit(ctx, "1", "USD", "c", 5, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "3 persisted deposits", func() bool { return len(repo.Applied()) == 3 })
+	if got := appliedTxIDs(repo, 1); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("user 1 tx = %v, want [a c]", got)
+	}
+	if bal := repo.Balance(1, "USD"); !bal.Equal(decimal.RequireFromString("0.15")) {
+		t.Fatalf("user 1 balance = %s, want 0.15 (15 cents)", bal)
+	}
+
+	// semua event di-ACK setelah commit
+	waitFor(t, "empty PEL", func() bool { return pendingEvents(t, rdb, ws) == 0 })
+}
+
+func TestPersisterRetriesFailedCommitFromPEL(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 1)
+	repo := repository.NewMemoryWalletRepository()
+
+	var mu sync.Mutex
+	failures := 0
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if in.TxID == "a" && failures < 2 {
+			failures++
+			return errDBDown
+		}
+		return nil
+	}
+	if _, err := ws.Deposit(context.Background(), "1", "USD", "a", 10, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	startPersister(t, newTestPersister(ws, repo))
+	waitFor(t, "deposit persisted after retries", func() bool { return len(repo.Applied()) == 1 })
+	waitFor(t, "empty PEL", func() bool { return pendingEvents(t, rdb, ws) == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if failures != 2 {
+		t.Fatalf("failures = %d, want 2", failures)
+	}
+}
+
+func TestPersisterReclaimsEventsFromDeadConsumer(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 1)
+	repo := repository.NewMemoryWalletRepository()
+	ctx := context.Background()
+
+	for _, tx := range []string{"a", "b"} {
+		if _, err := ws.Deposit(ctx, "1", "USD", tx, 10, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// persister lain membaca lalu mati sebelum commit/ACK
+	if err := ws.EnsureEventGroup(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if evs, err := ws.ReadEvents(ctx, 0, "dead", 10, -1); err != nil || len(evs) != 2 {
+		t.Fatalf("dead consumer read = %d, %v; want 2", len(evs), err)
+	}
+
+	p := newTestPersister(ws, repo)
+	p.ClaimIdle = 10 * time.Millisecond
+	startPersister(t, p)
+
+	waitFor(t, "reclaimed deposits persisted", func() bool { return len(repo.Applied()) == 2 })
+	waitFor(t, "empty PEL", func() bool { return pendingEvents(t, rdb, ws) == 0 })
+}
+
+func TestPersisterRetryWalksPELBeyondBatch(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 1)
+	repo := repository.NewMemoryWalletRepository()
+
+	// a, b, c selalu gagal (lebih dari Batch); d hanya gagal sekali
+	var mu sync.Mutex
+	dFailed := false
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case in.TxID != "d":
+			return errDBDown
+		case !dFailed:
+			dFailed = true
+			return errDBDown
+		}
+		return nil
+	}
+	for _, tx := range []string{"a", "b", "c", "d"} {
+		if _, err := ws.Deposit(context.Background(), "1", "USD", tx, 10, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newTestPersister(ws, repo)
+	p.Batch = 2
+	p.MaxDeliveries = 0
+	p.ClaimInterval = time.Hour // hanya jalur retry PEL yang diuji
+	startPersister(t, p)
+
+	waitFor(t, "d persisted past failing PEL head", func() bool { return len(repo.Applied()) == 1 })
+	if got := pendingEvents(t, rdb, ws); got != 3 {
+		t.Fatalf("pending = %d, want 3 (a, b, c)", got)
+	}
+}
+
+func TestPersisterDeadLettersAfterMaxDeliveries(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 2)
+	repo := repository.NewMemoryWalletRepository()
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		if in.TxID != "ok" {
+			return errDBDown
+		}
+		return nil
+	}
+	ctx := context.Background()
+	for _, tx := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := ws.Deposit(ctx, "1", "USD", tx, 10, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newTestPersister(ws, repo)
+	p.Batch = 2
+	p.MaxDeliveries = 3
+	startPersister(t, p)
+
+	var dead []store.DeadEvent
+	waitFor(t, "5 dead-lettered events", func() bool {
+		dead, _ = ws.ListDeadEvents(ctx, 100)
+		return len(dead) == 5
+	})
+	if got := pendingEvents(t, rdb, ws); got != 0 {
+		t.Fatalf("pending = %d, want 0 (parked events are acked)", got)
+	}
+	for _, de := range dead {
+		if de.Deliveries < 3 || de.Error == "" || de.UserID != "1" || de.Amount != 10 {
+			t.Fatalf("dead event = %+v", de)
+		}
+	}
+
+	// event baru tetap jalan setelah PEL bersih
+	if _, err := ws.Deposit(ctx, "1", "USD", "ok", 10, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "new deposit persisted", func() bool { return len(repo.Applied()) == 1 })
+}
+
+func TestPersisterParksUnpersistableEventsInsteadOfAcking(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 1)
+	repo := repository.NewMemoryWalletRepository()
+	ctx := context.Background()
+
+	// saldo Redis sudah berubah tapi event tidak bisa ditulis ke Postgres
+	for _, v := range []map[string]any{
+		{"type": "DEPOSIT", "user_id": "abc", "currency": "USD", "tx_id": "a", "amount": "10"},
+		{"type": "REFUND", "user_id": "1", "currency": "USD", "tx_id": "b", "amount": "10"},
+	} {
+		if err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: ws.StreamKeyName(0), Values: v}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// parkir nonaktif: event tetap di PEL, tidak di-ACK
+	p := newTestPersister(ws, repo)
+	p.MaxDeliveries = 0
+	runCtx, cancel := context.WithCancel(ctx)
+	p.Start(runCtx)
+	waitFor(t, "bad events delivered", func() bool {
+		lag, pending, err := ws.EventLag(ctx, 0)
+		return err == nil && lag == 0 && pending == 2
+	})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	p.Wait()
+	if got := pendingEvents(t, rdb, ws); got != 2 {
+		t.Fatalf("pending = %d, want 2 (bad events must not be acked)", got)
+	}
+
+	// parkir aktif: langsung ke dlq:events:wallet tanpa menunggu MaxDeliveries
+	p = newTestPersister(ws, repo)
+	p.MaxDeliveries = 100
+	startPersister(t, p)
+	var dead []store.DeadEvent
+	waitFor(t, "bad events parked", func() bool {
+		dead, _ = ws.ListDeadEvents(ctx, 10)
+		return len(dead) == 2
+	})
+	waitFor(t, "empty PEL", func() bool { return pendingEvents(t, rdb, ws) == 0 })
+	for _, de := range dead {
+		if de.Error == "" || de.Deliveries >= 100 {
+			t.Fatalf("dead event = %+v", de)
+		}
+	}
+	if len(repo.Applied()) != 0 {
+		t.Fatalf("applied = %v, want none", repo.Applied())
+	}
+}
+
+func TestPersisterReversesDoubleCreditOfOldTxID(t *testing.T) {
+	ws, rdb := newTestWalletStore(t, 1)
+	repo := repository.NewMemoryWalletRepository()
+	ctx := context.Background()
+
+	// "old" commit di Postgres jauh sebelum jendela warmup; "recent" baru saja (dalam creditSkew)
+	repo.Now = func() time.Time { return time.Now().Add(-100 * time.Hour) }
+	in := repository.OperationInput{UserID: 1, Currency: "USD", TxID: "old", Amount: decimal.RequireFromString("0.10")}
+	if _, err := repo.UpsertDepositDecimal(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+	repo.Now = nil
+	in.TxID = "recent"
+	if _, err := repo.UpsertDepositDecimal(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis kosong: kedua tx_id tidak dikenal deposit.lua dan dikredit lagi
+	for _, tx := range []string{"old", "recent", "new"} {
+		if _, err := ws.Deposit(ctx, "1", "USD", tx, 10, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	startPersister(t, newTestPersister(ws, repo))
+	waitFor(t, "empty PEL", func() bool { return len(repo.Applied()) == 3 && pendingEvents(t, rdb, ws) == 0 })
+
+	// hanya kredit "old" yang dibatalkan; "recent" dianggap replay biasa
+	if bal, _, _ := ws.GetBalance(ctx, "1", "USD"); bal != 20 {
+		t.Fatalf("redis balance = %d, want 20", bal)
+	}
+	// sekali saja, dan retry client tetap duplikat
+	if reversed, _, err := ws.ReverseDeposit(ctx, store.WalletEvent{UserID: "1", Currency: "USD", TxID: "old", Amount: 10}); err != nil || reversed {
+		t.Fatalf("second reverse = %v, %v; want no-op", reversed, err)
+	}
+	if res, err := ws.Deposit(ctx, "1", "USD", "old", 10, nil); err != nil || res.Code != 0 || res.Balance != 20 {
+		t.Fatalf("retry old = %+v, %v; want duplicate at 20", res, err)
+	}
+}
@@ -3,41 +3,138 @@ package async
 import (
 	"context"
 	"encoding/json"
-	"strconv"
+	"errors"
+	"fmt"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"grls/internal/currency"
 	"grls/internal/infrastructure/repository"
+	"grls/internal/metrics"
+	"grls/internal/model"
 	"grls/internal/store"
+	"grls/internal/tracing"
 	"grls/pkg/logger"
 )
 
+// Repository: operasi tulis yang dipakai processor.
+// Implementasi: *repository.WalletRepository (Postgres), *repository.MemoryWalletRepository (test).
+type Repository interface {
+	UpsertDepositDecimal(ctx context.Context, in repository.OperationInput) (*model.WalletTransaction, error)
+	WithdrawDecimal(ctx context.Context, in repository.OperationInput) (*model.WalletTransaction, error)
+	TransferDecimal(ctx context.Context, in repository.OperationInput) (*model.WalletTransaction, error)
+	SaveQuarantined(ctx context.Context, q model.QuarantinedPayload) error
+}
+
+var (
+	_ Repository = (*repository.WalletRepository)(nil)
+	_ Repository = (*repository.MemoryWalletRepository)(nil)
+)
+
+// Currencies: konversi amount API (minor unit) ke NUMERIC major unit.
+// Implementasi: *currency.Registry.
+type Currencies interface {
+	ToDecimal(code string, minor int64) (decimal.Decimal, error)
+}
+
+var _ Currencies = (*currency.Registry)(nil)
+
 type Processor struct {
-	Rdb        redis.UniversalClient
-	Repo       *repository.WalletRepository
-	Queue      *store.RedisQueue
-	BRPopBlock time.Duration
-	DBExecTO   time.Duration
+	Rdb               redis.UniversalClient
+	Repo              Repository
+	Queue             store.Queue
+	Currencies        Currencies
+	PopBlock          time.Duration
+	DBExecTO          time.Duration
+	ReapInterval      time.Duration
+	RetryPoll         time.Duration // interval cek retry yang jatuh tempo
+	RenewInterval     time.Duration // harus < lease TTL backend (default 10s)
+	HeartbeatInterval time.Duration // harus < heartbeat TTL backend (default 15s)
+	InstanceID        string        // prefix token worker, unik per proses
+
+	wg sync.WaitGroup
 }
 
-func NewProcessor(rdb redis.UniversalClient, repo *repository.WalletRepository, q *store.RedisQueue) *Processor {
+func NewProcessor(rdb redis.UniversalClient, repo Repository, q store.Queue, cur Currencies) *Processor {
+	host, _ := os.Hostname()
 	return &Processor{
-		Rdb:        rdb,
-		Repo:       repo,
-		Queue:      q,
-		BRPopBlock: 5 * time.Second,
-		DBExecTO:   2 * time.Second,
+		Rdb:               rdb,
+		Repo:              repo,
+		Queue:             q,
+		Currencies:        cur,
+		PopBlock:          5 * time.Second,
+		DBExecTO:          2 * time.Second,
+		ReapInterval:      5 * time.Second,
+		RetryPoll:         100 * time.Millisecond,
+		RenewInterval:     3 * time.Second,
+		HeartbeatInterval: 5 * time.Second,
+		InstanceID:        fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+}
+
+// Start: jalankan n worker yang Claim head bersamaan + heartbeat + reaper + retry poller.
+// FIFO per user tetap terjaga karena backend Queue hanya menyerahkan head user ke
+// satu worker pemegang lease pada satu waktu.
+func (p *Processor) Start(ctx context.Context, n int) {
+	n = max(n, 1)
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("%s#%d", p.InstanceID, i)
+	}
+	// daftar dulu sebelum Claim pertama supaya in-flight worker tidak dianggap milik worker mati
+	if err := p.Queue.Heartbeat(context.Background(), tokens...); err != nil {
+		logger.Warnf("heartbeat register err: %v", err)
 	}
+
+	var workers sync.WaitGroup
+	workers.Add(n)
+	for _, token := range tokens {
+		go func() {
+			defer workers.Done()
+			p.run(ctx, token)
+		}()
+	}
+
+	done := make(chan struct{})
+	p.wg.Add(4)
+	go func() {
+		defer p.wg.Done()
+		workers.Wait()
+		close(done)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.heartbeat(done, tokens)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.reap(ctx)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.promoteRetries(ctx)
+	}()
+	logger.Infof("async processor started (workers=%d)", n)
 }
 
-func (p *Processor) Run(ctx context.Context) {
-	logger.Info("async processor started")
-	defer logger.Info("async processor stopped")
+// Wait: tunggu semua worker selesai (item in-flight sudah commit & release).
+// Panggil setelah ctx di-cancel dan sebelum koneksi DB/Redis ditutup.
+func (p *Processor) Wait() {
+	p.wg.Wait()
+	logger.Info("async processor stopped")
+}
 
-	readyKey := p.Queue.ReadyKeyName()
+func (p *Processor) run(ctx context.Context, token string) {
+	logger.Debugf("worker %s started", token)
+	defer logger.Debugf("worker %s stopped", token)
 
 	for {
 		select {
@@ -46,73 +143,279 @@ func (p *Processor) Run(ctx context.Context) {
 		default:
 		}
 
-		// Ambil queue user yang siap (res[1] = "q:{user}")
-		res, err := p.Rdb.BRPop(ctx, p.BRPopBlock, readyKey).Result()
-		if err == redis.Nil {
+		// Sengaja tidak pakai ctx shutdown: blocking pop yang dibatalkan di tengah bisa
+		// meninggalkan item in-flight tanpa pemilik. Shutdown menunggu maks PopBlock.
+		claimStart := time.Now()
+		d, err := p.Queue.Claim(context.Background(), token, p.PopBlock)
+		if errors.Is(err, store.ErrNoDelivery) {
 			continue
 		}
 		if err != nil {
-			logger.Warnf("BRPOP err: %v", err)
-			continue
-		}
-		if len(res) != 2 {
-			continue
-		}
-		qKey := res[1]
-		user, ok := parseUserFromQueueKey(qKey)
-		if !ok {
-			logger.Warnf("cannot parse user from key=%s", qKey)
+			logger.Warnf("claim err: %v", err)
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
-		// Baca head payload (tanpa pop)
-		head, err := p.Rdb.LIndex(ctx, qKey, 0).Result()
-		if err == redis.Nil || head == "" {
-			continue
+		p.handle(d, claimStart)
+	}
+}
+
+// handle: proses satu head q:{user} di bawah lease, diakhiri tepat satu Ack/Nack/Quarantine.
+// Kalau worker mati di mana pun setelah Claim, lease expire dan backend mempromosikan ulang.
+func (p *Processor) handle(d *store.Delivery, claimStart time.Time) {
+	claimEnd := time.Now()
+	stop := p.keepAlive(d)
+	defer stop()
+
+	var payload store.OperationPayload
+	if err := json.Unmarshal([]byte(d.Raw), &payload); err != nil {
+		logger.Errorf("JSON decode err user=%s head=%q: %v", d.UserID, d.Raw, err)
+		metrics.DecodeFailures.Inc()
+		// karantina item buruk agar tidak macet (tetap tersimpan untuk audit/perbaikan)
+		p.quarantine(d, err)
+		return
+	}
+	d.TxID = payload.TxID
+	op := string(payload.Op())
+
+	// span processor jadi child dari span enqueue di RPC asal (trace context dibawa di payload)
+	ctx, span := p.startSpans(payload, claimStart, claimEnd)
+	defer span.End()
+
+	// Commit ke DB (minor unit → decimal lewat registry currency)
+	dbCtx, cancel := context.WithTimeout(ctx, p.DBExecTO)
+	start := time.Now()
+	rec, err := p.apply(dbCtx, payload)
+	metrics.DBExecLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	cancel()
+	if err != nil {
+		span.RecordError(err)
+	}
+	switch {
+	case err == nil:
+		metrics.ProcessedOps.WithLabelValues(op, "applied").Inc()
+		p.setStatus(payload, store.OpApplied, "")
+	case errors.Is(err, repository.ErrDuplicateTx):
+		metrics.ProcessedOps.WithLabelValues(op, "duplicate").Inc()
+		// sudah pernah commit (mis. crash sebelum release) → jangan apply ulang, pakai hasil asli
+		logger.Warnf("duplicate %s user=%s tx=%s: %s", payload.Op(), payload.UserID, payload.TxID, rec.Status)
+		p.setStatus(payload, store.OpState(rec.Status), rec.Error)
+	case isPermanent(err):
+		// gagal permanen (bisnis): jangan retry, lanjut ke item berikutnya
+		metrics.ProcessedOps.WithLabelValues(op, "rejected").Inc()
+		logger.Warnf("rejected %s user=%s cur=%s amt=%d tx=%s: %v", payload.Op(), payload.UserID, payload.Currency, payload.Amount, payload.TxID, err)
+		p.setStatus(payload, store.OpFailed, err.Error())
+	default:
+		logger.Errorf("DB err %s user=%s cur=%s amt=%d tx=%s: %v", payload.Op(), payload.UserID, payload.Currency, payload.Amount, payload.TxID, err)
+		span.SetStatus(codes.Error, err.Error())
+		p.retryOrDeadLetter(ctx, d, payload, err)
+		return
+	}
+
+	// Sukses (atau ditolak permanen) → release & promote
+	if err := p.Queue.Ack(ctx, d); err != nil {
+		// ErrLeaseLost: lease sempat expire dan diambil worker lain; head diproses ulang (aman: idempotent di DB)
+		logger.Warnf("release warn user=%s: %v", d.UserID, err)
+		if errors.Is(err, store.ErrLeaseLost) {
+			metrics.ProcessedOps.WithLabelValues(op, "lease_lost").Inc()
 		}
-		if err != nil {
-			logger.Warnf("LINDEX err user=%s: %v", user, err)
-			continue
+	}
+}
+
+// retryOrDeadLetter: jadwalkan ulang head dengan backoff; kalau jatah percobaan habis,
+// backend memarkir head ke dlq:wallet supaya item berikutnya milik user tidak ikut macet.
+func (p *Processor) retryOrDeadLetter(ctx context.Context, d *store.Delivery, payload store.OperationPayload, cause error) {
+	res, err := p.Queue.Nack(ctx, d, cause)
+	if err != nil {
+		logger.Warnf("nack warn user=%s tx=%s: %v", d.UserID, d.TxID, err)
+		return
+	}
+	if !res.DeadLettered {
+		logger.Warnf("retry #%d user=%s tx=%s in %s", res.Attempts, d.UserID, d.TxID, res.Backoff)
+		metrics.Retries.Inc()
+		metrics.ProcessedOps.WithLabelValues(string(payload.Op()), "retry").Inc()
+		return
+	}
+	metrics.ProcessedOps.WithLabelValues(string(payload.Op()), "dead_letter").Inc()
+	logger.Errorf("dead-letter user=%s tx=%s after %d attempts: %v", d.UserID, d.TxID, res.Attempts, cause)
+	p.setStatus(payload, store.OpFailed, store.DeadLetterReasonPrefix+cause.Error())
+}
+
+// quarantine: parkir head rusak ke quarantine:wallet + salinan audit di Postgres.
+// Salinan Postgres best effort: record Redis tetap jadi sumber untuk reinject.
+func (p *Processor) quarantine(d *store.Delivery, cause error) {
+	rec, err := p.Queue.Quarantine(context.Background(), d, cause)
+	if err != nil {
+		logger.Warnf("quarantine warn user=%s: %v", d.UserID, err)
+		return
+	}
+
+	dbCtx, cancel := context.WithTimeout(context.Background(), p.DBExecTO)
+	defer cancel()
+	err = p.Repo.SaveQuarantined(dbCtx, model.QuarantinedPayload{
+		QuarantineID: rec.ID,
+		UserID:       rec.UserID,
+		RawPayload:   rec.Raw,
+		Error:        rec.Error,
+		Status:       model.QuarantineStatusQuarantined,
+		CreatedAt:    time.UnixMilli(rec.QuarantinedAt),
+	})
+	if err != nil {
+		logger.Warnf("quarantine audit warn id=%s: %v", rec.ID, err)
+	}
+}
+
+// startSpans: span "queue.claim" (waktu blocking Claim, dicatat mundur setelah trace context
+// diketahui dari payload) dan span "processor.handle" untuk apply + ack
+func (p *Processor) startSpans(payload store.OperationPayload, claimStart, claimEnd time.Time) (context.Context, trace.Span) {
+	parent := tracing.Extract(context.Background(), payload.Trace)
+	attrs := []attribute.KeyValue{
+		attribute.String("wallet.op", string(payload.Op())),
+		attribute.String("wallet.user_id", payload.UserID),
+		attribute.String("wallet.tx_id", payload.TxID),
+	}
+
+	_, claim := tracing.Tracer().Start(parent, "queue.claim",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(claimStart),
+		trace.WithAttributes(attrs...))
+	claim.End(trace.WithTimestamp(claimEnd))
+
+	return tracing.Tracer().Start(parent, "processor.handle",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+}
+
+// keepAlive: perpanjang lease tiap RenewInterval sampai stop dipanggil
+func (p *Processor) keepAlive(d *store.Delivery) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(p.RenewInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				ok, err := p.Queue.Renew(context.Background(), d)
+				if err != nil || !ok {
+					logger.Warnf("lease renew failed user=%s ok=%v: %v", d.UserID, ok, err)
+				}
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
 
-		var payload store.DepositPayload
-		if err := json.Unmarshal([]byte(head), &payload); err != nil {
-			logger.Errorf("JSON decode err user=%s head=%q: %v", user, head, err)
-			// buang item buruk agar tidak macet
-			_, _ = p.Queue.ReleaseAndPromote(context.Background(), user)
-			continue
+// heartbeat: perbarui liveness worker sampai semua worker berhenti, lalu unregister
+func (p *Processor) heartbeat(done <-chan struct{}, tokens []string) {
+	t := time.NewTicker(p.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			for _, token := range tokens {
+				if err := p.Queue.Unregister(context.Background(), token); err != nil {
+					logger.Warnf("unregister warn worker=%s: %v", token, err)
+				}
+			}
+			return
+		case <-t.C:
+			if err := p.Queue.Heartbeat(context.Background(), tokens...); err != nil {
+				logger.Warnf("heartbeat err: %v", err)
+			}
 		}
+	}
+}
 
-		// Commit ke DB (as-is integer → decimal)
-		dbCtx, cancel := context.WithTimeout(context.Background(), p.DBExecTO)
-		err = p.Repo.UpsertDepositDecimal(dbCtx, mustParseInt64(payload.UserID), strings.ToUpper(payload.Currency), decimal.NewFromInt(payload.Amount))
-		cancel()
-		if err != nil {
-			logger.Errorf("DB err user=%s cur=%s amt=%d tx=%s: %v", payload.UserID, payload.Currency, payload.Amount, payload.TxID, err)
-			// retry: dorong lagi qKey ke ready agar diambil ulang setelah jeda
-			_ = p.Rdb.LPush(context.Background(), readyKey, qKey).Err()
-			time.Sleep(20 * time.Millisecond)
-			continue
+// reap: periodik pulihkan head milik worker mati (processing list / lease expire)
+func (p *Processor) reap(ctx context.Context) {
+	t := time.NewTicker(p.ReapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := p.Queue.Reap(ctx)
+			if err != nil {
+				logger.Warnf("reaper err: %v", err)
+			}
+			if n > 0 {
+				logger.Warnf("reaper re-promoted %d orphaned queue(s)", n)
+			}
 		}
+	}
+}
 
-		// Sukses → release & promote
-		if _, err := p.Queue.ReleaseAndPromote(context.Background(), user); err != nil {
-			logger.Warnf("release warn user=%s: %v", user, err)
+// promoteRetries: dorong antrian user yang backoff retry-nya sudah lewat
+func (p *Processor) promoteRetries(ctx context.Context) {
+	t := time.NewTicker(p.RetryPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := p.Queue.PromoteDue(ctx); err != nil && ctx.Err() == nil {
+				logger.Warnf("retry promote err: %v", err)
+			}
 		}
 	}
 }
 
-func parseUserFromQueueKey(qKey string) (string, bool) {
-	// ekspektasi: "q:{<user>}"
-	i := strings.Index(qKey, "{")
-	j := strings.LastIndex(qKey, "}")
-	if i == -1 || j == -1 || j <= i+1 {
-		return "", false
+// setStatus: best effort, kegagalan update status tidak boleh memblok antrian
+func (p *Processor) setStatus(payload store.OperationPayload, state store.OpState, reason string) {
+	if err := p.Queue.SetOpStatus(context.Background(), payload, state, reason); err != nil {
+		logger.Warnf("status warn user=%s tx=%s state=%s: %v", payload.UserID, payload.TxID, state, err)
+	}
+}
+
+var errUnknownOp = errors.New("unknown operation type")
+
+// apply: dispatch operasi ke repository sesuai type
+func (p *Processor) apply(ctx context.Context, payload store.OperationPayload) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(payload.Currency)
+	amount, err := p.Currencies.ToDecimal(cur, payload.Amount)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := store.ParseUserID(payload.UserID)
+	if err != nil {
+		return nil, err
+	}
+	in := repository.OperationInput{
+		TxID:     payload.TxID,
+		UserID:   userID,
+		Currency: cur,
+		Amount:   amount,
+		Meta:     payload.Meta,
+	}
+
+	switch payload.Op() {
+	case store.OpDeposit:
+		return p.Repo.UpsertDepositDecimal(ctx, in)
+	case store.OpWithdraw:
+		return p.Repo.WithdrawDecimal(ctx, in)
+	case store.OpTransfer:
+		// jangan sampai to_user_id rusak jadi 0 dan dana masuk ke wallet user 0
+		if in.ToUserID, err = store.ParseUserID(payload.ToUserID); err != nil {
+			return nil, fmt.Errorf("to_user_id: %w", err)
+		}
+		return p.Repo.TransferDecimal(ctx, in)
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownOp, payload.Type)
 	}
-	return qKey[i+1 : j], true
 }
 
-func mustParseInt64(s string) int64 {
-	n, _ := strconv.ParseInt(s, 10, 64)
-	return n
+// isPermanent: error yang tidak akan berubah walau di-retry
+func isPermanent(err error) bool {
+	return repository.IsRejection(err) || errors.Is(err, errUnknownOp) || errors.Is(err, currency.ErrUnknown) ||
+		errors.Is(err, store.ErrInvalidUserID)
 }
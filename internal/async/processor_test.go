@@ -0,0 +1,444 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grls/internal/config"
+	"grls/internal/currency"
+	"grls/internal/infrastructure/repository"
+	"grls/internal/model"
+	"grls/internal/store"
+	"grls/internal/tracing"
+
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errDBDown = errors.New("db down")
+
+// testCurrencies: USD 2 desimal, amount test dalam sen
+var testCurrencies = currency.NewStatic(model.Currency{Code: "USD", Decimals: 2, Enabled: true})
+
+func newTestProcessor(q store.Queue, repo Repository) *Processor {
+	p := NewProcessor(nil, repo, q, testCurrencies)
+	p.PopBlock = 20 * time.Millisecond
+	p.ReapInterval = 10 * time.Millisecond
+	p.RetryPoll = 2 * time.Millisecond
+	p.RenewInterval = 5 * time.Millisecond
+	p.HeartbeatInterval = 10 * time.Millisecond
+	p.InstanceID = "test"
+	return p
+}
+
+func newTestQueue() *store.MemoryQueue {
+	q := store.NewMemoryQueue()
+	q.RetryBaseBackoff = time.Millisecond
+	q.RetryMaxBackoff = 5 * time.Millisecond
+	return q
+}
+
+// start: jalankan processor, stop dipanggil otomatis di akhir test
+func start(t *testing.T, p *Processor, workers int) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx, workers)
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			p.Wait()
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func enqueue(t *testing.T, q store.Queue, p store.OperationPayload) {
+	t.Helper()
+	if p.Currency == "" {
+		p.Currency = "USD"
+	}
+	if _, err := q.Enqueue(context.Background(), p); err != nil {
+		t.Fatalf("enqueue %s: %v", p.TxID, err)
+	}
+}
+
+func deposit(user, txID string, amount int64) store.OperationPayload {
+	return store.OperationPayload{Type: store.OpDeposit, UserID: user, Amount: amount, TxID: txID}
+}
+
+func appliedTxIDs(repo *repository.MemoryWalletRepository, userID int64) []string {
+	var out []string
+	for _, tx := range repo.Applied() {
+		if tx.UserID == userID {
+			out = append(out, tx.TxID)
+		}
+	}
+	return out
+}
+
+func opState(t *testing.T, q store.Queue, user, txID string) *store.OpStatus {
+	t.Helper()
+	st, err := q.GetOpStatus(context.Background(), user, txID)
+	if err != nil {
+		t.Fatalf("op status %s/%s: %v", user, txID, err)
+	}
+	return st
+}
+
+func TestProcessorKeepsPerUserOrder(t *testing.T) {
+	const users, perUser = 5, 20
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	// deteksi dua worker memproses user yang sama bersamaan
+	var (
+		mu       sync.Mutex
+		inflight = map[int64]bool{}
+		overlap  bool
+	)
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		mu.Lock()
+		if inflight[in.UserID] {
+			overlap = true
+		}
+		inflight[in.UserID] = true
+		mu.Unlock()
+
+		time.Sleep(100 * time.Microsecond)
+
+		mu.Lock()
+		inflight[in.UserID] = false
+		mu.Unlock()
+		return nil
+	}
+
+	for i := 0; i < perUser; i++ {
+		for u := 1; u <= users; u++ {
+			enqueue(t, q, deposit(strconv.Itoa(u), fmt.Sprintf("tx-%02d", i), 1))
+		}
+	}
+
+	start(t, newTestProcessor(q, repo), 4)
+	waitFor(t, "all deposits applied", func() bool { return len(repo.Applied()) == users*perUser })
+
+	for u := 1; u <= users; u++ {
+		got := appliedTxIDs(repo, int64(u))
+		for i, txID := range got {
+			if want := fmt.Sprintf("tx-%02d", i); txID != want {
+				t.Fatalf("user %d: position %d = %s, want %s (order %v)", u, i, txID, want, got)
+			}
+		}
+		if bal := repo.Balance(int64(u), "USD"); !bal.Equal(decimal.New(perUser, -2)) {
+			t.Fatalf("user %d: balance %s, want %d cents", u, bal, perUser)
+		}
+		waitFor(t, "queue unlocked", func() bool { return !q.Locked(strconv.Itoa(u)) })
+	}
+	if overlap {
+		t.Fatal("same user processed by two workers concurrently")
+	}
+}
+
+func TestProcessorRetriesTransientErrorsInOrder(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	var mu sync.Mutex
+	failures := 0
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if in.TxID == "b" && failures < 2 {
+			failures++
+			return errDBDown
+		}
+		return nil
+	}
+
+	for _, tx := range []string{"a", "b", "c"} {
+		enqueue(t, q, deposit("1", tx, 10))
+	}
+
+	start(t, newTestProcessor(q, repo), 2)
+	waitFor(t, "all deposits applied", func() bool { return len(repo.Applied()) == 3 })
+
+	if got := strings.Join(appliedTxIDs(repo, 1), ","); got != "a,b,c" {
+		t.Fatalf("applied order = %s, want a,b,c", got)
+	}
+	if failures != 2 {
+		t.Fatalf("failures = %d, want 2", failures)
+	}
+	if n := q.Attempts("1", "b"); n != 0 {
+		t.Fatalf("attempts for b = %d after success, want 0", n)
+	}
+	if st := opState(t, q, "1", "b"); st.State != store.OpApplied {
+		t.Fatalf("state b = %s, want APPLIED", st.State)
+	}
+}
+
+func TestProcessorDeadLettersAfterMaxAttempts(t *testing.T) {
+	q := newTestQueue()
+	q.MaxAttempts = 3
+	repo := repository.NewMemoryWalletRepository()
+
+	var mu sync.Mutex
+	calls := 0
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		if in.TxID != "bad" {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return errDBDown
+	}
+
+	enqueue(t, q, deposit("1", "bad", 10))
+	enqueue(t, q, deposit("1", "good", 10))
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "next item applied", func() bool { return len(repo.Applied()) == 1 })
+
+	dl, ok := q.DeadLetters()["1:bad"]
+	if !ok {
+		t.Fatal("bad payload not in DLQ")
+	}
+	if dl.Attempts != 3 || dl.Error != errDBDown.Error() {
+		t.Fatalf("dead letter = %+v, want 3 attempts with %q", dl, errDBDown)
+	}
+	if calls != 3 {
+		t.Fatalf("repository called %d times for bad, want 3", calls)
+	}
+	if st := opState(t, q, "1", "bad"); st.State != store.OpFailed || !strings.HasPrefix(st.Error, "dead-lettered") {
+		t.Fatalf("status bad = %+v, want FAILED dead-lettered", st)
+	}
+	if got := appliedTxIDs(repo, 1); len(got) != 1 || got[0] != "good" {
+		t.Fatalf("applied = %v, want [good]", got)
+	}
+}
+
+func TestProcessorDoesNotRetryRejections(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[in.TxID]++
+		return nil
+	}
+
+	enqueue(t, q, store.OperationPayload{Type: store.OpWithdraw, UserID: "1", Amount: 50, TxID: "w1"})
+	enqueue(t, q, deposit("1", "d1", 10))
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "deposit applied", func() bool { return len(repo.Applied()) == 1 })
+
+	if st := opState(t, q, "1", "w1"); st.State != store.OpFailed || st.Error != repository.ErrInsufficientFunds.Error() {
+		t.Fatalf("status w1 = %+v, want FAILED insufficient funds", st)
+	}
+	if calls["w1"] != 1 {
+		t.Fatalf("withdraw attempted %d times, want 1", calls["w1"])
+	}
+	if len(q.DeadLetters()) != 0 {
+		t.Fatal("rejection must not be dead-lettered")
+	}
+}
+
+func TestProcessorRejectsUnknownCurrency(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	enqueue(t, q, store.OperationPayload{Type: store.OpDeposit, UserID: "1", Currency: "XYZ", Amount: 10, TxID: "d1"})
+	enqueue(t, q, deposit("1", "d2", 150))
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "deposit applied", func() bool { return len(repo.Applied()) == 1 })
+
+	if st := opState(t, q, "1", "d1"); st.State != store.OpFailed || !strings.Contains(st.Error, currency.ErrUnknown.Error()) {
+		t.Fatalf("status d1 = %+v, want FAILED unknown currency", st)
+	}
+	if bal := repo.Balance(1, "USD"); !bal.Equal(decimal.RequireFromString("1.50")) {
+		t.Fatalf("balance = %s, want 1.50", bal)
+	}
+	if len(q.DeadLetters()) != 0 {
+		t.Fatal("unknown currency must not be dead-lettered")
+	}
+}
+
+func TestProcessorRejectsTransferToInvalidUser(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+	repo.SetBalance(1, "USD", decimal.NewFromInt(10))
+
+	enqueue(t, q, store.OperationPayload{Type: store.OpTransfer, UserID: "1", ToUserID: "abc", Amount: 500, TxID: "t1"})
+	enqueue(t, q, store.OperationPayload{Type: store.OpTransfer, UserID: "1", ToUserID: " 7", Amount: 500, TxID: "t2"})
+	enqueue(t, q, deposit("1", "d1", 100))
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "deposit applied", func() bool { return len(repo.Applied()) == 1 })
+
+	for _, tx := range []string{"t1", "t2"} {
+		if st := opState(t, q, "1", tx); st.State != store.OpFailed || !strings.Contains(st.Error, store.ErrInvalidUserID.Error()) {
+			t.Fatalf("status %s = %+v, want FAILED invalid user_id", tx, st)
+		}
+	}
+	if bal := repo.Balance(1, "USD"); !bal.Equal(decimal.NewFromInt(11)) {
+		t.Fatalf("sender balance = %s, want 11 (no debit)", bal)
+	}
+	if bal := repo.Balance(0, "USD"); !bal.IsZero() {
+		t.Fatalf("user 0 balance = %s, want 0", bal)
+	}
+	if len(q.DeadLetters()) != 0 {
+		t.Fatal("invalid to_user_id must not be dead-lettered")
+	}
+}
+
+func TestProcessorQuarantinesMalformedPayload(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	q.PushRaw("1", "{not json")
+	enqueue(t, q, deposit("1", "a", 10))
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "next item applied", func() bool { return len(repo.Applied()) == 1 })
+
+	items := q.QuarantinedItems()
+	if len(items) != 1 || items[0].Raw != "{not json" || items[0].UserID != "1" {
+		t.Fatalf("quarantine = %+v, want the raw payload of user 1", items)
+	}
+	audit := repo.Quarantined()
+	if len(audit) != 1 || audit[0].QuarantineID != items[0].ID {
+		t.Fatalf("audit copy = %+v, want id %s", audit, items[0].ID)
+	}
+}
+
+func TestProcessorRecoversExpiredLease(t *testing.T) {
+	q := newTestQueue()
+	q.LeaseTTL = 20 * time.Millisecond
+	repo := repository.NewMemoryWalletRepository()
+
+	enqueue(t, q, deposit("1", "a", 10))
+
+	// worker lain mengambil head lalu "mati" tanpa Ack
+	if _, err := q.Claim(context.Background(), "dead#0", time.Second); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "orphaned head applied", func() bool { return len(repo.Applied()) == 1 })
+	waitFor(t, "queue unlocked", func() bool { return !q.Locked("1") })
+}
+
+func TestProcessorDrainsInFlightOnShutdown(t *testing.T) {
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	repo.Hook = func(op string, in repository.OperationInput) error {
+		if in.TxID == "slow" {
+			close(entered)
+			<-release
+		}
+		return nil
+	}
+	enqueue(t, q, deposit("1", "slow", 10))
+
+	p := newTestProcessor(q, repo)
+	p.DBExecTO = 5 * time.Second
+	stop := start(t, p, 2)
+	<-entered
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("processor stopped before the in-flight item finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("processor did not stop after in-flight item finished")
+	}
+
+	if got := appliedTxIDs(repo, 1); len(got) != 1 {
+		t.Fatalf("applied = %v, want [slow]", got)
+	}
+	if q.Len("1") != 0 || q.Locked("1") {
+		t.Fatal("in-flight item was not acked before shutdown")
+	}
+	if w := q.Workers(); len(w) != 0 {
+		t.Fatalf("workers still registered after shutdown: %v", w)
+	}
+}
+
+func TestProcessorContinuesTraceFromPayload(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	if _, err := tracing.Init(context.Background(), config.TracingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	q := newTestQueue()
+	repo := repository.NewMemoryWalletRepository()
+
+	ctx, rpc := tracing.Tracer().Start(context.Background(), "rpc")
+	p := deposit("1", "tx-traced", 1)
+	p.Trace = tracing.Inject(ctx)
+	rpc.End()
+	enqueue(t, q, p)
+
+	start(t, newTestProcessor(q, repo), 1)
+	waitFor(t, "deposit applied", func() bool { return len(repo.Applied()) == 1 })
+	waitFor(t, "processor span ended", func() bool {
+		for _, s := range rec.Ended() {
+			if s.Name() == "processor.handle" {
+				return true
+			}
+		}
+		return false
+	})
+
+	want := rpc.SpanContext().TraceID()
+	for _, s := range rec.Ended() {
+		switch s.Name() {
+		case "queue.claim", "processor.handle":
+			if s.SpanContext().TraceID() != want || s.Parent().SpanID() != rpc.SpanContext().SpanID() {
+				t.Fatalf("span %s not a child of the originating RPC span", s.Name())
+			}
+		}
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"grls/pkg/logger"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DB     *DBConfig
-	App    *AppConfig
-	Redis  *RedisConfig
-	Worker *WorkerConfig
+	DB      *DBConfig
+	App     *AppConfig
+	Redis   *RedisConfig
+	Worker  *WorkerConfig
+	Tracing *TracingConfig
 }
 
 type AppConfig struct {
@@ -21,6 +23,8 @@ type AppConfig struct {
 	Port        string
 	LogFilePath string
 	BinFilePath string
+	MetricsPort string // HTTP /metrics Prometheus; kosong = nonaktif
+	APIVersion  int    // gaya error write RPC: 1 = Status FAILED di response (legacy), 2 = gRPC status code
 }
 
 type DBConfig struct {
@@ -55,14 +59,37 @@ type DBPooling struct {
 }
 
 type RedisConfig struct {
-	Host     string
-	Port     string
-	Password string
-	DB       string
+	Mode       string // single | cluster | sentinel
+	Host       string
+	Port       string
+	Addrs      []string // cluster: seed node, sentinel: alamat sentinel (host:port)
+	MasterName string   // sentinel
+	Password   string
+	DB         string // diabaikan di mode cluster
+}
+
+type TracingConfig struct {
+	Enabled     bool
+	Endpoint    string // OTLP/gRPC collector host:port
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
 }
 
 type WorkerConfig struct {
-	WorkerCount int
+	WorkerCount  int
+	WalletMode   string // queue (FIFO q:{user} → Postgres) | redis (deposit.lua, Postgres via stream:wallet)
+	QueueBackend string // list (q:{user} + Lua) | stream (Redis Streams consumer group)
+	QueueShards  int    // jumlah shard hash tag {wN}; harus sama di semua instance
+
+	ReconcileInterval int    // detik antar rekonsiliasi Redis ↔ Postgres; 0 = nonaktif
+	ReconcileRepair   string // none | redis | postgres (sisi yang diperbaiki; postgres wajib dry-run)
+	ReconcileDryRun   bool   // true = hanya laporkan repair
+
+	WarmupEnabled       bool // WALLET_MODE=redis: backfill saldo & tx_id dari Postgres saat start
+	WarmupTxWindowHours int  // tx_id yang dimuat ke tx:{user}: dibuat dalam N jam terakhir
+
+	CurrencyRefreshInterval int // detik antar reload registry currency dari DB; 0 = hanya saat start
 }
 
 func Load() *Config {
@@ -71,10 +98,11 @@ func Load() *Config {
 	}
 
 	return &Config{
-		DB:     LoadDBConfig(),
-		App:    LoadAppConfig(),
-		Redis:  LoadRedisConfig(),
-		Worker: LoadWorkerConfig(),
+		DB:      LoadDBConfig(),
+		App:     LoadAppConfig(),
+		Redis:   LoadRedisConfig(),
+		Worker:  LoadWorkerConfig(),
+		Tracing: LoadTracingConfig(),
 	}
 }
 
@@ -85,6 +113,8 @@ func LoadAppConfig() *AppConfig {
 		Port:        getEnv("APP_PORT", "50051"),
 		LogFilePath: getEnv("APP_LOG_FILE", "logs/app.log"),
 		BinFilePath: getEnv("APP_BIN_FILE", "./bin/grls"),
+		MetricsPort: os.Getenv("METRICS_PORT"),
+		APIVersion:  getEnvAsInt("GRPC_API_VERSION", 1),
 	}
 }
 
@@ -123,16 +153,41 @@ func LoadDBConfig() *DBConfig {
 
 func LoadRedisConfig() *RedisConfig {
 	return &RedisConfig{
-		Host:     getEnv("REDIS_HOST", "localhost"),
-		Port:     getEnv("REDIS_PORT", "6379"),
-		Password: getEnv("REDIS_PASSWORD", "password"),
-		DB:       getEnv("REDIS_DB", "0"),
+		Mode:       getEnv("REDIS_MODE", "single"),
+		Host:       getEnv("REDIS_HOST", "localhost"),
+		Port:       getEnv("REDIS_PORT", "6379"),
+		Addrs:      getEnvAsList("REDIS_ADDRS"),
+		MasterName: getEnv("REDIS_MASTER_NAME", "mymaster"),
+		Password:   getEnv("REDIS_PASSWORD", "password"),
+		DB:         getEnv("REDIS_DB", "0"),
 	}
 }
 
 func LoadWorkerConfig() *WorkerConfig {
 	return &WorkerConfig{
-		WorkerCount: getEnvAsInt("WORKER_COUNT", 5),
+		WorkerCount:  getEnvAsInt("WORKER_COUNT", 5),
+		WalletMode:   getEnv("WALLET_MODE", "queue"),
+		QueueBackend: getEnv("QUEUE_BACKEND", "list"),
+		QueueShards:  getEnvAsInt("QUEUE_SHARDS", 1),
+
+		ReconcileInterval: getEnvAsInt("RECONCILE_INTERVAL", 0),
+		ReconcileRepair:   getEnv("RECONCILE_REPAIR", "none"),
+		ReconcileDryRun:   getEnvAsBool("RECONCILE_DRY_RUN", true),
+
+		WarmupEnabled:       getEnvAsBool("WARMUP_ENABLED", true),
+		WarmupTxWindowHours: getEnvAsInt("WARMUP_TX_WINDOW_HOURS", 72),
+
+		CurrencyRefreshInterval: getEnvAsInt("CURRENCY_REFRESH_INTERVAL", 60),
+	}
+}
+
+func LoadTracingConfig() *TracingConfig {
+	return &TracingConfig{
+		Enabled:     getEnvAsBool("OTEL_ENABLED", false),
+		Endpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4317"),
+		Insecure:    getEnvAsBool("OTEL_EXPORTER_OTLP_INSECURE", true),
+		ServiceName: getEnv("OTEL_SERVICE_NAME", "grls"),
+		SampleRatio: getEnvAsFloat("OTEL_SAMPLE_RATIO", 1),
 	}
 }
 
@@ -169,3 +224,34 @@ func getEnvAsInt(key string, defaultVal int) int {
 	}
 	return defaultVal
 }
+
+// getEnvAsList returns a comma-separated environment variable as a list (empty entries dropped)
+func getEnvAsList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// getEnvAsBool returns the value of the environment variable as a bool or a default value if not set
+func getEnvAsBool(key string, defaultVal bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
+
+// getEnvAsFloat returns the value of the environment variable as a float64 or a default value if not set
+func getEnvAsFloat(key string, defaultVal float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return defaultVal
+}
@@ -0,0 +1,148 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"grls/internal/model"
+	"grls/pkg/logger"
+)
+
+var (
+	ErrUnknown   = errors.New("unknown currency")
+	ErrDisabled  = errors.New("currency disabled")
+	ErrNetwork   = errors.New("network not supported for currency")
+	ErrPrecision = errors.New("amount exceeds currency precision")
+)
+
+// Source: isi tabel currencies. Implementasi: *repository.WalletRepository.
+type Source interface {
+	ListCurrencies(ctx context.Context) ([]model.Currency, error)
+}
+
+// Registry: cache in-memory tabel currencies. Dibaca di hot path (RPC, processor,
+// persister) tanpa query DB; isi diganti utuh tiap Refresh.
+type Registry struct {
+	src Source
+
+	mu     sync.RWMutex
+	byCode map[string]model.Currency
+}
+
+func NewRegistry(src Source) *Registry {
+	return &Registry{src: src, byCode: map[string]model.Currency{}}
+}
+
+// NewStatic: registry tetap tanpa DB (test/admin tool)
+func NewStatic(cs ...model.Currency) *Registry {
+	r := NewRegistry(nil)
+	r.set(cs)
+	return r
+}
+
+// Refresh: muat ulang dari Source. Gagal = isi lama tetap dipakai.
+func (r *Registry) Refresh(ctx context.Context) error {
+	if r.src == nil {
+		return nil
+	}
+	cs, err := r.src.ListCurrencies(ctx)
+	if err != nil {
+		return err
+	}
+	r.set(cs)
+	return nil
+}
+
+func (r *Registry) set(cs []model.Currency) {
+	m := make(map[string]model.Currency, len(cs))
+	for _, c := range cs {
+		c.Code = strings.ToUpper(c.Code)
+		m[c.Code] = c
+	}
+	r.mu.Lock()
+	r.byCode = m
+	r.mu.Unlock()
+}
+
+// RefreshEvery: Refresh tiap interval sampai ctx selesai (perubahan enabled/decimals
+// dari DB terbaca tanpa restart)
+func (r *Registry) RefreshEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			logger.Warnf("currency registry refresh: %v", err)
+		}
+	}
+}
+
+// Get: currency terdaftar (enabled atau tidak)
+func (r *Registry) Get(code string) (model.Currency, error) {
+	r.mu.RLock()
+	c, ok := r.byCode[strings.ToUpper(code)]
+	r.mu.RUnlock()
+	if !ok {
+		return model.Currency{}, fmt.Errorf("%w: %q", ErrUnknown, code)
+	}
+	return c, nil
+}
+
+// Validate: cek untuk operasi baru di RPC. Network hanya dicek kalau dua-duanya diisi
+// (network di request opsional, currency fiat tidak punya network).
+func (r *Registry) Validate(code, network string) (model.Currency, error) {
+	c, err := r.Get(code)
+	if err != nil {
+		return c, err
+	}
+	if !c.Enabled {
+		return c, fmt.Errorf("%w: %s", ErrDisabled, c.Code)
+	}
+	if network != "" && c.Network != "" && !strings.EqualFold(network, c.Network) {
+		return c, fmt.Errorf("%w: %s on %s", ErrNetwork, c.Code, network)
+	}
+	return c, nil
+}
+
+// Decimals: jumlah desimal minor unit; currency tak dikenal = 0 (as-is).
+// Bentuknya cocok untuk reconcile.Reconciler.Decimals / warmup.Warmer.Decimals.
+func (r *Registry) Decimals(code string) int32 {
+	c, err := r.Get(code)
+	if err != nil {
+		return 0
+	}
+	return c.Decimals
+}
+
+// ToDecimal: minor unit API → major unit NUMERIC (USD: 150 → 1.50). Currency yang
+// sudah disabled tetap dikonversi: operasi yang terlanjur diterima harus tetap jalan.
+func (r *Registry) ToDecimal(code string, minor int64) (decimal.Decimal, error) {
+	c, err := r.Get(code)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return decimal.New(minor, -c.Decimals), nil
+}
+
+// ToMinor: major unit → minor unit; nilai di bawah presisi currency ditolak, bukan dibulatkan
+func (r *Registry) ToMinor(code string, amount decimal.Decimal) (int64, error) {
+	c, err := r.Get(code)
+	if err != nil {
+		return 0, err
+	}
+	minor := amount.Shift(c.Decimals)
+	if !minor.IsInteger() {
+		return 0, fmt.Errorf("%w: %s %s (decimals=%d)", ErrPrecision, c.Code, amount, c.Decimals)
+	}
+	return minor.IntPart(), nil
+}
@@ -0,0 +1,108 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"grls/internal/model"
+)
+
+type stubSource struct {
+	cs  []model.Currency
+	err error
+}
+
+func (s *stubSource) ListCurrencies(context.Context) ([]model.Currency, error) { return s.cs, s.err }
+
+func TestConvertsBetweenMinorAndDecimal(t *testing.T) {
+	r := NewStatic(
+		model.Currency{Code: "USD", Decimals: 2, Enabled: true},
+		model.Currency{Code: "USDT", Decimals: 6, Network: "TRC20", Enabled: true},
+		model.Currency{Code: "JPY", Decimals: 0, Enabled: true},
+	)
+	for _, tc := range []struct {
+		code  string
+		minor int64
+		want  string
+	}{
+		{"USD", 100, "1"},
+		{"usd", 150, "1.5"},
+		{"USDT", 1, "0.000001"},
+		{"JPY", 500, "500"},
+	} {
+		got, err := r.ToDecimal(tc.code, tc.minor)
+		if err != nil || !got.Equal(decimal.RequireFromString(tc.want)) {
+			t.Fatalf("ToDecimal(%s, %d) = %s, %v; want %s", tc.code, tc.minor, got, err, tc.want)
+		}
+		back, err := r.ToMinor(tc.code, got)
+		if err != nil || back != tc.minor {
+			t.Fatalf("ToMinor(%s, %s) = %d, %v; want %d", tc.code, got, back, err, tc.minor)
+		}
+	}
+
+	if _, err := r.ToMinor("USD", decimal.RequireFromString("1.005")); !errors.Is(err, ErrPrecision) {
+		t.Fatalf("sub-cent ToMinor err = %v, want ErrPrecision", err)
+	}
+	if _, err := r.ToDecimal("XYZ", 1); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("unknown ToDecimal err = %v, want ErrUnknown", err)
+	}
+	if d := r.Decimals("XYZ"); d != 0 {
+		t.Fatalf("Decimals(unknown) = %d, want 0", d)
+	}
+}
+
+func TestValidateChecksEnabledAndNetwork(t *testing.T) {
+	r := NewStatic(
+		model.Currency{Code: "USD", Decimals: 2, Enabled: true},
+		model.Currency{Code: "SGD", Decimals: 2, Enabled: false},
+		model.Currency{Code: "USDT", Decimals: 6, Network: "TRC20", Enabled: true},
+	)
+	for _, tc := range []struct {
+		code, network string
+		want          error
+	}{
+		{"USD", "", nil},
+		{"USD", "TRC20", nil}, // fiat: network diabaikan
+		{"USDT", "trc20", nil},
+		{"USDT", "", nil},
+		{"USDT", "ERC20", ErrNetwork},
+		{"SGD", "", ErrDisabled},
+		{"XYZ", "", ErrUnknown},
+	} {
+		if _, err := r.Validate(tc.code, tc.network); !errors.Is(err, tc.want) {
+			t.Fatalf("Validate(%s, %q) = %v, want %v", tc.code, tc.network, err, tc.want)
+		}
+	}
+
+	// disabled tetap bisa dikonversi (operasi yang sudah diterima)
+	if _, err := r.ToDecimal("SGD", 100); err != nil {
+		t.Fatalf("ToDecimal(disabled) = %v", err)
+	}
+}
+
+func TestRefreshKeepsCacheOnError(t *testing.T) {
+	src := &stubSource{cs: []model.Currency{{Code: "USD", Decimals: 2, Enabled: true}}}
+	r := NewRegistry(src)
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	src.cs, src.err = nil, errors.New("db down")
+	if err := r.Refresh(context.Background()); err == nil {
+		t.Fatal("want refresh error")
+	}
+	if d := r.Decimals("USD"); d != 2 {
+		t.Fatalf("Decimals(USD) after failed refresh = %d, want 2", d)
+	}
+
+	src.cs, src.err = []model.Currency{{Code: "usd", Decimals: 2, Enabled: false}}, nil
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Validate("USD", ""); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("Validate after refresh = %v, want ErrDisabled", err)
+	}
+}
@@ -0,0 +1,99 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/store"
+	"grls/pkg/logger"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+const readTO = 1 * time.Second
+
+func (s *server) GetBalance(ctx context.Context, req *walletv1.GetBalanceRequest) (*walletv1.GetBalanceResponse, error) {
+	userID, err := store.ParseUserID(req.GetUserId())
+	if err != nil || req.GetCurrency() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid request: positive integer user_id and currency required")
+	}
+	user := strconv.FormatInt(userID, 10)
+	cur := strings.ToUpper(req.GetCurrency())
+
+	rctx, cancel := context.WithTimeout(ctx, readTO)
+	defer cancel()
+
+	// Cache: low-latency, tapi hanya balance (is_active/timestamps tidak diisi). Hanya
+	// WALLET_MODE=redis yang memelihara balance:{user}:{CUR}; mode queue & selama warm-up → DB.
+	if req.GetSource() == walletv1.BalanceSource_BALANCE_SOURCE_CACHE && s.cacheAuthoritative() {
+		bal, found, err := s.walletStore.GetBalance(rctx, user, cur)
+		if err != nil {
+			logger.Warnf("cache balance err user=%s cur=%s: %v", user, cur, err)
+		}
+		if found {
+			return &walletv1.GetBalanceResponse{
+				Wallet: &walletv1.Wallet{
+					UserId:   user,
+					Currency: cur,
+					Balance:  s.cacheBalance(cur, bal),
+				},
+				Source: walletv1.BalanceSource_BALANCE_SOURCE_CACHE,
+			}, nil
+		}
+	}
+
+	w, err := s.repo.GetWallet(rctx, userID, cur)
+	if errors.Is(err, repository.ErrWalletNotFound) {
+		return nil, status.Error(codes.NotFound, "wallet not found")
+	}
+	if err != nil {
+		logger.Errorf("get wallet err user=%d cur=%s: %v", userID, cur, err)
+		return nil, status.Error(codes.Internal, "db error")
+	}
+
+	return &walletv1.GetBalanceResponse{
+		Wallet: toProtoWallet(*w),
+		Source: walletv1.BalanceSource_BALANCE_SOURCE_DB,
+	}, nil
+}
+
+func (s *server) ListWallets(ctx context.Context, req *walletv1.ListWalletsRequest) (*walletv1.ListWalletsResponse, error) {
+	userID, err := store.ParseUserID(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid request: positive integer user_id required")
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, readTO)
+	defer cancel()
+
+	ws, err := s.repo.ListWallets(rctx, userID)
+	if err != nil {
+		logger.Errorf("list wallets err user=%d: %v", userID, err)
+		return nil, status.Error(codes.Internal, "db error")
+	}
+
+	return &walletv1.ListWalletsResponse{Wallets: toProtoWallets(ws)}, nil
+}
+
+// cacheAuthoritative: balance Redis adalah saldo final (WALLET_MODE=redis, warm-up selesai)
+func (s *server) cacheAuthoritative() bool {
+	return s.mode == ModeRedis && s.walletStore != nil && (s.ready == nil || s.ready())
+}
+
+// cacheBalance: balance Redis (minor unit) → string major unit seperti kolom NUMERIC.
+// Tanpa registry / currency tak dikenal: minor unit apa adanya.
+func (s *server) cacheBalance(cur string, minor int64) string {
+	if s.currencies != nil {
+		if c, err := s.currencies.Get(cur); err == nil {
+			return decimal.New(minor, -c.Decimals).StringFixed(c.Decimals)
+		}
+	}
+	return strconv.FormatInt(minor, 10)
+}
@@ -0,0 +1,218 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"grls/internal/currency"
+	"grls/internal/store"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+// API version write RPC (Deposit/Withdraw/Transfer):
+//   - v1 (legacy): selalu sukses di level gRPC, hasil di field Status (SUCCESS/FAILED) + Message
+//   - v2: error dikembalikan sebagai gRPC status (InvalidArgument / Unavailable / AlreadyExists)
+//     dengan error details; Status di response sukses tetap diisi SUCCESS
+//
+// Default v1 (GRPC_API_VERSION kosong/0) supaya client lama tidak berubah; v2 opt-in lewat
+// env atau per request lewat metadata "x-api-version: 1|2".
+const (
+	APIVersionLegacy = 1
+	APIVersionStatus = 2
+
+	apiVersionHeader = "x-api-version"
+	errorDomain      = "wallet.grls"
+)
+
+// legacy: true jika request ini harus dijawab dengan gaya v1
+func (s *server) legacy(ctx context.Context) bool {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(apiVersionHeader); len(v) > 0 {
+			switch v[0] {
+			case "1":
+				return true
+			case "2":
+				return false
+			}
+		}
+	}
+	return s.apiVersion == APIVersionLegacy
+}
+
+// fail: satu-satunya titik pemetaan hasil gagal write RPC. v2 → err (gRPC status), v1 →
+// response legacy (FAILED / duplicate) dengan error nil. Setiap RPC lewat sini supaya
+// pemetaan v1/v2 tidak bisa berbeda antar RPC.
+func fail[R any](s *server, ctx context.Context, err error, legacyResp *R) (*R, error) {
+	if s.legacy(ctx) {
+		return legacyResp, nil
+	}
+	return nil, err
+}
+
+// response v1 per RPC: FAILED + Message, atau duplicate (Status mengikuti hasil asli)
+
+func failedDeposit(msg string) *walletv1.DepositResponse {
+	return &walletv1.DepositResponse{Status: walletv1.DepositResponse_FAILED, Message: msg}
+}
+
+func failedWithdraw(msg string) *walletv1.WithdrawResponse {
+	return &walletv1.WithdrawResponse{Status: walletv1.WithdrawResponse_FAILED, Message: msg}
+}
+
+func failedTransfer(msg string) *walletv1.TransferResponse {
+	return &walletv1.TransferResponse{Status: walletv1.TransferResponse_FAILED, Message: msg}
+}
+
+func duplicateDeposit(txID string, prev *store.OpStatus) *walletv1.DepositResponse {
+	resp := &walletv1.DepositResponse{Status: walletv1.DepositResponse_SUCCESS, Message: duplicateMessage(prev), OperationId: txID, Duplicate: true}
+	if prev.State == store.OpFailed {
+		resp.Status = walletv1.DepositResponse_FAILED
+	}
+	return resp
+}
+
+func duplicateWithdraw(txID string, prev *store.OpStatus) *walletv1.WithdrawResponse {
+	resp := &walletv1.WithdrawResponse{Status: walletv1.WithdrawResponse_SUCCESS, Message: duplicateMessage(prev), OperationId: txID, Duplicate: true}
+	if prev.State == store.OpFailed {
+		resp.Status = walletv1.WithdrawResponse_FAILED
+	}
+	return resp
+}
+
+func duplicateTransfer(txID string, prev *store.OpStatus) *walletv1.TransferResponse {
+	resp := &walletv1.TransferResponse{Status: walletv1.TransferResponse_SUCCESS, Message: duplicateMessage(prev), OperationId: txID, Duplicate: true}
+	if prev.State == store.OpFailed {
+		resp.Status = walletv1.TransferResponse_FAILED
+	}
+	return resp
+}
+
+func violation(field, desc string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: desc}
+}
+
+// invalidArgument: InvalidArgument + BadRequest berisi semua field yang salah
+func invalidArgument(msg string, violations []*errdetails.BadRequest_FieldViolation) error {
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
+
+// unavailable: Redis/queue gagal → client boleh retry dengan tx_id yang sama.
+// reason: QUEUE_UNAVAILABLE (WALLET_MODE=queue) | STORE_UNAVAILABLE / WARMING_UP (WALLET_MODE=redis)
+func unavailable(reason, msg string) error {
+	st, err := status.New(codes.Unavailable, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, msg)
+	}
+	return st.Err()
+}
+
+// unimplemented: operasi tidak tersedia di WALLET_MODE server ini (permanen)
+func unimplemented(msg string) error {
+	return status.Error(codes.Unimplemented, msg)
+}
+
+// alreadyExists: tx_id sudah pernah diterima; ErrorInfo membawa hasil asli operasi
+func alreadyExists(txID string, prev *store.OpStatus) error {
+	msg := duplicateMessage(prev)
+	info := &errdetails.ErrorInfo{
+		Reason: "DUPLICATE_TX",
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			"operation_id": txID,
+			"state":        string(prev.State),
+		},
+	}
+	if prev.Error != "" {
+		info.Metadata["error"] = prev.Error
+	}
+	st, err := status.New(codes.AlreadyExists, msg).WithDetails(info)
+	if err != nil {
+		return status.Error(codes.AlreadyExists, msg)
+	}
+	return st.Err()
+}
+
+// validatePayload: validasi minimal sebelum masuk antrian. msg = pesan gaya v1.
+func validatePayload(p store.OperationPayload) (msg string, violations []*errdetails.BadRequest_FieldViolation) {
+	var missing []string
+	userField := "user_id"
+	if p.Op() == store.OpTransfer {
+		userField = "from_user_id"
+	}
+	var invalid []string
+	if p.UserID == "" {
+		missing = append(missing, "user_id")
+		violations = append(violations, violation(userField, "required"))
+	} else if _, err := store.ParseUserID(p.UserID); err != nil {
+		invalid = append(invalid, userField)
+		violations = append(violations, violation(userField, "must be a positive integer"))
+	}
+	if p.Currency == "" {
+		missing = append(missing, "currency")
+		violations = append(violations, violation("currency", "required"))
+	}
+	if p.TxID == "" {
+		missing = append(missing, "tx_id")
+		violations = append(violations, violation("tx_id", "required"))
+	}
+	if p.Op() == store.OpTransfer && p.ToUserID != "" {
+		if _, err := store.ParseUserID(p.ToUserID); err != nil {
+			invalid = append(invalid, "to_user_id")
+			violations = append(violations, violation("to_user_id", "must be a positive integer"))
+		}
+	}
+	if len(missing) > 0 {
+		msg = "invalid request: " + strings.Join(missing, "/") + " required"
+	} else if len(invalid) > 0 {
+		msg = "invalid request: " + strings.Join(invalid, "/") + " must be a positive integer"
+	}
+	if p.Amount <= 0 {
+		violations = append(violations, violation("amount", "must be > 0"))
+		if msg == "" {
+			msg = "invalid amount: must be > 0"
+		}
+	}
+	if p.Op() == store.OpTransfer && (p.ToUserID == "" || p.ToUserID == p.UserID) {
+		violations = append(violations, violation("to_user_id", "required and must differ from from_user_id"))
+		if msg == "" {
+			msg = "invalid request: to_user_id required and must differ from from_user_id"
+		}
+	}
+	return msg, violations
+}
+
+// validate: validatePayload + currency harus terdaftar & enabled di registry
+// (s.currencies nil = tidak dicek). network: kosong kalau RPC tidak punya field network.
+func (s *server) validate(p store.OperationPayload, network string) (msg string, violations []*errdetails.BadRequest_FieldViolation) {
+	msg, violations = validatePayload(p)
+	if s.currencies == nil || p.Currency == "" {
+		return msg, violations
+	}
+	if _, err := s.currencies.Validate(p.Currency, network); err != nil {
+		field, desc := "currency", "unsupported currency"
+		switch {
+		case errors.Is(err, currency.ErrDisabled):
+			desc = "currency disabled"
+		case errors.Is(err, currency.ErrNetwork):
+			field, desc = "network", "not supported for "+p.Currency
+		}
+		violations = append(violations, violation(field, desc))
+		if msg == "" {
+			msg = "invalid " + field + ": " + desc
+		}
+	}
+	return msg, violations
+}
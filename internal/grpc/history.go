@@ -0,0 +1,86 @@
+package grpcserver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/store"
+	"grls/pkg/cursor"
+	"grls/pkg/logger"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+func (s *server) ListTransactions(ctx context.Context, req *walletv1.ListTransactionsRequest) (*walletv1.ListTransactionsResponse, error) {
+	userID, err := store.ParseUserID(req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid request: positive integer user_id required")
+	}
+	filter := historyFilterHash(userID, req)
+	cur, err := cursor.DecodeFor(req.GetCursor(), filter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	limit = min(limit, maxHistoryLimit)
+
+	f := repository.TxHistoryFilter{
+		UserID:   userID,
+		Currency: req.GetCurrency(),
+		BeforeID: cur.LastID,
+		Limit:    limit + 1, // +1 untuk tahu masih ada halaman berikutnya
+	}
+	if req.GetFrom() != nil {
+		f.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		f.To = req.GetTo().AsTime()
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, readTO)
+	defer cancel()
+
+	entries, err := s.repo.ListTransactions(rctx, f)
+	if err != nil {
+		logger.Errorf("list transactions err user=%d: %v", userID, err)
+		return nil, status.Error(codes.Internal, "db error")
+	}
+
+	resp := &walletv1.ListTransactionsResponse{}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		resp.NextCursor = cursor.Encode(cursor.Cursor{LastID: entries[limit-1].ID, Filter: filter})
+	}
+	resp.Transactions = toProtoTransactions(entries)
+	return resp, nil
+}
+
+// historyFilterHash: cursor terikat ke user/currency/from/to; limit boleh beda antar halaman
+func historyFilterHash(userID int64, req *walletv1.ListTransactionsRequest) string {
+	ts := func(set bool, t time.Time) string {
+		if !set {
+			return ""
+		}
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return cursor.FilterHash(
+		strconv.FormatInt(userID, 10),
+		strings.ToUpper(req.GetCurrency()),
+		ts(req.GetFrom() != nil, req.GetFrom().AsTime()),
+		ts(req.GetTo() != nil, req.GetTo().AsTime()),
+	)
+}
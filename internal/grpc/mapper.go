@@ -0,0 +1,57 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"grls/internal/model"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+func toProtoWallet(w model.Wallet) *walletv1.Wallet {
+	return &walletv1.Wallet{
+		UserId:    strconv.FormatInt(w.UserID, 10),
+		Currency:  w.Currency,
+		Balance:   w.Balance,
+		IsActive:  w.IsActive,
+		CreatedAt: timestamppb.New(w.CreatedAt),
+		UpdatedAt: timestamppb.New(w.UpdatedAt),
+	}
+}
+
+func toProtoWallets(ws []model.Wallet) []*walletv1.Wallet {
+	out := make([]*walletv1.Wallet, 0, len(ws))
+	for _, w := range ws {
+		out = append(out, toProtoWallet(w))
+	}
+	return out
+}
+
+func toProtoTransaction(e model.LedgerEntry) *walletv1.Transaction {
+	var meta map[string]string
+	_ = json.Unmarshal([]byte(e.Meta), &meta)
+
+	t := &walletv1.Transaction{
+		TxId:      e.TxID,
+		Type:      e.OpType,
+		Currency:  e.Currency,
+		Direction: e.Direction,
+		Amount:    e.Amount,
+		Meta:      meta,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+	if e.BalanceAfter != nil {
+		t.BalanceAfter = *e.BalanceAfter
+	}
+	return t
+}
+
+func toProtoTransactions(es []model.LedgerEntry) []*walletv1.Transaction {
+	out := make([]*walletv1.Transaction, 0, len(es))
+	for _, e := range es {
+		out = append(out, toProtoTransaction(e))
+	}
+	return out
+}
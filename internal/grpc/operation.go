@@ -0,0 +1,47 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"grls/internal/store"
+	"grls/pkg/logger"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+var opStateToProto = map[store.OpState]walletv1.OperationState{
+	store.OpPending: walletv1.OperationState_PENDING,
+	store.OpApplied: walletv1.OperationState_APPLIED,
+	store.OpFailed:  walletv1.OperationState_FAILED,
+}
+
+func (s *server) GetOperationStatus(ctx context.Context, req *walletv1.GetOperationStatusRequest) (*walletv1.GetOperationStatusResponse, error) {
+	if req.GetUserId() == "" || req.GetTxId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid request: user_id/tx_id required")
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, readTO)
+	defer cancel()
+
+	st, err := s.queue.GetOpStatus(rctx, req.GetUserId(), req.GetTxId())
+	if errors.Is(err, store.ErrOpNotFound) {
+		return nil, status.Error(codes.NotFound, "operation not found")
+	}
+	if err != nil {
+		logger.Errorf("get op status err user=%s tx=%s: %v", req.GetUserId(), req.GetTxId(), err)
+		return nil, status.Error(codes.Unavailable, "queue error")
+	}
+
+	return &walletv1.GetOperationStatusResponse{
+		OperationId: st.TxID,
+		Type:        string(st.Type),
+		State:       opStateToProto[st.State],
+		Message:     st.Error,
+		UpdatedAt:   timestamppb.New(time.UnixMilli(st.UpdatedAt)),
+	}, nil
+}
@@ -5,65 +5,275 @@ import (
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 
+	"grls/internal/currency"
+	"grls/internal/infrastructure/repository"
+	"grls/internal/metrics"
 	"grls/internal/store"
+	"grls/internal/tracing"
 	"grls/pkg/logger"
 	walletv1 "grls/pkg/proto/wallet/v1"
 )
 
 const enqueueTO = 1500 * time.Millisecond
 
+// Mode write path (WALLET_MODE):
+//   - queue: operasi masuk q:{user} FIFO, processor commit ke Postgres (default)
+//   - redis: Deposit langsung diterapkan deposit.lua (Redis = sumber saldo),
+//     async.Persister menyalin stream:wallet ke Postgres. Withdraw/Transfer belum didukung.
+const (
+	ModeQueue = "queue"
+	ModeRedis = "redis"
+)
+
+// Deps: dependency handler gRPC
+type Deps struct {
+	Mode        string                       // ModeQueue | ModeRedis; kosong = ModeQueue
+	Queue       store.Queue                  // write path (FIFO per user)
+	Repo        *repository.WalletRepository // read path (dbRead)
+	WalletStore *store.RedisWalletStore      // read cache balance:{user}:{CUR}; write path di ModeRedis
+	APIVersion  int                          // default gaya error write RPC (lihat errors.go)
+	Ready       func() bool                  // ModeRedis: false selama warm-up saldo dari Postgres; nil = selalu siap
+	Currencies  *currency.Registry           // currency yang diterima write RPC + konversi minor unit; nil = tidak dicek
+}
+
 type server struct {
 	walletv1.UnimplementedWalletServiceServer
-	queue *store.RedisQueue
+	mode        string
+	apiVersion  int
+	queue       store.Queue
+	repo        *repository.WalletRepository
+	walletStore *store.RedisWalletStore
+	ready       func() bool
+	currencies  *currency.Registry
 }
 
-func NewWalletServiceServer(queue *store.RedisQueue) *server {
-	return &server{queue: queue}
+func NewWalletServiceServer(deps Deps) *server {
+	apiVersion := deps.APIVersion
+	if apiVersion == 0 {
+		apiVersion = APIVersionLegacy
+	}
+	mode := deps.Mode
+	if mode == "" {
+		mode = ModeQueue
+	}
+	return &server{
+		mode:        mode,
+		apiVersion:  apiVersion,
+		queue:       deps.Queue,
+		repo:        deps.Repo,
+		walletStore: deps.WalletStore,
+		ready:       deps.Ready,
+		currencies:  deps.Currencies,
+	}
 }
 
-func RegisterWalletService(s *grpc.Server, queue *store.RedisQueue) {
-	walletv1.RegisterWalletServiceServer(s, NewWalletServiceServer(queue))
+func RegisterWalletService(s *grpc.Server, deps Deps) {
+	walletv1.RegisterWalletServiceServer(s, NewWalletServiceServer(deps))
 }
 
 var _ walletv1.WalletServiceServer = (*server)(nil)
 
 func (s *server) Deposit(ctx context.Context, req *walletv1.DepositRequest) (*walletv1.DepositResponse, error) {
-	if req.GetUserId() == "" || req.GetCurrency() == "" || req.GetTxId() == "" {
-		return &walletv1.DepositResponse{
-			Status:  walletv1.DepositResponse_FAILED,
-			Message: "invalid request: user_id/currency/tx_id required",
-		}, nil
+	payload := store.OperationPayload{
+		Type:     store.OpDeposit,
+		UserID:   req.GetUserId(),
+		Currency: strings.ToUpper(req.GetCurrency()),
+		Amount:   req.GetAmount(),
+		TxID:     req.GetTxId(),
+		Meta:     req.GetMeta(),
 	}
-	if req.GetAmount() <= 0 {
-		return &walletv1.DepositResponse{
-			Status:  walletv1.DepositResponse_FAILED,
-			Message: "invalid amount: must be > 0",
-		}, nil
+	if msg, violations := s.validate(payload, req.GetNetwork()); len(violations) > 0 {
+		return fail(s, ctx, invalidArgument(msg, violations), failedDeposit(msg))
+	}
+	if s.mode == ModeRedis {
+		return s.depositRedis(ctx, payload)
+	}
+
+	res, err := s.enqueue(ctx, payload)
+	if err != nil {
+		return fail(s, ctx, unavailable("QUEUE_UNAVAILABLE", "queue error"), failedDeposit("queue error"))
+	}
+	if res.Duplicate {
+		return fail(s, ctx, alreadyExists(payload.TxID, res.Existing), duplicateDeposit(payload.TxID, res.Existing))
 	}
 
-	payload := store.DepositPayload{
+	return &walletv1.DepositResponse{
+		Status:        walletv1.DepositResponse_SUCCESS,
+		Message:       "accepted",
+		OperationId:   payload.TxID,
+		QueuePosition: res.Position,
+		Acquired:      res.Acquired,
+	}, nil
+}
+
+func (s *server) Withdraw(ctx context.Context, req *walletv1.WithdrawRequest) (*walletv1.WithdrawResponse, error) {
+	if s.mode == ModeRedis {
+		return fail(s, ctx, unimplemented(redisModeUnsupported), failedWithdraw(redisModeUnsupported))
+	}
+	payload := store.OperationPayload{
+		Type:     store.OpWithdraw,
 		UserID:   req.GetUserId(),
 		Currency: strings.ToUpper(req.GetCurrency()),
 		Amount:   req.GetAmount(),
 		TxID:     req.GetTxId(),
+		Meta:     req.GetMeta(),
+	}
+	if msg, violations := s.validate(payload, req.GetNetwork()); len(violations) > 0 {
+		return fail(s, ctx, invalidArgument(msg, violations), failedWithdraw(msg))
 	}
 
-	enqCtx, cancel := context.WithTimeout(context.Background(), enqueueTO)
-	_, err := s.queue.EnqueueDeposit(enqCtx, payload)
+	// Cek saldo dilakukan processor saat giliran user ini (atomic di DB), bukan di sini
+	res, err := s.enqueue(ctx, payload)
+	if err != nil {
+		return fail(s, ctx, unavailable("QUEUE_UNAVAILABLE", "queue error"), failedWithdraw("queue error"))
+	}
+	if res.Duplicate {
+		return fail(s, ctx, alreadyExists(payload.TxID, res.Existing), duplicateWithdraw(payload.TxID, res.Existing))
+	}
+
+	return &walletv1.WithdrawResponse{
+		Status:        walletv1.WithdrawResponse_SUCCESS,
+		Message:       "accepted",
+		OperationId:   payload.TxID,
+		QueuePosition: res.Position,
+		Acquired:      res.Acquired,
+	}, nil
+}
+
+func (s *server) Transfer(ctx context.Context, req *walletv1.TransferRequest) (*walletv1.TransferResponse, error) {
+	if s.mode == ModeRedis {
+		return fail(s, ctx, unimplemented(redisModeUnsupported), failedTransfer(redisModeUnsupported))
+	}
+	payload := store.OperationPayload{
+		Type:     store.OpTransfer,
+		UserID:   req.GetFromUserId(),
+		ToUserID: req.GetToUserId(),
+		Currency: strings.ToUpper(req.GetCurrency()),
+		Amount:   req.GetAmount(),
+		TxID:     req.GetTxId(),
+		Meta:     req.GetMeta(),
+	}
+	if msg, violations := s.validate(payload, ""); len(violations) > 0 {
+		return fail(s, ctx, invalidArgument(msg, violations), failedTransfer(msg))
+	}
+
+	// Masuk ke q:{from_user_id}; lihat store.OpTransfer untuk aturan urutan lintas user
+	res, err := s.enqueue(ctx, payload)
+	if err != nil {
+		return fail(s, ctx, unavailable("QUEUE_UNAVAILABLE", "queue error"), failedTransfer("queue error"))
+	}
+	if res.Duplicate {
+		return fail(s, ctx, alreadyExists(payload.TxID, res.Existing), duplicateTransfer(payload.TxID, res.Existing))
+	}
+
+	return &walletv1.TransferResponse{
+		Status:        walletv1.TransferResponse_SUCCESS,
+		Message:       "accepted",
+		OperationId:   payload.TxID,
+		QueuePosition: res.Position,
+		Acquired:      res.Acquired,
+	}, nil
+}
+
+const redisModeUnsupported = "not supported in WALLET_MODE=redis"
+
+// depositRedis: ModeRedis, saldo diterapkan sinkron oleh deposit.lua (dedupe di tx:{user}).
+// Event stream:wallet ditulis di script yang sama, Postgres menyusul lewat async.Persister.
+func (s *server) depositRedis(ctx context.Context, p store.OperationPayload) (*walletv1.DepositResponse, error) {
+	// saldo Redis belum di-backfill: HINCRBY akan mulai dari 0
+	if s.ready != nil && !s.ready() {
+		return fail(s, ctx, unavailable("WARMING_UP", "wallet store warming up"), failedDeposit("wallet store warming up"))
+	}
+
+	var meta map[string]any
+	if len(p.Meta) > 0 {
+		meta = make(map[string]any, len(p.Meta))
+		for k, v := range p.Meta {
+			meta[k] = v
+		}
+	}
+
+	// sama seperti enqueue: script setengah jalan tidak ada, tapi jangan batal karena client pergi
+	depCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), enqueueTO)
+	res, err := s.walletStore.Deposit(depCtx, p.UserID, p.Currency, p.TxID, p.Amount, meta)
 	cancel()
 	if err != nil {
-		logger.Errorf("enqueue error user=%s cur=%s amt=%d tx=%s: %v",
-			payload.UserID, payload.Currency, payload.Amount, payload.TxID, err)
+		logger.Errorf("redis deposit error user=%s cur=%s amt=%d tx=%s: %v", p.UserID, p.Currency, p.Amount, p.TxID, err)
+		return fail(s, ctx, unavailable("STORE_UNAVAILABLE", "wallet store error"), failedDeposit("wallet store error"))
+	}
+
+	switch res.Code {
+	case 1:
 		return &walletv1.DepositResponse{
-			Status:  walletv1.DepositResponse_FAILED,
-			Message: "queue error",
+			Status:      walletv1.DepositResponse_SUCCESS,
+			Message:     "applied",
+			OperationId: p.TxID,
+			Balance:     res.Balance,
 		}, nil
+	case 0:
+		prev := &store.OpStatus{TxID: p.TxID, Type: store.OpDeposit, State: store.OpApplied}
+		legacy := duplicateDeposit(p.TxID, prev)
+		legacy.Balance = res.Balance
+		return fail(s, ctx, alreadyExists(p.TxID, prev), legacy)
+	default: // -2: amount ditolak script
+		msg := "invalid amount: must be > 0"
+		return fail(s, ctx, invalidArgument(msg, []*errdetails.BadRequest_FieldViolation{violation("amount", "must be > 0")}), failedDeposit(msg))
 	}
+}
 
-	return &walletv1.DepositResponse{
-		Status:  walletv1.DepositResponse_SUCCESS,
-		Message: "accepted",
-	}, nil
+// duplicateMessage: hasil asli untuk tx_id yang sudah pernah diterima
+func duplicateMessage(st *store.OpStatus) string {
+	if st.Error != "" {
+		return "duplicate: " + string(st.State) + " (" + st.Error + ")"
+	}
+	return "duplicate: " + string(st.State)
+}
+
+// enqueue: timeout sendiri, tidak ikut cancel dari client (enqueue setengah jalan lebih buruk
+// daripada selesai), tapi tetap membawa span RPC untuk trace
+func (s *server) enqueue(ctx context.Context, payload store.OperationPayload) (store.EnqueueResult, error) {
+	op := string(payload.Op())
+	ctx, span := tracing.Tracer().Start(ctx, "queue.enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("wallet.op", op),
+			attribute.String("wallet.user_id", payload.UserID),
+			attribute.String("wallet.tx_id", payload.TxID),
+		))
+	defer span.End()
+	payload.Trace = tracing.Inject(ctx)
+
+	enqCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), enqueueTO)
+	start := time.Now()
+	res, err := s.queue.Enqueue(enqCtx, payload)
+	cancel()
+	span.SetAttributes(attribute.Bool("wallet.acquired", res.Acquired), attribute.Int64("wallet.position", res.Position))
+	metrics.EnqueueLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		metrics.EnqueueResults.WithLabelValues(op, "error").Inc()
+		logger.Errorf("enqueue error op=%s user=%s cur=%s amt=%d tx=%s: %v",
+			payload.Type, payload.UserID, payload.Currency, payload.Amount, payload.TxID, err)
+		return res, err
+	}
+	metrics.EnqueueResults.WithLabelValues(op, enqueueResultLabel(res)).Inc()
+	return res, nil
+}
+
+func enqueueResultLabel(res store.EnqueueResult) string {
+	switch {
+	case res.Duplicate:
+		return "duplicate"
+	case res.Acquired:
+		return "acquired"
+	default:
+		return "queued"
+	}
 }
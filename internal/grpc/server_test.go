@@ -0,0 +1,308 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"grls/internal/currency"
+	"grls/internal/model"
+	"grls/internal/store"
+	"grls/pkg/cursor"
+	walletv1 "grls/pkg/proto/wallet/v1"
+)
+
+// downQueue: Enqueue selalu gagal (Redis mati)
+type downQueue struct{ store.Queue }
+
+func (downQueue) Enqueue(context.Context, store.OperationPayload) (store.EnqueueResult, error) {
+	return store.EnqueueResult{}, errors.New("dial tcp: connection refused")
+}
+
+func legacyCtx() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-version", "1"))
+}
+
+func TestDepositValidationReturnsFieldViolations(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue(), APIVersion: APIVersionStatus})
+
+	_, err := s.Deposit(context.Background(), &walletv1.DepositRequest{Currency: "usd", TxId: "t1"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %s, want InvalidArgument", st.Code())
+	}
+	var fields []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	if len(fields) != 2 || fields[0] != "user_id" || fields[1] != "amount" {
+		t.Fatalf("field violations = %v, want [user_id amount]", fields)
+	}
+}
+
+func TestTransferRejectsNonNumericUserIDs(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue(), APIVersion: APIVersionStatus})
+
+	for _, tc := range []struct{ from, to, field string }{
+		{"1", "abc", "to_user_id"},
+		{"1", " 7", "to_user_id"},
+		{"1", "0", "to_user_id"},
+		{"x1", "2", "from_user_id"},
+		{"-3", "2", "from_user_id"},
+	} {
+		_, err := s.Transfer(context.Background(), &walletv1.TransferRequest{
+			FromUserId: tc.from, ToUserId: tc.to, Currency: "USD", Amount: 5, TxId: "t1",
+		})
+		st := status.Convert(err)
+		if st.Code() != codes.InvalidArgument {
+			t.Fatalf("%q->%q: code = %s, want InvalidArgument", tc.from, tc.to, st.Code())
+		}
+		var fields []string
+		for _, d := range st.Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok {
+				for _, v := range br.GetFieldViolations() {
+					fields = append(fields, v.GetField())
+				}
+			}
+		}
+		if len(fields) != 1 || fields[0] != tc.field {
+			t.Fatalf("%q->%q: field violations = %v, want [%s]", tc.from, tc.to, fields, tc.field)
+		}
+	}
+}
+
+func TestDepositDuplicateIsAlreadyExistsWithOriginalState(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue(), APIVersion: APIVersionStatus})
+	req := &walletv1.DepositRequest{UserId: "1", Currency: "USD", Amount: 10, TxId: "t1"}
+
+	if _, err := s.Deposit(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Deposit(context.Background(), req)
+	st := status.Convert(err)
+	if st.Code() != codes.AlreadyExists {
+		t.Fatalf("code = %s, want AlreadyExists", st.Code())
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if info.GetMetadata()["state"] != string(store.OpPending) || info.GetMetadata()["operation_id"] != "t1" {
+				t.Fatalf("error info = %v", info.GetMetadata())
+			}
+			return
+		}
+	}
+	t.Fatal("missing ErrorInfo detail")
+}
+
+func TestDepositQueueFailureIsUnavailable(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: downQueue{}, APIVersion: APIVersionStatus})
+	req := &walletv1.DepositRequest{UserId: "1", Currency: "USD", Amount: 10, TxId: "t1"}
+
+	if _, err := s.Deposit(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("code = %s, want Unavailable", status.Code(err))
+	}
+
+	// legacy: error di dalam response sukses
+	resp, err := s.Deposit(legacyCtx(), req)
+	if err != nil || resp.GetStatus() != walletv1.DepositResponse_FAILED || resp.GetMessage() != "queue error" {
+		t.Fatalf("legacy = %v, %v; want FAILED/queue error", resp, err)
+	}
+}
+
+func TestDepositRejectsUnsupportedCurrency(t *testing.T) {
+	s := NewWalletServiceServer(Deps{
+		Queue:      store.NewMemoryQueue(),
+		APIVersion: APIVersionStatus,
+		Currencies: currency.NewStatic(
+			model.Currency{Code: "USD", Decimals: 2, Enabled: true},
+			model.Currency{Code: "SGD", Decimals: 2, Enabled: false},
+			model.Currency{Code: "USDT", Decimals: 6, Network: "TRC20", Enabled: true},
+		),
+	})
+
+	for _, tc := range []struct {
+		currency, network, field, desc string
+	}{
+		{"XYZ", "", "currency", "unsupported currency"},
+		{"sgd", "", "currency", "currency disabled"},
+		{"USDT", "ERC20", "network", "not supported for USDT"},
+	} {
+		_, err := s.Deposit(context.Background(), &walletv1.DepositRequest{
+			UserId: "1", Currency: tc.currency, Network: tc.network, Amount: 10, TxId: "t-" + tc.currency,
+		})
+		st := status.Convert(err)
+		if st.Code() != codes.InvalidArgument {
+			t.Fatalf("%s: code = %s, want InvalidArgument", tc.currency, st.Code())
+		}
+		var got *errdetails.BadRequest_FieldViolation
+		for _, d := range st.Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok && len(br.GetFieldViolations()) == 1 {
+				got = br.GetFieldViolations()[0]
+			}
+		}
+		if got.GetField() != tc.field || got.GetDescription() != tc.desc {
+			t.Fatalf("%s: violation = %v, want %s %q", tc.currency, got, tc.field, tc.desc)
+		}
+	}
+
+	resp, err := s.Deposit(legacyCtx(), &walletv1.DepositRequest{UserId: "1", Currency: "XYZ", Amount: 10, TxId: "t1"})
+	if err != nil || resp.GetStatus() != walletv1.DepositResponse_FAILED || resp.GetMessage() != "invalid currency: unsupported currency" {
+		t.Fatalf("legacy = %v, %v; want FAILED unsupported currency", resp, err)
+	}
+
+	// tidak ada yang masuk antrian: tx_id yang sama masih bisa dipakai
+	resp, err = s.Deposit(context.Background(), &walletv1.DepositRequest{UserId: "1", Currency: "USDT", Network: "TRC20", Amount: 10, TxId: "t1"})
+	if err != nil || resp.GetDuplicate() {
+		t.Fatalf("valid deposit = %v, %v", resp, err)
+	}
+}
+
+func TestFailMapsAPIVersion(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue(), APIVersion: APIVersionStatus})
+	v2 := unavailable("QUEUE_UNAVAILABLE", "queue error")
+
+	resp, err := fail(s, legacyCtx(), v2, failedWithdraw("queue error"))
+	if err != nil || resp.GetStatus() != walletv1.WithdrawResponse_FAILED || resp.GetMessage() != "queue error" {
+		t.Fatalf("v1 = %v, %v; want FAILED response and nil error", resp, err)
+	}
+
+	resp, err = fail(s, context.Background(), v2, failedWithdraw("queue error"))
+	if resp != nil || status.Code(err) != codes.Unavailable {
+		t.Fatalf("v2 = %v, %v; want nil response and Unavailable", resp, err)
+	}
+
+	// duplicate v1: Status mengikuti hasil asli operasi
+	prev := &store.OpStatus{TxID: "t1", State: store.OpFailed, Error: "insufficient balance"}
+	dup, err := fail(s, legacyCtx(), alreadyExists("t1", prev), duplicateTransfer("t1", prev))
+	if err != nil || !dup.GetDuplicate() || dup.GetStatus() != walletv1.TransferResponse_FAILED || dup.GetOperationId() != "t1" {
+		t.Fatalf("v1 duplicate = %v, %v", dup, err)
+	}
+}
+
+func TestDefaultAPIVersionIsLegacy(t *testing.T) {
+	// tanpa GRPC_API_VERSION / header: client lama tetap dapat Status FAILED
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue()})
+
+	resp, err := s.Transfer(context.Background(), &walletv1.TransferRequest{
+		FromUserId: "1", ToUserId: "1", Currency: "USD", Amount: 5, TxId: "t1",
+	})
+	if err != nil || resp.GetStatus() != walletv1.TransferResponse_FAILED {
+		t.Fatalf("legacy transfer = %v, %v; want FAILED response", resp, err)
+	}
+
+	// v2 opt-in per request
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-version", "2"))
+	_, err = s.Transfer(ctx, &walletv1.TransferRequest{FromUserId: "1", ToUserId: "1", Currency: "USD", Amount: 5, TxId: "t1"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("code = %s, want InvalidArgument", status.Code(err))
+	}
+}
+
+func TestRedisModeDepositAppliesSynchronously(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	s := NewWalletServiceServer(Deps{Mode: ModeRedis, APIVersion: APIVersionStatus, WalletStore: store.NewRedisWalletStore(rdb, 1)})
+	req := &walletv1.DepositRequest{UserId: "1", Currency: "usd", Amount: 150, TxId: "t1"}
+
+	resp, err := s.Deposit(context.Background(), req)
+	if err != nil || resp.GetMessage() != "applied" || resp.GetBalance() != 150 {
+		t.Fatalf("deposit = %v, %v; want applied with balance 150", resp, err)
+	}
+
+	if _, err := s.Deposit(context.Background(), req); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("replay code = %s, want AlreadyExists", status.Code(err))
+	}
+	resp, err = s.Deposit(legacyCtx(), req)
+	if err != nil || !resp.GetDuplicate() || resp.GetBalance() != 150 {
+		t.Fatalf("legacy replay = %v, %v; want duplicate with balance 150", resp, err)
+	}
+
+	_, err = s.Withdraw(context.Background(), &walletv1.WithdrawRequest{UserId: "1", Currency: "USD", Amount: 1, TxId: "w1"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("withdraw code = %s, want Unimplemented", status.Code(err))
+	}
+}
+
+func TestRedisModeDepositWaitsForWarmup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ready := false
+	s := NewWalletServiceServer(Deps{
+		Mode:        ModeRedis,
+		APIVersion:  APIVersionStatus,
+		WalletStore: store.NewRedisWalletStore(rdb, 1),
+		Ready:       func() bool { return ready },
+	})
+	req := &walletv1.DepositRequest{UserId: "1", Currency: "USD", Amount: 10, TxId: "t1"}
+
+	if _, err := s.Deposit(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("code during warm-up = %s, want Unavailable", status.Code(err))
+	}
+	ready = true
+	if resp, err := s.Deposit(context.Background(), req); err != nil || resp.GetBalance() != 10 {
+		t.Fatalf("deposit after warm-up = %v, %v", resp, err)
+	}
+}
+
+func TestGetBalanceFromCacheUsesCanonicalUserID(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ws := store.NewRedisWalletStore(rdb, 1)
+	if _, err := ws.Deposit(context.Background(), "7", "USD", "t1", 150, nil); err != nil {
+		t.Fatal(err)
+	}
+	s := NewWalletServiceServer(Deps{Mode: ModeRedis, WalletStore: ws})
+
+	for _, id := range []string{"07", "-1", "0", "x"} {
+		_, err := s.GetBalance(context.Background(), &walletv1.GetBalanceRequest{UserId: id, Currency: "USD", Source: walletv1.BalanceSource_BALANCE_SOURCE_CACHE})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("user_id %q: code = %s, want InvalidArgument", id, status.Code(err))
+		}
+	}
+
+	resp, err := s.GetBalance(context.Background(), &walletv1.GetBalanceRequest{UserId: "7", Currency: "usd", Source: walletv1.BalanceSource_BALANCE_SOURCE_CACHE})
+	if err != nil || resp.GetSource() != walletv1.BalanceSource_BALANCE_SOURCE_CACHE || resp.GetWallet().GetBalance() != "150" {
+		t.Fatalf("cache balance = %v, %v", resp, err)
+	}
+	// is_active tidak diketahui dari cache: tidak dipalsukan
+	if resp.GetWallet().GetIsActive() {
+		t.Fatal("cache read must not claim is_active")
+	}
+}
+
+func TestListTransactionsRejectsCursorFromOtherFilters(t *testing.T) {
+	s := NewWalletServiceServer(Deps{Queue: store.NewMemoryQueue()})
+	usd := &walletv1.ListTransactionsRequest{UserId: "7", Currency: "USD"}
+	token := cursor.Encode(cursor.Cursor{LastID: 10, Filter: historyFilterHash(7, usd)})
+
+	for _, req := range []*walletv1.ListTransactionsRequest{
+		{UserId: "7", Currency: "SGD", Cursor: token},
+		{UserId: "7", Cursor: token},
+		{UserId: "7", Currency: "USD", From: timestamppb.Now(), Cursor: token},
+		{UserId: "8", Currency: "USD", Cursor: token},
+		{UserId: "07", Currency: "USD"},
+	} {
+		if _, err := s.ListTransactions(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("%v: code = %s, want InvalidArgument", req, status.Code(err))
+		}
+	}
+	// filter sama (currency beda huruf besar/kecil) tetap cocok
+	if got := historyFilterHash(7, &walletv1.ListTransactionsRequest{Currency: "usd"}); got != historyFilterHash(7, usd) {
+		t.Fatal("currency case must not change the cursor filter")
+	}
+}
@@ -11,23 +11,74 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	ModeSingle   = "single"
+	ModeCluster  = "cluster"
+	ModeSentinel = "sentinel"
+)
+
+const (
+	poolSize     = 300
+	minIdleConns = 100
+)
+
+// CheckClusterShards: tiap shard {wN} = satu slot, jadi cluster hanya menyebar beban kalau
+// QUEUE_SHARDS ≥ 2 (idealnya ≥ jumlah master). Shards 1 = semua data di satu node.
+func CheckClusterShards(mode string, shards int) error {
+	if mode == ModeCluster && shards < 2 {
+		return fmt.Errorf("REDIS_MODE=cluster requires QUEUE_SHARDS >= 2 (got %d): every key would share one slot", shards)
+	}
+	return nil
+}
+
 func ConnectRedis(ctx context.Context, cfg config.RedisConfig) (redis.UniversalClient, error) {
-	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+	rdb, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// health check
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, err
+	}
+
+	// pre-warm pool (best effort)
+	warm := min(minIdleConns, 64)
+	for i := 0; i < warm; i++ {
+		go func() { _ = rdb.Ping(ctx).Err() }()
+	}
 
+	return rdb, nil
+}
+
+func newClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
 	dbIndex, err := strconv.Atoi(cfg.DB)
 	if err != nil {
 		dbIndex = 0
 	}
 
-	opts := &redis.Options{
-		Addr:            addr,
+	onConnect := func(ctx context.Context, cn *redis.Conn) error {
+		// Biar gampang di-trace di Redis: CLIENT LIST/INFO
+		_ = cn.ClientSetName(ctx, "grls").Err()
+		return nil
+	}
+
+	addrs := cfg.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)}
+	}
+
+	// timeout & pool sama untuk semua mode (di cluster berlaku per node)
+	opts := &redis.UniversalOptions{
+		Addrs:           addrs,
 		Password:        cfg.Password,
 		DB:              dbIndex,
 		DialTimeout:     1 * time.Second,
 		ReadTimeout:     1 * time.Second,
 		WriteTimeout:    1 * time.Second,
-		PoolSize:        300,
-		MinIdleConns:    100,
+		PoolSize:        poolSize,
+		MinIdleConns:    minIdleConns,
 		PoolTimeout:     1 * time.Second,
 		ConnMaxIdleTime: 90 * time.Second,
 		ConnMaxLifetime: 0,
@@ -35,27 +86,26 @@ func ConnectRedis(ctx context.Context, cfg config.RedisConfig) (redis.UniversalC
 		MaxRetries:      0,
 		MinRetryBackoff: 50 * time.Millisecond,
 		MaxRetryBackoff: 200 * time.Millisecond,
-
-		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
-			// Biar gampang di-trace di Redis: CLIENT LIST/INFO
-			_ = cn.ClientSetName(ctx, "grls").Err()
-			return nil
-		},
+		MaxRedirects:    3, // cluster: MOVED/ASK saat resharding
+		OnConnect:       onConnect,
 	}
 
-	rdb := redis.NewClient(opts)
+	switch cfg.Mode {
+	case "", ModeSingle:
+		return redis.NewClient(opts.Simple()), nil
 
-	// health check
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		_ = rdb.Close()
-		return nil, err
-	}
+	case ModeCluster:
+		// semua key satu script berbagi hash tag {wN} (lihat store.Keyspace), jadi aman dari CROSSSLOT
+		return redis.NewClusterClient(opts.Cluster()), nil
 
-	// pre-warm pool (best effort)
-	warm := min(opts.MinIdleConns, 64)
-	for i := 0; i < warm; i++ {
-		go func() { _ = rdb.Ping(ctx).Err() }()
-	}
+	case ModeSentinel:
+		if len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel mode requires REDIS_ADDRS")
+		}
+		opts.MasterName = cfg.MasterName
+		return redis.NewFailoverClient(opts.Failover()), nil
 
-	return rdb, nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", cfg.Mode)
+	}
 }
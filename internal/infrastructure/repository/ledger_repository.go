@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"grls/internal/model"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// posting: satu kaki jurnal; balanceAfter hanya untuk akun wallet
+type posting struct {
+	account      string
+	userID       int64
+	direction    string
+	balanceAfter *string
+}
+
+// insertPostings: tulis posting jurnal untuk satu operasi di transaksi yang sama dengan update wallets
+func insertPostings(tx *gorm.DB, walletTxID int64, rec model.WalletTransaction, meta map[string]string, ps ...posting) error {
+	metaJSON := "{}"
+	if len(meta) > 0 {
+		b, _ := json.Marshal(meta)
+		metaJSON = string(b)
+	}
+
+	entries := make([]model.LedgerEntry, 0, len(ps))
+	for _, p := range ps {
+		entries = append(entries, model.LedgerEntry{
+			WalletTxID:   &walletTxID,
+			TxID:         rec.TxID,
+			OpType:       rec.Type,
+			Account:      p.account,
+			UserID:       p.userID,
+			Currency:     rec.Currency,
+			Direction:    p.direction,
+			Amount:       rec.Amount,
+			BalanceAfter: p.balanceAfter,
+			Meta:         metaJSON,
+		})
+	}
+	return tx.Omit("id", "created_at").Create(&entries).Error
+}
+
+// LedgerBalance: saldo wallet yang diturunkan dari jurnal (CREDIT - DEBIT akun wallet), dari read replica
+func (r *WalletRepository) LedgerBalance(ctx context.Context, userID int64, currency string) (decimal.Decimal, error) {
+	var bal decimal.Decimal
+	err := r.dbRead.WithContext(ctx).Raw(`
+		SELECT COALESCE(SUM(CASE WHEN direction = 'CREDIT' THEN amount ELSE -amount END), 0)
+		FROM ledger_entries
+		WHERE account = 'wallet' AND user_id = ? AND currency = ?
+	`, userID, strings.ToUpper(currency)).Scan(&bal).Error
+	return bal, err
+}
+
+// RebuildBalances: set wallets.balance = saldo dari jurnal untuk semua currency milik user.
+// Row wallets di-lock dulu supaya tidak ada posting baru di tengah perhitungan.
+// Return jumlah wallet yang saldonya berubah (0 = sudah konsisten).
+func (r *WalletRepository) RebuildBalances(ctx context.Context, userID int64) (int64, error) {
+	var changed int64
+	err := r.dbWrite.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked []int64
+		err := tx.Raw(`SELECT id FROM wallets WHERE user_id = ? ORDER BY id FOR UPDATE`, userID).Scan(&locked).Error
+		if err != nil {
+			return err
+		}
+
+		res := tx.Exec(`
+			UPDATE wallets w
+			SET balance = j.balance, updated_at = NOW()
+			FROM (
+				SELECT currency, SUM(CASE WHEN direction = 'CREDIT' THEN amount ELSE -amount END) AS balance
+				FROM ledger_entries
+				WHERE account = 'wallet' AND user_id = ?
+				GROUP BY currency
+			) j
+			WHERE w.user_id = ? AND w.currency = j.currency AND w.balance <> j.balance
+		`, userID, userID)
+		changed = res.RowsAffected
+		return res.Error
+	})
+	return changed, err
+}
+
+// TxHistoryFilter: filter riwayat posting wallet; nilai zero = tanpa batas
+type TxHistoryFilter struct {
+	UserID   int64
+	Currency string
+	From     time.Time // inklusif
+	To       time.Time // eksklusif
+	BeforeID int64     // keyset: hanya id < BeforeID
+	Limit    int
+}
+
+// ListTransactions: posting akun wallet milik user, terbaru dulu (keyset pada id), dari read replica
+func (r *WalletRepository) ListTransactions(ctx context.Context, f TxHistoryFilter) ([]model.LedgerEntry, error) {
+	q := r.dbRead.WithContext(ctx).
+		Where("account = ? AND user_id = ?", model.AccountWallet, f.UserID)
+	if f.Currency != "" {
+		q = q.Where("currency = ?", strings.ToUpper(f.Currency))
+	}
+	if !f.From.IsZero() {
+		q = q.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("created_at < ?", f.To)
+	}
+	if f.BeforeID > 0 {
+		q = q.Where("id < ?", f.BeforeID)
+	}
+
+	var entries []model.LedgerEntry
+	err := q.Order("id DESC").Limit(f.Limit).Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grls/internal/model"
+
+	"github.com/shopspring/decimal"
+)
+
+// MemoryWalletRepository: pengganti WalletRepository di memori untuk test hermetic.
+// Semantik tulis sama: idempotent per (user_id, tx_id), penolakan dicatat FAILED,
+// withdraw/transfer ditolak jika saldo kurang.
+type MemoryWalletRepository struct {
+	// Hook: dipanggil sebelum setiap operasi; error yang dikembalikan diteruskan apa adanya
+	// tanpa mengubah state (simulasi DB down / timeout).
+	Hook func(op string, in OperationInput) error
+	// Now: created_at operasi baru (nil = time.Now)
+	Now func() time.Time
+
+	mu          sync.Mutex
+	nextID      int64
+	balances    map[string]decimal.Decimal // "<user>:<CUR>"
+	txs         map[string]*model.WalletTransaction
+	applied     []model.WalletTransaction // urutan commit APPLIED
+	quarantined []model.QuarantinedPayload
+}
+
+func NewMemoryWalletRepository() *MemoryWalletRepository {
+	return &MemoryWalletRepository{
+		balances: map[string]decimal.Decimal{},
+		txs:      map[string]*model.WalletTransaction{},
+	}
+}
+
+func memKey(userID int64, s string) string {
+	return strconv.FormatInt(userID, 10) + ":" + s
+}
+
+func (r *MemoryWalletRepository) UpsertDepositDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeDeposit, Currency: cur, Amount: in.Amount.String()}
+	return r.applyOnce(ctx, rec, in, func() error {
+		k := memKey(in.UserID, cur)
+		r.balances[k] = r.balances[k].Add(in.Amount)
+		return nil
+	})
+}
+
+func (r *MemoryWalletRepository) WithdrawDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeWithdraw, Currency: cur, Amount: in.Amount.String()}
+	return r.applyOnce(ctx, rec, in, func() error {
+		k := memKey(in.UserID, cur)
+		if r.balances[k].LessThan(in.Amount) {
+			return ErrInsufficientFunds
+		}
+		r.balances[k] = r.balances[k].Sub(in.Amount)
+		return nil
+	})
+}
+
+func (r *MemoryWalletRepository) TransferDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	to := in.ToUserID
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeTransfer, Currency: cur, Amount: in.Amount.String(), ToUserID: &to}
+	return r.applyOnce(ctx, rec, in, func() error {
+		if in.UserID == in.ToUserID {
+			return ErrSameWallet
+		}
+		from, dst := memKey(in.UserID, cur), memKey(in.ToUserID, cur)
+		if r.balances[from].LessThan(in.Amount) {
+			return ErrInsufficientFunds
+		}
+		r.balances[from] = r.balances[from].Sub(in.Amount)
+		r.balances[dst] = r.balances[dst].Add(in.Amount)
+		return nil
+	})
+}
+
+func (r *MemoryWalletRepository) SaveQuarantined(ctx context.Context, q model.QuarantinedPayload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.quarantined {
+		if existing.QuarantineID == q.QuarantineID {
+			return nil
+		}
+	}
+	r.quarantined = append(r.quarantined, q)
+	return nil
+}
+
+// ScanWallets: versi memori WalletRepository.ScanWallets
+func (r *MemoryWalletRepository) ScanWallets(ctx context.Context, after WalletKey, limit int) ([]model.Wallet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]WalletKey, 0, len(r.balances))
+	for k := range r.balances {
+		user, cur, _ := strings.Cut(k, ":")
+		id, _ := strconv.ParseInt(user, 10, 64)
+		wk := WalletKey{UserID: id, Currency: cur}
+		if compareWalletKey(wk, after) > 0 {
+			keys = append(keys, wk)
+		}
+	}
+	slices.SortFunc(keys, compareWalletKey)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	out := make([]model.Wallet, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, r.wallet(k))
+	}
+	return out, nil
+}
+
+// FindWallets: versi memori WalletRepository.FindWallets
+func (r *MemoryWalletRepository) FindWallets(ctx context.Context, keys []WalletKey) ([]model.Wallet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []model.Wallet
+	for _, k := range keys {
+		k.Currency = strings.ToUpper(k.Currency)
+		if _, ok := r.balances[memKey(k.UserID, k.Currency)]; ok {
+			out = append(out, r.wallet(k))
+		}
+	}
+	return out, nil
+}
+
+// AdjustBalanceDecimal: versi memori WalletRepository.AdjustBalanceDecimal
+func (r *MemoryWalletRepository) AdjustBalanceDecimal(ctx context.Context, in OperationInput, expected decimal.Decimal) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	delta := in.Amount.Sub(expected)
+	if delta.IsZero() {
+		return nil, errors.New("adjustment without balance change")
+	}
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeAdjustment, Currency: cur, Amount: delta.Abs().String()}
+	return r.applyOnce(ctx, rec, in, func() error {
+		k := memKey(in.UserID, cur)
+		if !r.balances[k].Equal(expected) {
+			return ErrBalanceChanged
+		}
+		r.balances[k] = in.Amount
+		return nil
+	})
+}
+
+// ScanTransactions: versi memori WalletRepository.ScanTransactions
+func (r *MemoryWalletRepository) ScanTransactions(ctx context.Context, since time.Time, afterID int64, limit int) ([]model.WalletTransaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []model.WalletTransaction
+	for _, t := range r.txs {
+		if t.ID > afterID && !t.CreatedAt.Before(since) {
+			out = append(out, *t)
+		}
+	}
+	slices.SortFunc(out, func(a, b model.WalletTransaction) int { return cmp.Compare(a.ID, b.ID) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// SetBalance: timpa saldo langsung tanpa jurnal (simulasi edit manual / drift di test)
+func (r *MemoryWalletRepository) SetBalance(userID int64, currency string, bal decimal.Decimal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.balances[memKey(userID, strings.ToUpper(currency))] = bal
+}
+
+func (r *MemoryWalletRepository) wallet(k WalletKey) model.Wallet {
+	return model.Wallet{UserID: k.UserID, Currency: k.Currency, Balance: r.balances[memKey(k.UserID, k.Currency)].String(), IsActive: true}
+}
+
+func compareWalletKey(a, b WalletKey) int {
+	if c := cmp.Compare(a.UserID, b.UserID); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Currency, b.Currency)
+}
+
+// Balance: saldo user/currency saat ini
+func (r *MemoryWalletRepository) Balance(userID int64, currency string) decimal.Decimal {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.balances[memKey(userID, strings.ToUpper(currency))]
+}
+
+// Applied: salinan operasi APPLIED sesuai urutan commit
+func (r *MemoryWalletRepository) Applied() []model.WalletTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.WalletTransaction(nil), r.applied...)
+}
+
+// Transaction: hasil operasi per (user_id, tx_id), nil jika belum ada
+func (r *MemoryWalletRepository) Transaction(userID int64, txID string) *model.WalletTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.txs[memKey(userID, txID)]; ok {
+		cp := *t
+		return &cp
+	}
+	return nil
+}
+
+// Quarantined: salinan audit payload rusak yang disimpan
+func (r *MemoryWalletRepository) Quarantined() []model.QuarantinedPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.QuarantinedPayload(nil), r.quarantined...)
+}
+
+// applyOnce: versi memori WalletRepository.applyOnce
+func (r *MemoryWalletRepository) applyOnce(ctx context.Context, rec model.WalletTransaction, in OperationInput, fn func() error) (*model.WalletTransaction, error) {
+	if r.Hook != nil {
+		if err := r.Hook(rec.Type, in); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := memKey(rec.UserID, rec.TxID)
+	if existing, ok := r.txs[k]; ok {
+		cp := *existing
+		return &cp, ErrDuplicateTx
+	}
+
+	r.nextID++
+	rec.ID = r.nextID
+	rec.CreatedAt = time.Now()
+	if r.Now != nil {
+		rec.CreatedAt = r.Now()
+	}
+	rec.Status = model.TxStatusApplied
+	err := fn()
+	if err != nil {
+		if !IsRejection(err) {
+			return nil, err
+		}
+		rec.Status = model.TxStatusFailed
+		rec.Error = err.Error()
+	} else {
+		r.applied = append(r.applied, rec)
+	}
+	r.txs[k] = &rec
+	cp := rec
+	return &cp, err
+}
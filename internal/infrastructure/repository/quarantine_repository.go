@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+
+	"grls/internal/model"
+
+	"gorm.io/gorm/clause"
+)
+
+// SaveQuarantined: salinan audit payload rusak; aman diulang (unik per quarantine_id)
+func (r *WalletRepository) SaveQuarantined(ctx context.Context, q model.QuarantinedPayload) error {
+	return r.dbWrite.WithContext(ctx).
+		Omit("id", "resolved_at", "repaired_payload").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&q).Error
+}
+
+// MarkReinjected: tandai payload sudah diperbaiki & dimasukkan lagi ke q:{user}
+func (r *WalletRepository) MarkReinjected(ctx context.Context, quarantineID, repaired string) error {
+	return r.dbWrite.WithContext(ctx).Exec(`
+		UPDATE quarantined_payloads
+		SET status = ?, repaired_payload = ?, resolved_at = NOW()
+		WHERE quarantine_id = ?
+	`, model.QuarantineStatusReinjected, repaired, quarantineID).Error
+}
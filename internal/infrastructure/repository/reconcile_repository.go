@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"grls/internal/model"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ErrBalanceChanged: saldo wallet sudah berubah sejak dibaca rekonsiliasi; adjustment dibatalkan
+var ErrBalanceChanged = errors.New("balance changed since read")
+
+// WalletKey: identitas wallet (unik per user/currency), juga dipakai sebagai cursor scan
+type WalletKey struct {
+	UserID   int64
+	Currency string
+}
+
+// ScanWallets: wallet dari primary urut (user_id, currency) setelah cursor after.
+// Primary, bukan replica: lag replica akan terbaca sebagai selisih palsu.
+func (r *WalletRepository) ScanWallets(ctx context.Context, after WalletKey, limit int) ([]model.Wallet, error) {
+	var ws []model.Wallet
+	err := r.dbWrite.WithContext(ctx).
+		Where("(user_id, currency) > (?, ?)", after.UserID, strings.ToUpper(after.Currency)).
+		Order("user_id, currency").
+		Limit(limit).
+		Find(&ws).Error
+	return ws, err
+}
+
+// FindWallets: wallet dari primary untuk daftar user/currency; yang tidak ada tidak ikut
+func (r *WalletRepository) FindWallets(ctx context.Context, keys []WalletKey) ([]model.Wallet, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pairs := make([][]any, len(keys))
+	for i, k := range keys {
+		pairs[i] = []any{k.UserID, strings.ToUpper(k.Currency)}
+	}
+	var ws []model.Wallet
+	err := r.dbWrite.WithContext(ctx).
+		Where("(user_id, currency) IN ?", pairs).
+		Find(&ws).Error
+	return ws, err
+}
+
+// AdjustBalanceDecimal: set balance = in.Amount hanya jika saldo saat ini masih expected
+// (wallet yang belum ada dianggap 0 dan dibuat). Dicatat sebagai ADJUSTMENT sebesar selisihnya,
+// sekali per (user_id, tx_id). Jurnal: selisih naik DEBIT clearing/CREDIT wallet, turun sebaliknya.
+func (r *WalletRepository) AdjustBalanceDecimal(ctx context.Context, in OperationInput, expected decimal.Decimal) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	delta := in.Amount.Sub(expected)
+	if delta.IsZero() {
+		return nil, errors.New("adjustment without balance change")
+	}
+
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeAdjustment, Currency: cur, Amount: delta.Abs().String()}
+	return r.applyOnce(ctx, rec, func(tx *gorm.DB, walletTxID int64) error {
+		err := tx.Exec(`
+			INSERT INTO wallets (user_id, currency, balance, is_active)
+			VALUES (?, ?, 0, TRUE)
+			ON CONFLICT (user_id, currency) DO NOTHING
+		`, in.UserID, cur).Error
+		if err != nil {
+			return err
+		}
+
+		var bal string
+		res := tx.Raw(`
+			UPDATE wallets
+			SET balance = ?, updated_at = NOW()
+			WHERE user_id = ? AND currency = ? AND balance = ?
+			RETURNING balance
+		`, in.Amount.String(), in.UserID, cur, expected.String()).Scan(&bal)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrBalanceChanged
+		}
+
+		if delta.IsPositive() {
+			return insertPostings(tx, walletTxID, rec, in.Meta,
+				posting{model.AccountClearing, in.UserID, model.DirectionDebit, nil},
+				posting{model.AccountWallet, in.UserID, model.DirectionCredit, &bal},
+			)
+		}
+		return insertPostings(tx, walletTxID, rec, in.Meta,
+			posting{model.AccountWallet, in.UserID, model.DirectionDebit, &bal},
+			posting{model.AccountClearing, in.UserID, model.DirectionCredit, nil},
+		)
+	})
+}
+
+// ScanTransactions: wallet_transactions sejak since dari primary, urut id setelah afterID (keyset)
+func (r *WalletRepository) ScanTransactions(ctx context.Context, since time.Time, afterID int64, limit int) ([]model.WalletTransaction, error) {
+	var txs []model.WalletTransaction
+	err := r.dbWrite.WithContext(ctx).
+		Where("id > ? AND created_at >= ?", afterID, since).
+		Order("id").
+		Limit(limit).
+		Find(&txs).Error
+	return txs, err
+}
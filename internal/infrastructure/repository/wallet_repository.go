@@ -2,12 +2,36 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"strings"
+	"time"
+
+	"grls/internal/model"
 
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrInsufficientFunds: saldo tidak cukup (atau wallet belum ada) untuk debit
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrWalletInactive: wallet tujuan ada tapi is_active = false
+	ErrWalletInactive = errors.New("wallet inactive")
+	// ErrSameWallet: transfer ke wallet sendiri
+	ErrSameWallet = errors.New("transfer to same wallet")
+	// ErrWalletNotFound: belum ada row wallets untuk user/currency
+	ErrWalletNotFound = errors.New("wallet not found")
+	// ErrDuplicateTx: (user_id, tx_id) sudah pernah diproses; hasil asli dikembalikan bersama error ini
+	ErrDuplicateTx = errors.New("duplicate tx_id")
+)
+
+// IsRejection: error bisnis yang dicatat FAILED dan tidak akan berubah walau di-retry
+func IsRejection(err error) bool {
+	return errors.Is(err, ErrInsufficientFunds) ||
+		errors.Is(err, ErrWalletInactive) ||
+		errors.Is(err, ErrSameWallet)
+}
+
 type WalletRepository struct {
 	dbWrite *gorm.DB
 	dbRead  *gorm.DB
@@ -17,18 +41,253 @@ func NewWalletRepository(dbWrite *gorm.DB, dbRead *gorm.DB) *WalletRepository {
 	return &WalletRepository{dbWrite: dbWrite, dbRead: dbRead}
 }
 
-// UpsertDepositDecimal: balance = balance + amount (NUMERIC(20,8)), idempotensi bisa ditambah nanti via ledger
-func (r *WalletRepository) UpsertDepositDecimal(ctx context.Context, userID int64, currency string, amount decimal.Decimal) error {
-	cur := strings.ToUpper(currency)
-	amtStr := amount.String() // "as-is" (uji coba)
-
-	sql := `
-		INSERT INTO wallets (user_id, currency, balance, is_active)
-		VALUES (?, ?, ?, TRUE)
-		ON CONFLICT (user_id, currency)
-		DO UPDATE SET
-			balance    = wallets.balance + EXCLUDED.balance,
-			updated_at = NOW()
-	`
-	return r.dbWrite.WithContext(ctx).Exec(sql, userID, cur, amtStr).Error
+// OperationInput: input operasi tulis ke repository
+type OperationInput struct {
+	TxID     string
+	UserID   int64
+	ToUserID int64 // hanya untuk TRANSFER
+	Currency string
+	Amount   decimal.Decimal
+	Meta     map[string]string
+}
+
+// UpsertDepositDecimal: balance = balance + amount (NUMERIC(20,8)), sekali per (user_id, tx_id).
+// Jurnal: DEBIT clearing, CREDIT wallet.
+func (r *WalletRepository) UpsertDepositDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	amtStr := in.Amount.String() // major unit, sudah dikonversi dari minor unit oleh caller
+
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeDeposit, Currency: cur, Amount: amtStr}
+	return r.applyOnce(ctx, rec, func(tx *gorm.DB, walletTxID int64) error {
+		sql := `
+			INSERT INTO wallets (user_id, currency, balance, is_active)
+			VALUES (?, ?, ?, TRUE)
+			ON CONFLICT (user_id, currency)
+			DO UPDATE SET
+				balance    = wallets.balance + EXCLUDED.balance,
+				updated_at = NOW()
+			RETURNING balance
+		`
+		var bal string
+		if err := tx.Raw(sql, in.UserID, cur, amtStr).Scan(&bal).Error; err != nil {
+			return err
+		}
+		return insertPostings(tx, walletTxID, rec, in.Meta,
+			posting{model.AccountClearing, in.UserID, model.DirectionDebit, nil},
+			posting{model.AccountWallet, in.UserID, model.DirectionCredit, &bal},
+		)
+	})
+}
+
+// WithdrawDecimal: balance = balance - amount, hanya jika saldo cukup.
+// Cek saldo ada di WHERE sehingga tidak bergantung pada ck_balance_nonneg untuk menolak.
+// Jurnal: DEBIT wallet, CREDIT clearing.
+func (r *WalletRepository) WithdrawDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	amtStr := in.Amount.String()
+
+	rec := model.WalletTransaction{UserID: in.UserID, TxID: in.TxID, Type: model.TxTypeWithdraw, Currency: cur, Amount: amtStr}
+	return r.applyOnce(ctx, rec, func(tx *gorm.DB, walletTxID int64) error {
+		sql := `
+			UPDATE wallets
+			SET balance    = balance - ?,
+				updated_at = NOW()
+			WHERE user_id = ? AND currency = ? AND is_active AND balance >= ?
+			RETURNING balance
+		`
+		var bal string
+		res := tx.Raw(sql, amtStr, in.UserID, cur, amtStr).Scan(&bal)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInsufficientFunds
+		}
+		return insertPostings(tx, walletTxID, rec, in.Meta,
+			posting{model.AccountWallet, in.UserID, model.DirectionDebit, &bal},
+			posting{model.AccountClearing, in.UserID, model.DirectionCredit, nil},
+		)
+	})
+}
+
+// TransferDecimal: debit from + credit to dalam satu transaksi, idempotensi dicatat di sisi from.
+// Row di-lock dengan urutan user_id naik (SELECT ... ORDER BY ... FOR UPDATE) sehingga
+// transfer A→B dan B→A yang jalan bersamaan tidak saling deadlock.
+// Jurnal: DEBIT wallet(from), CREDIT wallet(to).
+func (r *WalletRepository) TransferDecimal(ctx context.Context, in OperationInput) (*model.WalletTransaction, error) {
+	cur := strings.ToUpper(in.Currency)
+	amtStr := in.Amount.String()
+	fromUserID, toUserID := in.UserID, in.ToUserID
+
+	rec := model.WalletTransaction{UserID: fromUserID, TxID: in.TxID, Type: model.TxTypeTransfer, Currency: cur, Amount: amtStr, ToUserID: &toUserID}
+	return r.applyOnce(ctx, rec, func(tx *gorm.DB, walletTxID int64) error {
+		if fromUserID == toUserID {
+			return ErrSameWallet
+		}
+
+		// Wallet penerima dibuat kalau belum ada (sama seperti deposit)
+		err := tx.Exec(`
+			INSERT INTO wallets (user_id, currency, balance, is_active)
+			VALUES (?, ?, 0, TRUE)
+			ON CONFLICT (user_id, currency) DO NOTHING
+		`, toUserID, cur).Error
+		if err != nil {
+			return err
+		}
+
+		var locked []int64
+		err = tx.Raw(`
+			SELECT user_id FROM wallets
+			WHERE currency = ? AND user_id IN (?, ?)
+			ORDER BY user_id
+			FOR UPDATE
+		`, cur, fromUserID, toUserID).Scan(&locked).Error
+		if err != nil {
+			return err
+		}
+
+		var fromBal, toBal string
+		res := tx.Raw(`
+			UPDATE wallets
+			SET balance = balance - ?, updated_at = NOW()
+			WHERE user_id = ? AND currency = ? AND is_active AND balance >= ?
+			RETURNING balance
+		`, amtStr, fromUserID, cur, amtStr).Scan(&fromBal)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInsufficientFunds
+		}
+
+		res = tx.Raw(`
+			UPDATE wallets
+			SET balance = balance + ?, updated_at = NOW()
+			WHERE user_id = ? AND currency = ? AND is_active
+			RETURNING balance
+		`, amtStr, toUserID, cur).Scan(&toBal)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrWalletInactive
+		}
+		return insertPostings(tx, walletTxID, rec, in.Meta,
+			posting{model.AccountWallet, fromUserID, model.DirectionDebit, &fromBal},
+			posting{model.AccountWallet, toUserID, model.DirectionCredit, &toBal},
+		)
+	})
+}
+
+// applyOnce: jalankan fn dalam transaksi yang sama dengan insert wallet_transactions.
+// Unique (user_id, tx_id) menjamin fn hanya commit sekali walau payload diproses ulang
+// (retry gRPC, atau processor crash antara commit DB dan ReleaseAndPromote).
+func (r *WalletRepository) applyOnce(ctx context.Context, rec model.WalletTransaction, fn func(tx *gorm.DB, walletTxID int64) error) (*model.WalletTransaction, error) {
+	rec.Status = model.TxStatusApplied
+	err := r.dbWrite.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		inserted, err := insertTx(tx, &rec)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return ErrDuplicateTx
+		}
+		return fn(tx, rec.ID)
+	})
+
+	switch {
+	case err == nil:
+		return &rec, nil
+	case errors.Is(err, ErrDuplicateTx):
+		return r.duplicateOf(ctx, rec)
+	case IsRejection(err):
+		// Perubahan saldo sudah di-rollback; catat FAILED supaya tx_id yang sama dapat hasil yang sama
+		rec.Status = model.TxStatusFailed
+		rec.Error = err.Error()
+		inserted, ierr := insertTx(r.dbWrite.WithContext(ctx), &rec)
+		if ierr != nil {
+			return nil, ierr
+		}
+		if !inserted {
+			return r.duplicateOf(ctx, rec)
+		}
+		return &rec, err
+	default:
+		return nil, err
+	}
+}
+
+func (r *WalletRepository) duplicateOf(ctx context.Context, rec model.WalletTransaction) (*model.WalletTransaction, error) {
+	existing, err := r.FindTransaction(ctx, rec.UserID, rec.TxID)
+	if err != nil {
+		return nil, err
+	}
+	return existing, ErrDuplicateTx
+}
+
+// insertTx: insert wallet_transactions; inserted=false jika (user_id, tx_id) sudah ada
+func insertTx(db *gorm.DB, rec *model.WalletTransaction) (inserted bool, err error) {
+	var row struct {
+		ID        int64
+		CreatedAt time.Time
+	}
+	err = db.Raw(`
+		INSERT INTO wallet_transactions (user_id, tx_id, type, currency, amount, to_user_id, status, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, tx_id) DO NOTHING
+		RETURNING id, created_at
+	`, rec.UserID, rec.TxID, rec.Type, rec.Currency, rec.Amount, rec.ToUserID, rec.Status, rec.Error).Scan(&row).Error
+	if err != nil {
+		return false, err
+	}
+	if row.ID == 0 {
+		return false, nil
+	}
+	rec.ID = row.ID
+	rec.CreatedAt = row.CreatedAt
+	return true, nil
+}
+
+// FindTransaction: baca hasil operasi dari primary (harus konsisten dengan commit terakhir)
+func (r *WalletRepository) FindTransaction(ctx context.Context, userID int64, txID string) (*model.WalletTransaction, error) {
+	var t model.WalletTransaction
+	err := r.dbWrite.WithContext(ctx).
+		Where("user_id = ? AND tx_id = ?", userID, txID).
+		Take(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetWallet: baca satu wallet dari read replica
+func (r *WalletRepository) GetWallet(ctx context.Context, userID int64, currency string) (*model.Wallet, error) {
+	var w model.Wallet
+	err := r.dbRead.WithContext(ctx).
+		Where("user_id = ? AND currency = ?", userID, strings.ToUpper(currency)).
+		Take(&w).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// ListWallets: semua wallet milik user dari read replica, urut currency
+func (r *WalletRepository) ListWallets(ctx context.Context, userID int64) ([]model.Wallet, error) {
+	var ws []model.Wallet
+	err := r.dbRead.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("currency").
+		Find(&ws).Error
+	return ws, err
+}
+
+// ListCurrencies: isi registry currency (termasuk yang disabled), dari read replica
+func (r *WalletRepository) ListCurrencies(ctx context.Context) ([]model.Currency, error) {
+	var cs []model.Currency
+	err := r.dbRead.WithContext(ctx).Order("code").Find(&cs).Error
+	return cs, err
 }
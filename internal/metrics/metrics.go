@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"grls/pkg/logger"
+)
+
+const namespace = "grls"
+
+// gRPC
+var (
+	GRPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "gRPC requests by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "gRPC handler latency by method and status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. ~4s
+	}, []string{"method", "code"})
+)
+
+// Enqueue (write path gRPC → Queue.Enqueue)
+var (
+	EnqueueLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "enqueue_duration_seconds",
+		Help:      "Queue.Enqueue latency by operation type.",
+		Buckets:   prometheus.ExponentialBuckets(0.0002, 2, 14), // 0.2ms .. ~1.6s
+	}, []string{"op"})
+
+	// result: acquired (langsung jadi head) | queued | duplicate | error
+	EnqueueResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "enqueue_total",
+		Help:      "Enqueue outcomes; acquired vs queued shows how often a user already had work in flight.",
+	}, []string{"op", "result"})
+)
+
+// Processor
+var (
+	// result: applied | duplicate | rejected | retry | dead_letter | lease_lost
+	ProcessedOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processor",
+		Name:      "operations_total",
+		Help:      "Queue heads handled by the processor by operation type and outcome.",
+	}, []string{"op", "result"})
+
+	DBExecLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "processor",
+		Name:      "db_exec_duration_seconds",
+		Help:      "Postgres apply latency per operation type.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"op"})
+
+	Retries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processor",
+		Name:      "retries_total",
+		Help:      "Heads rescheduled with backoff after a transient failure.",
+	})
+
+	DecodeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "processor",
+		Name:      "decode_failures_total",
+		Help:      "Heads that could not be decoded and were quarantined.",
+	})
+)
+
+// Queue (disampel periodik dari Redis, lihat StartQueueSampler)
+var (
+	ReadyLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "ready_length",
+		Help:      "Users waiting to be claimed (ready list, or sched stream lag), per shard.",
+	}, []string{"shard"})
+
+	UserQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "user_queue_depth",
+		Help:      "Sampled per-user queue depth of users waiting to be claimed.",
+		Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 250, 1000},
+	})
+)
+
+// Persister (WALLET_MODE=redis, stream:wallet → Postgres)
+var (
+	// result: applied | duplicate | reversed | error
+	PersistedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "persister",
+		Name:      "events_total",
+		Help:      "stream:wallet events handled by the persister by outcome.",
+	}, []string{"result"})
+
+	PersisterLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "persister",
+		Name:      "lag",
+		Help:      "stream:wallet entries after the group's last-delivered-id, per shard.",
+	}, []string{"shard"})
+
+	PersisterPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "persister",
+		Name:      "pending",
+		Help:      "Delivered but not yet acknowledged (not committed) events, per shard.",
+	}, []string{"shard"})
+
+	ReclaimedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "persister",
+		Name:      "reclaimed_total",
+		Help:      "Pending events taken over from idle consumers with XAUTOCLAIM.",
+	})
+
+	DeadLetteredEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "persister",
+		Name:      "dead_lettered_total",
+		Help:      "Events acknowledged into dlq:events:wallet after exhausting their delivery attempts.",
+	})
+)
+
+// Rekonsiliasi Redis ↔ Postgres (periodik)
+var (
+	// kind: AMOUNT | SCALE | MISSING_REDIS | MISSING_POSTGRES (hasil run terakhir)
+	ReconcileMismatches = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "mismatches",
+		Help:      "Balance mismatches between Redis and Postgres found by the last reconcile run.",
+	}, []string{"kind"})
+
+	ReconcileRepaired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "repaired_total",
+		Help:      "Balances repaired by reconcile runs.",
+	})
+
+	ReconcileLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last completed reconcile run.",
+	})
+)
+
+// Serve: HTTP /metrics di addr (mis. ":9090") sampai ctx selesai. addr kosong = nonaktif.
+func Serve(ctx context.Context, addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Infof("📈 metrics listening on %s/metrics", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("❌ metrics server error: " + err.Error())
+	}
+}
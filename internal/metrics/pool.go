@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/redis/go-redis/v9"
+)
+
+// RegisterDBStats: statistik pool database/sql (label db_name = write/read)
+func RegisterDBStats(name string, db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterRedisPool: statistik pool go-redis dari PoolStats() saat scrape
+func RegisterRedisPool(rdb redis.UniversalClient) {
+	prometheus.MustRegister(&redisPoolCollector{rdb: rdb})
+}
+
+type redisPoolCollector struct {
+	rdb redis.UniversalClient
+}
+
+var (
+	redisPoolHits     = poolDesc("hits_total", "Times a free connection was found in the pool.")
+	redisPoolMisses   = poolDesc("misses_total", "Times a free connection was not found in the pool.")
+	redisPoolTimeouts = poolDesc("timeouts_total", "Times a wait for a connection timed out.")
+	redisPoolTotal    = poolDesc("connections", "Connections in the pool.")
+	redisPoolIdle     = poolDesc("idle_connections", "Idle connections in the pool.")
+	redisPoolStale    = poolDesc("stale_connections_total", "Stale connections removed from the pool.")
+)
+
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis_pool", name), help, nil, nil)
+}
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolHits
+	ch <- redisPoolMisses
+	ch <- redisPoolTimeouts
+	ch <- redisPoolTotal
+	ch <- redisPoolIdle
+	ch <- redisPoolStale
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.rdb.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisPoolHits, prometheus.CounterValue, float64(st.Hits))
+	ch <- prometheus.MustNewConstMetric(redisPoolMisses, prometheus.CounterValue, float64(st.Misses))
+	ch <- prometheus.MustNewConstMetric(redisPoolTimeouts, prometheus.CounterValue, float64(st.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisPoolTotal, prometheus.GaugeValue, float64(st.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolIdle, prometheus.GaugeValue, float64(st.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolStale, prometheus.CounterValue, float64(st.StaleConns))
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"grls/internal/store"
+	"grls/pkg/logger"
+)
+
+// QueueSampler: backend Queue yang bisa disampel panjang antriannya (RedisQueue, StreamQueue)
+type QueueSampler interface {
+	Sample(ctx context.Context, perShard int64) (store.QueueSample, error)
+}
+
+// StartQueueSampler: sampel ready & kedalaman antrian user tiap interval sampai ctx selesai.
+// Backend yang tidak mengimplementasikan QueueSampler dilewati dengan warning.
+func StartQueueSampler(ctx context.Context, q store.Queue, interval time.Duration) {
+	s, ok := q.(QueueSampler)
+	if !ok {
+		logger.Warnf("queue sampler disabled: %T does not implement Sample", q)
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				sampleOnce(ctx, s)
+			}
+		}
+	}()
+}
+
+func sampleOnce(ctx context.Context, s QueueSampler) {
+	sampleCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	sample, err := s.Sample(sampleCtx, 100)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warnf("queue sample err: %v", err)
+		}
+		return
+	}
+	for shard, n := range sample.Ready {
+		ReadyLength.WithLabelValues(strconv.Itoa(shard)).Set(float64(n))
+	}
+	for _, d := range sample.Depths {
+		UserQueueDepth.Observe(float64(d))
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// Currency: registry currency. Amount di API = minor unit (integer), di Postgres =
+// NUMERIC(20,8) dalam major unit; konversinya pakai Decimals (USD 2: 100 = 1.00).
+type Currency struct {
+	Code      string    `json:"code"       gorm:"column:code;primaryKey;type:VARCHAR(10)"`
+	Name      string    `json:"name"       gorm:"column:name;type:VARCHAR(64);not null;default:''"`
+	Decimals  int32     `json:"decimals"   gorm:"column:decimals;type:SMALLINT;not null"`
+	Network   string    `json:"network"    gorm:"column:network;type:VARCHAR(32);not null;default:''"` // crypto: TRC20/ERC20/...; kosong = fiat
+	Enabled   bool      `json:"enabled"    gorm:"column:enabled;not null;default:true"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+}
+
+func (Currency) TableName() string { return "currencies" }
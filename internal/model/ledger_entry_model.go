@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+const (
+	AccountWallet   = "wallet"   // saldo user
+	AccountClearing = "clearing" // lawan transaksi dari/ke luar sistem
+
+	DirectionDebit  = "DEBIT"
+	DirectionCredit = "CREDIT"
+)
+
+// LedgerEntry: satu posting jurnal double-entry. Satu operasi menghasilkan
+// minimal dua posting dengan total DEBIT = total CREDIT.
+type LedgerEntry struct {
+	ID           int64     `json:"id"            gorm:"column:id;primaryKey"`
+	WalletTxID   *int64    `json:"wallet_tx_id"  gorm:"column:wallet_tx_id"`
+	TxID         string    `json:"tx_id"         gorm:"column:tx_id;type:VARCHAR(128);not null"`
+	OpType       string    `json:"op_type"       gorm:"column:op_type;type:VARCHAR(16);not null"`
+	Account      string    `json:"account"       gorm:"column:account;type:VARCHAR(16);not null"`
+	UserID       int64     `json:"user_id"       gorm:"column:user_id;not null"`
+	Currency     string    `json:"currency"      gorm:"column:currency;type:VARCHAR(10);not null"`
+	Direction    string    `json:"direction"     gorm:"column:direction;type:VARCHAR(6);not null"`
+	Amount       string    `json:"amount"        gorm:"column:amount;type:NUMERIC(20,8);not null"`
+	BalanceAfter *string   `json:"balance_after" gorm:"column:balance_after;type:NUMERIC(20,8)"`
+	Meta         string    `json:"meta"          gorm:"column:meta;type:jsonb;not null;default:'{}'"`
+	CreatedAt    time.Time `json:"created_at"    gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+}
+
+func (LedgerEntry) TableName() string { return "ledger_entries" }
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+const (
+	QuarantineStatusQuarantined = "QUARANTINED"
+	QuarantineStatusReinjected  = "REINJECTED"
+)
+
+// QuarantinedPayload: head q:{user} yang gagal di-decode, disimpan mentah untuk audit
+type QuarantinedPayload struct {
+	ID              int64      `json:"id"               gorm:"column:id;primaryKey"`
+	QuarantineID    string     `json:"quarantine_id"    gorm:"column:quarantine_id;type:VARCHAR(160);not null"`
+	UserID          string     `json:"user_id"          gorm:"column:user_id;type:VARCHAR(64);not null"`
+	RawPayload      string     `json:"raw_payload"      gorm:"column:raw_payload;not null"`
+	Error           string     `json:"error"            gorm:"column:error;not null"`
+	Status          string     `json:"status"           gorm:"column:status;type:VARCHAR(16);not null;default:'QUARANTINED'"`
+	RepairedPayload *string    `json:"repaired_payload" gorm:"column:repaired_payload"`
+	CreatedAt       time.Time  `json:"created_at"       gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	ResolvedAt      *time.Time `json:"resolved_at"      gorm:"column:resolved_at;type:timestamptz"`
+}
+
+func (QuarantinedPayload) TableName() string { return "quarantined_payloads" }
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+const (
+	TxTypeDeposit    = "DEPOSIT"
+	TxTypeWithdraw   = "WITHDRAW"
+	TxTypeTransfer   = "TRANSFER"
+	TxTypeAdjustment = "ADJUSTMENT" // koreksi saldo dari rekonsiliasi
+
+	TxStatusApplied = "APPLIED"
+	TxStatusFailed  = "FAILED"
+)
+
+// WalletTransaction: hasil akhir satu operasi, unik per (user_id, tx_id).
+// Dipakai untuk idempotensi: operasi dengan tx_id yang sama mengembalikan row ini.
+type WalletTransaction struct {
+	ID        int64     `json:"id"         gorm:"column:id;primaryKey"`
+	UserID    int64     `json:"user_id"    gorm:"column:user_id;not null"`
+	TxID      string    `json:"tx_id"      gorm:"column:tx_id;type:VARCHAR(128);not null"`
+	Type      string    `json:"type"       gorm:"column:type;type:VARCHAR(16);not null"`
+	Currency  string    `json:"currency"   gorm:"column:currency;type:VARCHAR(10);not null"`
+	Amount    string    `json:"amount"     gorm:"column:amount;type:NUMERIC(20,8);not null"`
+	ToUserID  *int64    `json:"to_user_id" gorm:"column:to_user_id"`
+	Status    string    `json:"status"     gorm:"column:status;type:VARCHAR(16);not null"`
+	Error     string    `json:"error"      gorm:"column:error;not null;default:''"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+}
+
+func (WalletTransaction) TableName() string { return "wallet_transactions" }
@@ -0,0 +1,48 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"grls/internal/metrics"
+	"grls/pkg/logger"
+)
+
+// RunEvery: jalankan Run tiap interval sampai ctx selesai; selisih dicatat di log & metrics
+func (r *Reconciler) RunEvery(ctx context.Context, interval time.Duration, opts Options) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		rep, err := r.Run(ctx, opts)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Errorf("reconcile run: %v", err)
+			}
+			continue
+		}
+		Observe(rep)
+	}
+}
+
+// Observe: catat hasil satu run ke log & metrics
+func Observe(rep *Report) {
+	counts := map[Kind]int{KindAmount: 0, KindScale: 0, KindMissingRedis: 0, KindMissingPostgres: 0}
+	for _, m := range rep.Mismatches {
+		counts[m.Kind]++
+		logger.Warnf("reconcile mismatch user=%s cur=%s kind=%s redis=%s postgres=%s diff=%s action=%q repaired=%v %s %s",
+			m.UserID, m.Currency, m.Kind, minorString(m.RedisMinor), m.Postgres, m.Diff, m.Action, m.Repaired, m.Note, m.Error)
+	}
+	for kind, n := range counts {
+		metrics.ReconcileMismatches.WithLabelValues(string(kind)).Set(float64(n))
+	}
+	metrics.ReconcileRepaired.Add(float64(rep.Repaired))
+	metrics.ReconcileLastSuccess.SetToCurrentTime()
+	logger.Infof("reconcile done wallets=%d redis_keys=%d mismatches=%d repaired=%d repair=%s dry_run=%v in %s",
+		rep.Wallets, rep.RedisKeys, len(rep.Mismatches), rep.Repaired, rep.Repair, rep.DryRun, rep.Duration)
+}
@@ -0,0 +1,379 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/model"
+	"grls/internal/store"
+)
+
+// Repository: operasi Postgres yang dipakai rekonsiliasi.
+// Implementasi: *repository.WalletRepository, *repository.MemoryWalletRepository (test).
+type Repository interface {
+	ScanWallets(ctx context.Context, after repository.WalletKey, limit int) ([]model.Wallet, error)
+	FindWallets(ctx context.Context, keys []repository.WalletKey) ([]model.Wallet, error)
+	AdjustBalanceDecimal(ctx context.Context, in repository.OperationInput, expected decimal.Decimal) (*model.WalletTransaction, error)
+}
+
+var (
+	_ Repository = (*repository.WalletRepository)(nil)
+	_ Repository = (*repository.MemoryWalletRepository)(nil)
+)
+
+// Direction: sisi yang diperbaiki (sisi lain dianggap benar)
+type Direction string
+
+const (
+	RepairNone       Direction = "none"
+	RepairToRedis    Direction = "redis"    // Postgres benar → timpa balance:{wN}:<user>:<CUR>
+	RepairToPostgres Direction = "postgres" // Redis benar → ADJUSTMENT di wallets + jurnal
+)
+
+func ParseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(s)); d {
+	case "", RepairNone:
+		return RepairNone, nil
+	case RepairToRedis, RepairToPostgres:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown repair direction %q (none|redis|postgres)", s)
+	}
+}
+
+// Kind: jenis selisih
+type Kind string
+
+const (
+	KindAmount          Kind = "AMOUNT"           // nilai beda
+	KindScale           Kind = "SCALE"            // beda skala minor unit vs NUMERIC(20,8), lihat Note
+	KindMissingRedis    Kind = "MISSING_REDIS"    // wallet Postgres saldo > 0, key Redis tidak ada
+	KindMissingPostgres Kind = "MISSING_POSTGRES" // key Redis saldo != 0, wallet Postgres tidak ada
+)
+
+type Options struct {
+	Repair Direction
+	DryRun bool // laporkan Action tanpa menulis apa pun
+}
+
+// Mismatch: satu user/currency yang tidak sama di kedua store
+type Mismatch struct {
+	UserID       string `json:"user_id"`
+	Currency     string `json:"currency"`
+	Kind         Kind   `json:"kind"`
+	Decimals     int32  `json:"decimals"`
+	RedisMinor   *int64 `json:"redis_minor,omitempty"`   // balance:{wN}:<user>:<CUR> (minor units)
+	RedisDecimal string `json:"redis_decimal,omitempty"` // RedisMinor / 10^Decimals
+	Postgres     string `json:"postgres,omitempty"`      // wallets.balance apa adanya
+	Diff         string `json:"diff"`                    // postgres - redis_decimal
+	Note         string `json:"note,omitempty"`
+	Action       string `json:"action,omitempty"` // repair yang dilakukan (atau akan, saat dry-run)
+	Repaired     bool   `json:"repaired"`
+	Error        string `json:"error,omitempty"`
+
+	pg  *decimal.Decimal
+	tip string // ID terakhir stream:wallet shard user, dibaca setelah saldo Redis; "" = tidak diketahui
+}
+
+type Report struct {
+	StartedAt  time.Time     `json:"started_at"`
+	Duration   time.Duration `json:"duration"`
+	Repair     Direction     `json:"repair"`
+	DryRun     bool          `json:"dry_run"`
+	Wallets    int           `json:"wallets"`    // wallet Postgres yang dicek
+	RedisKeys  int           `json:"redis_keys"` // key balance Redis yang dicek
+	Repaired   int           `json:"repaired"`
+	Mismatches []Mismatch    `json:"mismatches"`
+}
+
+// Reconciler: bandingkan saldo Redis (minor units) dengan wallets.balance (NUMERIC(20,8))
+type Reconciler struct {
+	Store *store.RedisWalletStore
+	Repo  Repository
+	Batch int
+	// Decimals: jumlah desimal minor unit per currency (currency.Registry.Decimals).
+	// Default 0: minor unit dianggap sama dengan NUMERIC.
+	Decimals func(currency string) int32
+}
+
+func New(ws *store.RedisWalletStore, repo Repository) *Reconciler {
+	return &Reconciler{
+		Store:    ws,
+		Repo:     repo,
+		Batch:    500,
+		Decimals: func(string) int32 { return 0 },
+	}
+}
+
+// Run: dua pass — wallet Postgres (keyset) lalu SCAN balance Redis untuk key yang tidak
+// punya wallet. Repair hanya menulis jika sisi target masih bernilai sama seperti saat dibaca
+// dan semua event stream:wallet yang sudah masuk saldo Redis tadi sudah dipersist (lihat held).
+func (r *Reconciler) Run(ctx context.Context, opts Options) (*Report, error) {
+	rep := &Report{StartedAt: time.Now(), Repair: opts.Repair, DryRun: opts.DryRun}
+	if rep.Repair == "" {
+		rep.Repair = RepairNone
+	}
+	run := &run{Reconciler: r, opts: opts, rep: rep}
+
+	// pass 1: Postgres → Redis
+	after := repository.WalletKey{}
+	for {
+		ws, err := r.Repo.ScanWallets(ctx, after, r.Batch)
+		if err != nil {
+			return rep, fmt.Errorf("scan wallets: %w", err)
+		}
+		if len(ws) == 0 {
+			break
+		}
+		keys := make([]store.BalanceKey, len(ws))
+		for i, w := range ws {
+			keys[i] = store.BalanceKey{UserID: strconv.FormatInt(w.UserID, 10), Currency: w.Currency}
+		}
+		redisBal, err := r.Store.GetBalances(ctx, keys)
+		if err != nil {
+			return rep, fmt.Errorf("redis balances: %w", err)
+		}
+		run.loadTips(ctx)
+		for i, w := range ws {
+			pg, err := decimal.NewFromString(w.Balance)
+			if err != nil {
+				return rep, fmt.Errorf("wallet %d/%s balance %q: %w", w.UserID, w.Currency, w.Balance, err)
+			}
+			var rb *int64
+			if v, ok := redisBal[keys[i]]; ok {
+				rb = &v
+			}
+			run.check(ctx, keys[i], rb, &pg)
+		}
+		rep.Wallets += len(ws)
+		last := ws[len(ws)-1]
+		after = repository.WalletKey{UserID: last.UserID, Currency: last.Currency}
+	}
+
+	// pass 2: key Redis tanpa wallet Postgres
+	err := r.Store.ScanBalances(ctx, int64(r.Batch), func(entries []store.BalanceEntry) error {
+		rep.RedisKeys += len(entries)
+		run.loadTips(ctx)
+		keys := make([]repository.WalletKey, 0, len(entries))
+		for _, e := range entries {
+			if id, err := strconv.ParseInt(e.UserID, 10, 64); err == nil {
+				keys = append(keys, repository.WalletKey{UserID: id, Currency: e.Currency})
+			}
+		}
+		found, err := r.Repo.FindWallets(ctx, keys)
+		if err != nil {
+			return err
+		}
+		seen := make(map[store.BalanceKey]bool, len(found))
+		for _, w := range found {
+			seen[store.BalanceKey{UserID: strconv.FormatInt(w.UserID, 10), Currency: w.Currency}] = true
+		}
+		for _, e := range entries {
+			if !seen[e.BalanceKey] {
+				amt := e.Amount
+				run.check(ctx, e.BalanceKey, &amt, nil)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return rep, fmt.Errorf("scan redis balances: %w", err)
+	}
+
+	rep.Duration = time.Since(rep.StartedAt)
+	return rep, nil
+}
+
+// run: state satu kali Run
+type run struct {
+	*Reconciler
+	opts Options
+	rep  *Report
+	tips map[int]string // shard → tip stream:wallet setelah chunk saldo terakhir dibaca
+}
+
+// loadTips: watermark stream:wallet per shard, dipanggil tepat SETELAH saldo Redis satu
+// chunk dibaca. Event di atas tip belum ada di saldo itu; yang <= tip harus sudah dipersist
+// sebelum repair, kalau tidak persister akan menerapkannya lagi setelah repair (dobel).
+func (r *run) loadTips(ctx context.Context) {
+	if r.opts.Repair == RepairNone {
+		return
+	}
+	r.tips = make(map[int]string, r.Store.Keys.Shards)
+	for shard := 0; shard < r.Store.Keys.Shards; shard++ {
+		if tip, err := r.Store.StreamTip(ctx, shard); err == nil {
+			r.tips[shard] = tip
+		}
+	}
+}
+
+func (r *run) check(ctx context.Context, key store.BalanceKey, redisMinor *int64, pg *decimal.Decimal) {
+	dec := r.Decimals(key.Currency)
+	m := Mismatch{UserID: key.UserID, Currency: key.Currency, Decimals: dec, RedisMinor: redisMinor, pg: pg}
+	m.tip = r.tips[r.Store.Keys.Shard(key.UserID)]
+
+	rd := decimal.Zero
+	if redisMinor != nil {
+		rd = decimal.New(*redisMinor, -dec)
+		m.RedisDecimal = rd.String()
+	}
+	pgVal := decimal.Zero
+	if pg != nil {
+		pgVal = *pg
+		m.Postgres = pg.String()
+	}
+	m.Diff = pgVal.Sub(rd).String()
+
+	subMinor := !pgVal.Equal(pgVal.Truncate(dec))
+	switch {
+	case pgVal.Equal(rd):
+		return // termasuk key Redis 0 tanpa wallet / wallet 0 tanpa key Redis
+	case redisMinor == nil:
+		m.Kind = KindMissingRedis
+	case pg == nil:
+		m.Kind = KindMissingPostgres
+	case subMinor:
+		m.Kind = KindScale
+	case dec > 0 && pgVal.Equal(decimal.NewFromInt(*redisMinor)):
+		m.Kind = KindScale
+		m.Note = fmt.Sprintf("postgres holds raw minor units (expected %s = %d / 10^%d)", rd, *redisMinor, dec)
+	default:
+		m.Kind = KindAmount
+	}
+	if subMinor {
+		m.Note = fmt.Sprintf("postgres has precision below the minor unit (%d decimals): %s", dec, pgVal)
+	}
+
+	r.repair(ctx, &m)
+	if m.Repaired {
+		r.rep.Repaired++
+	}
+	r.rep.Mismatches = append(r.rep.Mismatches, m)
+}
+
+func (r *run) repair(ctx context.Context, m *Mismatch) {
+	switch r.opts.Repair {
+	case RepairToRedis:
+		if m.pg == nil {
+			m.Error = "not repaired: no postgres wallet to copy from"
+			return
+		}
+		if !m.pg.Equal(m.pg.Truncate(m.Decimals)) {
+			m.Error = "not repaired: postgres value not representable in minor units"
+			return
+		}
+		target := m.pg.Shift(m.Decimals).IntPart()
+		m.Action = fmt.Sprintf("set redis %s -> %d", minorString(m.RedisMinor), target)
+		if r.held(ctx, m) || r.opts.DryRun {
+			return
+		}
+		// CAS di bawah hanya menjaga sisi Redis; event yang baru dipersist sejak wallet
+		// dibaca mengubah Postgres, jadi nilai sumbernya dicek ulang
+		if changed, err := r.postgresChanged(ctx, m); err != nil || changed {
+			m.Error = "not repaired: postgres balance changed since read"
+			if err != nil {
+				m.Error = err.Error()
+			}
+			return
+		}
+		ok, err := r.Store.SetBalanceIf(ctx, m.UserID, m.Currency, m.RedisMinor, target)
+		switch {
+		case err != nil:
+			m.Error = err.Error()
+		case !ok:
+			m.Error = "not repaired: redis balance changed since read"
+		default:
+			m.Repaired = true
+		}
+
+	case RepairToPostgres:
+		if m.RedisMinor == nil {
+			m.Error = "not repaired: no redis balance to copy from"
+			return
+		}
+		userID, err := strconv.ParseInt(m.UserID, 10, 64)
+		if err != nil {
+			m.Error = "not repaired: non-numeric user id"
+			return
+		}
+		expected := decimal.Zero
+		if m.pg != nil {
+			expected = *m.pg
+		}
+		target := decimal.New(*m.RedisMinor, -m.Decimals)
+		m.Action = fmt.Sprintf("adjust postgres %s -> %s", expected, target)
+		if r.held(ctx, m) || r.opts.DryRun {
+			return
+		}
+		_, err = r.Repo.AdjustBalanceDecimal(ctx, repository.OperationInput{
+			TxID:     fmt.Sprintf("reconcile:%d:%s", r.rep.StartedAt.UnixMilli(), m.Currency),
+			UserID:   userID,
+			Currency: m.Currency,
+			Amount:   target,
+			Meta:     map[string]string{"source": "reconcile", "kind": string(m.Kind), "redis_minor": strconv.FormatInt(*m.RedisMinor, 10)},
+		}, expected)
+		switch {
+		case errors.Is(err, repository.ErrBalanceChanged):
+			m.Error = "not repaired: postgres balance changed since read"
+		case err != nil:
+			m.Error = err.Error()
+		default:
+			m.Repaired = true
+		}
+	}
+}
+
+// held: repair ditahan kalau event stream:wallet <= m.tip (sudah masuk saldo Redis yang
+// dibandingkan) belum di-ACK persister. Dicek ulang per repair, tepat sebelum menulis.
+func (r *run) held(ctx context.Context, m *Mismatch) bool {
+	shard := r.Store.Keys.Shard(m.UserID)
+	if m.tip == "" {
+		m.Error = "not repaired: " + r.Store.StreamKeyName(shard) + " tip unknown"
+		return true
+	}
+	ok, reason, err := r.Store.EventsPersistedThrough(ctx, shard, m.tip)
+	switch {
+	case err != nil:
+		m.Error = fmt.Sprintf("not repaired: %s lag unknown: %v", r.Store.StreamKeyName(shard), err)
+	case !ok:
+		m.Error = "not repaired: " + reason
+	}
+	return !ok
+}
+
+// postgresChanged: wallets.balance sudah bukan m.pg lagi (atau wallet baru muncul)
+func (r *run) postgresChanged(ctx context.Context, m *Mismatch) (bool, error) {
+	userID, err := strconv.ParseInt(m.UserID, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	ws, err := r.Repo.FindWallets(ctx, []repository.WalletKey{{UserID: userID, Currency: m.Currency}})
+	if err != nil {
+		return false, err
+	}
+	if len(ws) == 0 {
+		return m.pg != nil, nil
+	}
+	if m.pg == nil {
+		return true, nil
+	}
+	cur, err := decimal.NewFromString(ws[0].Balance)
+	if err != nil {
+		return false, err
+	}
+	return !cur.Equal(*m.pg), nil
+}
+
+func minorString(v *int64) string {
+	if v == nil {
+		return "(missing)"
+	}
+	return strconv.FormatInt(*v, 10)
+}
@@ -0,0 +1,233 @@
+package reconcile
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/model"
+	"grls/internal/store"
+)
+
+func newTestReconciler(t *testing.T) (*Reconciler, *store.RedisWalletStore, *repository.MemoryWalletRepository) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	ws := store.NewRedisWalletStore(rdb, 2)
+	repo := repository.NewMemoryWalletRepository()
+	r := New(ws, repo)
+	r.Batch = 2 // paksa beberapa halaman
+	r.Decimals = func(cur string) int32 {
+		if cur == "USD" {
+			return 2
+		}
+		return 0
+	}
+	return r, ws, repo
+}
+
+// seedRedis: set saldo tanpa event stream:wallet (persister dianggap sudah menyusul)
+func seedRedis(t *testing.T, ws *store.RedisWalletStore, user, cur string, minor int64) {
+	t.Helper()
+	if ok, err := ws.SetBalanceIf(context.Background(), user, cur, nil, minor); err != nil || !ok {
+		t.Fatalf("seed redis %s/%s: %v, %v", user, cur, ok, err)
+	}
+}
+
+func kinds(rep *Report) string {
+	var out []string
+	for _, m := range rep.Mismatches {
+		out = append(out, m.UserID+":"+m.Currency+":"+string(m.Kind))
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func seedDrift(t *testing.T, ws *store.RedisWalletStore, repo *repository.MemoryWalletRepository) {
+	seedRedis(t, ws, "1", "USD", 150)
+	repo.SetBalance(1, "USD", decimal.RequireFromString("1.5")) // sama (150 sen)
+	seedRedis(t, ws, "2", "IDR", 100)
+	repo.SetBalance(2, "IDR", decimal.NewFromInt(90))
+	repo.SetBalance(3, "IDR", decimal.NewFromInt(5))
+	seedRedis(t, ws, "4", "IDR", 7)
+	seedRedis(t, ws, "5", "USD", 250)
+	repo.SetBalance(5, "USD", decimal.NewFromInt(250)) // minor unit mentah
+	seedRedis(t, ws, "6", "USD", 123)
+	repo.SetBalance(6, "USD", decimal.RequireFromString("1.234"))
+	repo.SetBalance(7, "IDR", decimal.Zero) // wallet 0 tanpa key Redis: bukan selisih
+}
+
+func TestReconcileReportsMismatchKinds(t *testing.T) {
+	r, ws, repo := newTestReconciler(t)
+	seedDrift(t, ws, repo)
+
+	rep, err := r.Run(context.Background(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2:IDR:AMOUNT 3:IDR:MISSING_REDIS 4:IDR:MISSING_POSTGRES 5:USD:SCALE 6:USD:SCALE"
+	if got := kinds(rep); got != want {
+		t.Fatalf("mismatches = %s\nwant %s", got, want)
+	}
+	if rep.Wallets != 6 || rep.RedisKeys != 5 {
+		t.Fatalf("checked wallets=%d redis_keys=%d, want 6/5", rep.Wallets, rep.RedisKeys)
+	}
+	for _, m := range rep.Mismatches {
+		if m.Kind == KindScale && m.Note == "" {
+			t.Fatalf("scale mismatch %s without note", m.UserID)
+		}
+		if m.UserID == "2" && m.Diff != "-10" {
+			t.Fatalf("diff user 2 = %s, want -10", m.Diff)
+		}
+	}
+}
+
+func TestReconcileRepairToPostgres(t *testing.T) {
+	r, ws, repo := newTestReconciler(t)
+	seedDrift(t, ws, repo)
+	ctx := context.Background()
+
+	// dry-run: tidak menulis apa pun
+	rep, err := r.Run(ctx, Options{Repair: RepairToPostgres, DryRun: true})
+	if err != nil || rep.Repaired != 0 || !repo.Balance(2, "IDR").Equal(decimal.NewFromInt(90)) {
+		t.Fatalf("dry run repaired=%d bal=%s, %v", rep.Repaired, repo.Balance(2, "IDR"), err)
+	}
+
+	rep, err = r.Run(ctx, Options{Repair: RepairToPostgres})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Repaired != 4 { // 2, 4, 5, 6; 3 tidak punya saldo Redis
+		t.Fatalf("repaired = %d, want 4 (%+v)", rep.Repaired, rep.Mismatches)
+	}
+	for user, want := range map[int64]string{2: "100", 4: "7"} {
+		if got := repo.Balance(user, "IDR"); !got.Equal(decimal.RequireFromString(want)) {
+			t.Fatalf("user %d IDR = %s, want %s", user, got, want)
+		}
+	}
+	if got := repo.Balance(6, "USD"); !got.Equal(decimal.RequireFromString("1.23")) {
+		t.Fatalf("user 6 USD = %s, want 1.23", got)
+	}
+
+	rep, _ = r.Run(ctx, Options{})
+	if got := kinds(rep); got != "3:IDR:MISSING_REDIS" {
+		t.Fatalf("after repair = %s", got)
+	}
+}
+
+func TestReconcileRepairToRedis(t *testing.T) {
+	r, ws, repo := newTestReconciler(t)
+	seedDrift(t, ws, repo)
+	ctx := context.Background()
+
+	rep, err := r.Run(ctx, Options{Repair: RepairToRedis})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6 (1.234 USD) tidak bisa jadi minor unit, 4 tidak punya wallet Postgres
+	if rep.Repaired != 3 {
+		t.Fatalf("repaired = %d, want 3 (%+v)", rep.Repaired, rep.Mismatches)
+	}
+	for _, c := range []struct {
+		user, cur string
+		want      int64
+	}{{"2", "IDR", 90}, {"3", "IDR", 5}, {"5", "USD", 25000}} {
+		bal, _, err := ws.GetBalance(ctx, c.user, c.cur)
+		if err != nil || bal != c.want {
+			t.Fatalf("redis %s/%s = %d, %v; want %d", c.user, c.cur, bal, err, c.want)
+		}
+	}
+}
+
+func TestReconcileHoldsRepairWhileStreamNotDrained(t *testing.T) {
+	r, ws, repo := newTestReconciler(t)
+	ctx := context.Background()
+
+	// deposit Redis yang event-nya belum dipersist: Postgres tertinggal, bukan drift
+	if _, err := ws.Deposit(ctx, "1", "IDR", "tx-1", 50, nil); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := r.Run(ctx, Options{Repair: RepairToPostgres})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Mismatches) != 1 || rep.Repaired != 0 || !strings.Contains(rep.Mismatches[0].Error, "not drained") {
+		t.Fatalf("report = %+v, want held mismatch", rep.Mismatches)
+	}
+	if !repo.Balance(1, "IDR").IsZero() {
+		t.Fatal("held repair must not touch postgres")
+	}
+}
+
+// midRunRepo: jalankan onScan sekali setelah halaman wallet pertama dibaca (sebelum saldo
+// Redis chunk itu dibaca), mensimulasikan deposit yang masuk di tengah Run
+type midRunRepo struct {
+	*repository.MemoryWalletRepository
+	onScan func()
+}
+
+func (m *midRunRepo) ScanWallets(ctx context.Context, after repository.WalletKey, limit int) ([]model.Wallet, error) {
+	ws, err := m.MemoryWalletRepository.ScanWallets(ctx, after, limit)
+	if f := m.onScan; f != nil && len(ws) > 0 {
+		m.onScan = nil
+		f()
+	}
+	return ws, err
+}
+
+func TestReconcileHoldsRepairForDepositDuringRun(t *testing.T) {
+	r, ws, mem := newTestReconciler(t)
+	ctx := context.Background()
+	shard := ws.Keys.Shard("1")
+	if err := ws.EnsureEventGroup(ctx, shard); err != nil {
+		t.Fatal(err)
+	}
+	seedRedis(t, ws, "1", "USD", 100)
+	mem.SetBalance(1, "USD", decimal.NewFromInt(1))
+
+	r.Repo = &midRunRepo{MemoryWalletRepository: mem, onScan: func() {
+		if _, err := ws.Deposit(ctx, "1", "USD", "tx-mid", 50, nil); err != nil {
+			t.Error(err)
+		}
+	}}
+	rep, err := r.Run(ctx, Options{Repair: RepairToPostgres})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Mismatches) != 1 || rep.Repaired != 0 || !strings.Contains(rep.Mismatches[0].Error, "not drained") {
+		t.Fatalf("report = %+v, want held mismatch", rep.Mismatches)
+	}
+	if got := mem.Balance(1, "USD"); !got.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("postgres = %s, want 1 (repair held)", got)
+	}
+
+	// persister menyusul: commit lalu ACK
+	events, err := ws.ReadEvents(ctx, shard, "persister-test", 10, -1)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("events = %v, %v", events, err)
+	}
+	if _, err := mem.UpsertDepositDecimal(ctx, repository.OperationInput{
+		TxID: events[0].TxID, UserID: 1, Currency: "USD", Amount: decimal.New(events[0].Amount, -2),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.AckEvents(ctx, shard, events[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err = r.Run(ctx, Options{Repair: RepairToPostgres})
+	if err != nil || len(rep.Mismatches) != 0 {
+		t.Fatalf("after persist = %+v, %v; want no mismatch", rep.Mismatches, err)
+	}
+	if got := mem.Balance(1, "USD"); !got.Equal(decimal.RequireFromString("1.5")) {
+		t.Fatalf("postgres = %s, want 1.5 (deposit credited once)", got)
+	}
+}
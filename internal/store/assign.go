@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pembagian shard ready ke worker hidup (rendezvous hashing dengan batas beban).
+// Semua instance menghitung dari daftar worker hidup yang sama (workers:wallet + hb),
+// jadi hasilnya sama tanpa koordinasi. Jumlah worker berubah → dihitung ulang saat
+// Heartbeat; shard yang pindah hanya yang terdampak plus penyesuaian kapasitas.
+
+// AssignShards: worker → shard yang dipegang. Setiap shard punya minimal satu worker;
+// jika worker > shard, worker sisa ikut membantu shard dengan skor tertinggi.
+func AssignShards(shards int, workers []string) map[string][]int {
+	out := make(map[string][]int, len(workers))
+	if len(workers) == 0 || shards <= 0 {
+		return out
+	}
+	ws := slices.Clone(workers)
+	slices.Sort(ws)
+	ws = slices.Compact(ws)
+
+	// pass 1: setiap shard ke worker skor tertinggi yang belum penuh
+	capacity := (shards + len(ws) - 1) / len(ws)
+	for s := 0; s < shards; s++ {
+		best := ""
+		var bestScore uint64
+		for _, w := range ws {
+			if len(out[w]) >= capacity {
+				continue
+			}
+			if sc := rendezvousScore(w, s); best == "" || sc > bestScore {
+				best, bestScore = w, sc
+			}
+		}
+		out[best] = append(out[best], s)
+	}
+
+	// pass 2: worker tanpa shard (worker > shard) berbagi shard
+	owners := make([]int, shards)
+	for _, list := range out {
+		for _, s := range list {
+			owners[s]++
+		}
+	}
+	perShard := (len(ws) + shards - 1) / shards
+	for _, w := range ws {
+		if len(out[w]) > 0 {
+			continue
+		}
+		best := -1
+		var bestScore uint64
+		for s := 0; s < shards; s++ {
+			if owners[s] >= perShard {
+				continue
+			}
+			if sc := rendezvousScore(w, s); best == -1 || sc > bestScore {
+				best, bestScore = s, sc
+			}
+		}
+		owners[best]++
+		out[w] = []int{best}
+	}
+	return out
+}
+
+func rendezvousScore(worker string, shard int) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(worker))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(strconv.Itoa(shard)))
+	// finalizer splitmix64 supaya bit rendah FNV tersebar
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// LiveWorkers: anggota workers:wallet yang heartbeat-nya masih ada
+func (q *RedisQueue) LiveWorkers(ctx context.Context) ([]string, error) {
+	tokens, err := q.rdb.SMembers(ctx, q.WorkerSetKey).Result()
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	pipe := q.rdb.Pipeline()
+	exists := make([]*redis.IntCmd, len(tokens))
+	for i, t := range tokens {
+		exists[i] = pipe.Exists(ctx, q.keyHeartbeat(t))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	live := make([]string, 0, len(tokens))
+	for i, t := range tokens {
+		if exists[i].Val() == 1 {
+			live = append(live, t)
+		}
+	}
+	slices.Sort(live)
+	return live, nil
+}
+
+// Rebalance: hitung ulang shard milik worker lokal dari daftar worker hidup.
+// Return true jika pembagian untuk salah satu worker lokal berubah.
+func (q *RedisQueue) Rebalance(ctx context.Context, local ...string) (bool, error) {
+	if q.Keys.Shards <= 1 {
+		return false, nil
+	}
+	live, err := q.LiveWorkers(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range local {
+		if !slices.Contains(live, t) {
+			live = append(live, t)
+		}
+	}
+	plan := AssignShards(q.Keys.Shards, live)
+
+	q.assignMu.Lock()
+	defer q.assignMu.Unlock()
+	if q.assigned == nil {
+		q.assigned = map[string][]int{}
+	}
+	changed := false
+	for _, t := range local {
+		if !slices.Equal(q.assigned[t], plan[t]) {
+			q.assigned[t] = plan[t]
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// AssignedShards: shard yang di-poll worker. Belum pernah Rebalance → semua shard.
+func (q *RedisQueue) AssignedShards(worker string) []int {
+	q.assignMu.RLock()
+	shards := q.assigned[worker]
+	q.assignMu.RUnlock()
+	if len(shards) > 0 {
+		return shards
+	}
+	all := make([]int, q.Keys.Shards)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
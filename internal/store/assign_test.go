@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func workerNames(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("host:%d#0", i)
+	}
+	return out
+}
+
+func TestAssignShardsCoversEveryShardAndBalances(t *testing.T) {
+	for _, tc := range []struct{ shards, workers int }{
+		{1, 1}, {1, 5}, {4, 1}, {4, 3}, {4, 4}, {4, 10}, {16, 5}, {3, 4},
+	} {
+		plan := AssignShards(tc.shards, workerNames(tc.workers))
+
+		owners := make([]int, tc.shards)
+		for w, list := range plan {
+			if len(list) == 0 {
+				t.Fatalf("%+v: worker %s has no shard", tc, w)
+			}
+			if limit := max((tc.shards+tc.workers-1)/tc.workers, 1); len(list) > limit {
+				t.Fatalf("%+v: worker %s holds %d shards, limit %d", tc, w, len(list), limit)
+			}
+			for _, s := range list {
+				owners[s]++
+			}
+		}
+		for s, n := range owners {
+			if n == 0 {
+				t.Fatalf("%+v: shard %d has no worker", tc, s)
+			}
+		}
+	}
+}
+
+func TestAssignShardsIsDeterministic(t *testing.T) {
+	ws := workerNames(5)
+	a := AssignShards(8, ws)
+	slices.Reverse(ws)
+	b := AssignShards(8, ws)
+	for w := range a {
+		if !slices.Equal(a[w], b[w]) {
+			t.Fatalf("worker %s: %v vs %v depending on input order", w, a[w], b[w])
+		}
+	}
+}
+
+// Worker baru bergabung → pembagian dihitung ulang saat Heartbeat berikutnya
+func TestHeartbeatRebalancesOnWorkerCountChange(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 4)
+	ctx := context.Background()
+
+	if err := q.Heartbeat(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.AssignedShards("a"); len(got) != 4 {
+		t.Fatalf("single worker shards = %v, want all 4", got)
+	}
+
+	other := NewRedisQueue(rdb, 4)
+	if err := other.Heartbeat(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Heartbeat(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	a, b := q.AssignedShards("a"), other.AssignedShards("b")
+	if len(a)+len(b) != 4 || len(a) != 2 {
+		t.Fatalf("after join a=%v b=%v, want 2 shards each", a, b)
+	}
+	for _, s := range a {
+		if slices.Contains(b, s) {
+			t.Fatalf("shard %d assigned to both workers", s)
+		}
+	}
+
+	// b berhenti → a mengambil semua shard lagi
+	if err := other.Unregister(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Heartbeat(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.AssignedShards("a"); len(got) != 4 {
+		t.Fatalf("after leave a=%v, want all 4", got)
+	}
+}
+
+func TestClaimOnlyPollsAssignedShards(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 2)
+	ctx := context.Background()
+
+	q.assigned = map[string][]int{"w": {0}}
+	user := ""
+	for i := 0; user == ""; i++ {
+		if u := fmt.Sprint(i); q.Keys.Shard(u) == 1 {
+			user = u
+		}
+	}
+	if _, err := q.Enqueue(ctx, testPayload(user, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Claim(ctx, "w", 0); err != ErrNoDelivery {
+		t.Fatalf("claim on shard 0 = %v, want no delivery (user is on shard 1)", err)
+	}
+
+	q.assigned["w"] = []int{0, 1}
+	d, err := q.Claim(ctx, "w", 0)
+	if err != nil || d.UserID != user {
+		t.Fatalf("claim = %+v, %v; want user %s", d, err, user)
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BalanceKey: pasangan user/currency balance:{wN}:<user>:<CUR>
+type BalanceKey struct {
+	UserID   string
+	Currency string
+}
+
+// BalanceEntry: isi satu key balance (minor units)
+type BalanceEntry struct {
+	BalanceKey
+	Amount int64
+}
+
+// balanceKeyFromKey: kebalikan keyBalance ("balance:{w1}:42:USD" → 42, USD)
+func balanceKeyFromKey(key string) (BalanceKey, bool) {
+	rest, ok := UserFromKey(key)
+	if !ok {
+		return BalanceKey{}, false
+	}
+	user, cur, ok := strings.Cut(rest, ":")
+	if !ok || user == "" || cur == "" {
+		return BalanceKey{}, false
+	}
+	return BalanceKey{UserID: user, Currency: cur}, true
+}
+
+// GetBalances: HGET amount untuk banyak key dalam satu pipeline; key yang belum ada tidak ikut di map
+func (s *RedisWalletStore) GetBalances(ctx context.Context, keys []BalanceKey) (map[BalanceKey]int64, error) {
+	out := make(map[BalanceKey]int64, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.HGet(ctx, s.keyBalance(k.UserID, k.Currency), "amount")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		v, err := cmd.Int64()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[BalanceKey{UserID: keys[i].UserID, Currency: strings.ToUpper(keys[i].Currency)}] = v
+	}
+	return out, nil
+}
+
+// ScanBalances: SCAN semua balance:{wN}:* (di tiap master kalau cluster), fn dipanggil per batch
+func (s *RedisWalletStore) ScanBalances(ctx context.Context, batch int64, fn func([]BalanceEntry) error) error {
+	scan := func(ctx context.Context, c redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := c.Scan(ctx, cursor, "balance:{w*}:*", batch).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				entries, err := s.readBalanceKeys(ctx, c, keys)
+				if err != nil {
+					return err
+				}
+				if err := fn(entries); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+	if cc, ok := s.rdb.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, s.rdb)
+}
+
+func (s *RedisWalletStore) readBalanceKeys(ctx context.Context, c redis.UniversalClient, keys []string) ([]BalanceEntry, error) {
+	pipe := c.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.HGet(ctx, k, "amount")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	out := make([]BalanceEntry, 0, len(keys))
+	for i, cmd := range cmds {
+		bk, ok := balanceKeyFromKey(keys[i])
+		if !ok {
+			continue
+		}
+		v, err := cmd.Int64()
+		if err == redis.Nil {
+			continue // sudah hilang di antara SCAN dan HGET
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, BalanceEntry{BalanceKey: bk, Amount: v})
+	}
+	return out, nil
+}
+
+// SetBalanceIf: set saldo hanya jika nilainya masih expected (nil = key belum ada).
+// false = saldo berubah sejak dibaca, jangan timpa.
+func (s *RedisWalletStore) SetBalanceIf(ctx context.Context, userID, currency string, expected *int64, target int64) (bool, error) {
+	exp := ""
+	if expected != nil {
+		exp = strconv.FormatInt(*expected, 10)
+	}
+	n, err := s.scrSetBalanceIf.Run(ctx, s.rdb, []string{s.keyBalance(userID, currency)}, exp, target).Int64()
+	return n == 1, err
+}
+
+// TxRef: satu tx_id yang sudah diterapkan untuk user (isi tx:{wN}:<user>)
+type TxRef struct {
+	UserID string
+	TxID   string
+}
+
+// WarmBalances: isi balance:{wN}:<user>:<CUR> yang belum ada (HSETNX, satu pipeline).
+// Key yang sudah ada tidak ditimpa: bisa berisi deposit yang belum sampai Postgres.
+// Return jumlah key yang benar-benar diisi.
+func (s *RedisWalletStore) WarmBalances(ctx context.Context, entries []BalanceEntry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(entries))
+	for i, e := range entries {
+		cmds[i] = pipe.HSetNX(ctx, s.keyBalance(e.UserID, e.Currency), "amount", e.Amount)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, cmd := range cmds {
+		if cmd.Val() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// WarmTxIDs: tandai tx_id di tx:{wN}:<user> (satu pipeline) supaya deposit.lua menolak replay
+func (s *RedisWalletStore) WarmTxIDs(ctx context.Context, refs []TxRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	pipe := s.rdb.Pipeline()
+	for _, r := range refs {
+		pipe.HSet(ctx, s.keyTx(r.UserID), r.TxID, 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
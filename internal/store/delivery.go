@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Implementasi Queue untuk RedisQueue: Claim = BLMOVE ready → processing list + lease own:{user};
+// setiap Delivery diakhiri tepat satu Ack/Nack/Quarantine yang juga meng-ack processing list.
+
+var _ Queue = (*RedisQueue)(nil)
+
+// maxShardBlock: dengan >1 shard BLMOVE hanya menunggu satu shard, jadi dibatasi
+// supaya shard lain tetap disapu secara berkala
+const maxShardBlock = time.Second
+
+func (q *RedisQueue) Claim(ctx context.Context, worker string, block time.Duration) (*Delivery, error) {
+	shard, qKey, err := q.popAnyReady(ctx, worker, block)
+	if err == redis.Nil {
+		return nil, ErrNoDelivery
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := q.UserFromQueueKey(qKey)
+	if !ok {
+		_ = q.AckReady(ctx, shard, worker, qKey)
+		return nil, ErrNoDelivery
+	}
+
+	claim, err := q.ClaimLease(ctx, user, worker)
+	if err != nil || claim != ClaimAcquired {
+		// duplikat entry ready (reaper / retry): sudah/sedang diurus worker lain
+		_ = q.AckReady(ctx, shard, worker, qKey)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNoDelivery
+	}
+
+	head, err := q.Head(ctx, user)
+	if err != nil {
+		_ = q.Requeue(ctx, user, worker)
+		_ = q.AckReady(ctx, shard, worker, qKey)
+		return nil, err
+	}
+	return &Delivery{UserID: user, Worker: worker, Raw: head, ref: qKey, shard: shard}, nil
+}
+
+// popAnyReady: hanya shard milik worker (AssignedShards). 1 shard → BLMOVE biasa.
+// >1 shard → LMOVE tanpa blok bergiliran mulai dari cursor, lalu BLMOVE di shard cursor.
+func (q *RedisQueue) popAnyReady(ctx context.Context, worker string, block time.Duration) (int, string, error) {
+	shards := q.AssignedShards(worker)
+	if len(shards) == 1 {
+		qKey, err := q.PopReady(ctx, shards[0], worker, block)
+		return shards[0], qKey, err
+	}
+	n := len(shards)
+	start := int(q.pollCursor.Add(1) % uint64(n))
+	for i := 0; i < n; i++ {
+		shard := shards[(start+i)%n]
+		qKey, err := q.PopReady(ctx, shard, worker, 0)
+		if err != redis.Nil {
+			return shard, qKey, err
+		}
+	}
+	qKey, err := q.PopReady(ctx, shards[start], worker, min(block, maxShardBlock))
+	return shards[start], qKey, err
+}
+
+func (q *RedisQueue) Renew(ctx context.Context, d *Delivery) (bool, error) {
+	return q.RenewLease(ctx, d.UserID, d.Worker)
+}
+
+func (q *RedisQueue) Ack(ctx context.Context, d *Delivery) error {
+	defer q.ackReady(d)
+	res, err := q.ReleaseAndPromote(ctx, d.UserID, d.Worker, d.TxID)
+	if err != nil {
+		return err
+	}
+	if res == -2 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *RedisQueue) Nack(ctx context.Context, d *Delivery, cause error) (NackResult, error) {
+	defer q.ackReady(d)
+	res, err := q.ScheduleRetry(ctx, d.UserID, d.Worker, d.TxID)
+	if err != nil {
+		// fallback: lepas lease, ambil ulang lewat ready
+		_ = q.Requeue(ctx, d.UserID, d.Worker)
+		return NackResult{}, err
+	}
+	if res.LeaseLost {
+		return NackResult{}, ErrLeaseLost
+	}
+	if res.Scheduled {
+		return NackResult{Attempts: res.Attempts, Backoff: res.Backoff}, nil
+	}
+
+	code, err := q.DeadLetterHead(ctx, d.UserID, d.Worker, d.TxID, d.Raw, cause, res.Attempts)
+	if err != nil {
+		return NackResult{Attempts: res.Attempts}, err
+	}
+	if code == -2 {
+		return NackResult{Attempts: res.Attempts}, ErrLeaseLost
+	}
+	return NackResult{Attempts: res.Attempts, DeadLettered: true}, nil
+}
+
+func (q *RedisQueue) Quarantine(ctx context.Context, d *Delivery, cause error) (*Quarantined, error) {
+	defer q.ackReady(d)
+	rec, res, err := q.QuarantineHead(ctx, d.UserID, d.Worker, d.Raw, cause)
+	if err != nil {
+		return nil, err
+	}
+	if res == -2 {
+		return nil, ErrLeaseLost
+	}
+	return &rec, nil
+}
+
+// Reap: processing list worker mati + lease yang expire
+func (q *RedisQueue) Reap(ctx context.Context) (int, error) {
+	recovered, err := q.RecoverDeadWorkers(ctx)
+	if err != nil {
+		return recovered, err
+	}
+	reaped, err := q.ReapExpired(ctx, 100)
+	return recovered + reaped, err
+}
+
+func (q *RedisQueue) PromoteDue(ctx context.Context) (int, error) {
+	return q.PromoteDueRetries(ctx, 100)
+}
+
+// ackReady: hapus qKey dari processing list; pakai context sendiri supaya tetap jalan saat shutdown
+func (q *RedisQueue) ackReady(d *Delivery) {
+	_ = q.AckReady(context.Background(), d.shard, d.Worker, d.ref)
+}
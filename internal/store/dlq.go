@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	// ErrReplayQueueNotEmpty: backend stream hanya bisa replay ke antrian kosong (tanpa
+	// sisip di depan, op yang lebih baru akan jalan duluan)
+	ErrReplayQueueNotEmpty = errors.New("user queue not empty, replay would reorder operations")
+)
+
+// DeadLetterReasonPrefix: awalan error status FAILED untuk op yang diparkir di DLQ. Replay
+// hanya menimpa status yang masih berawalan ini.
+const DeadLetterReasonPrefix = "dead-lettered: "
+
+// RetryResult: hasil ScheduleRetry
+type RetryResult struct {
+	Scheduled bool          // true = dijadwalkan ulang; false = jatah habis → parkir ke DLQ
+	Attempts  int64         // jumlah percobaan gagal untuk head ini
+	Backoff   time.Duration // jeda sampai retry berikutnya
+	LeaseLost bool          // lease bukan milik token (tidak ada yang diubah)
+}
+
+// ScheduleRetry: catat kegagalan head (attempts:{user}) dan jadwalkan ulang q:{user}
+// di retry:wallet dengan exponential backoff. q:{user} tetap locked sampai jatuh tempo.
+func (q *RedisQueue) ScheduleRetry(ctx context.Context, user, token, txID string) (RetryResult, error) {
+	shard := q.Keys.Shard(user)
+	keys := []string{q.keyQueue(user), q.keyOwn(user), q.keyLease(shard), q.keyRetry(shard), q.keyAttempts(user)}
+	args := []any{token, txID, nowMillis(), q.MaxAttempts, q.RetryBaseBackoff.Milliseconds(), q.RetryMaxBackoff.Milliseconds(), q.ReadyGrace.Milliseconds()}
+	res, err := q.scrRetry.Run(ctx, q.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return RetryResult{}, err
+	}
+	return RetryResult{
+		Scheduled: res[0] == 1,
+		Attempts:  res[1],
+		Backoff:   time.Duration(res[2]) * time.Millisecond,
+		LeaseLost: res[0] == -2,
+	}, nil
+}
+
+// PromoteDueRetries: dorong q:{user} yang jatuh tempo di retry:wallet ke ready (semua shard)
+func (q *RedisQueue) PromoteDueRetries(ctx context.Context, limit int64) (int, error) {
+	total := 0
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		keys := []string{q.keyRetry(shard), q.keyReady(shard), q.keyLease(shard)}
+		n, err := q.scrPromoteDue.Run(ctx, q.rdb, keys, nowMillis(), limit, q.ReadyGrace.Milliseconds()).Int()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// DeadLetter: head yang gagal MaxAttempts kali, disimpan di dlq:wallet
+type DeadLetter struct {
+	ID       string `json:"id"` // <user>:<tx_id>
+	UserID   string `json:"user_id"`
+	TxID     string `json:"tx_id"`
+	Payload  string `json:"payload"` // raw JSON head apa adanya
+	Error    string `json:"error"`
+	Attempts int64  `json:"attempts"`
+	FailedAt int64  `json:"failed_at"` // unix millis
+}
+
+func newDeadLetter(user, txID, raw string, reason error, attempts int64) DeadLetter {
+	return DeadLetter{
+		ID:       user + ":" + txID,
+		UserID:   user,
+		TxID:     txID,
+		Payload:  raw,
+		Error:    reason.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UnixMilli(),
+	}
+}
+
+// parking: hash parkir dlq:wallet & quarantine:wallet (per shard), layout sama untuk semua backend Queue
+type parking struct {
+	rdb           redis.UniversalClient
+	keys          Keyspace
+	DLQKey        string // e.g. "dlq:wallet" (+ ":{wN}", HASH <user>:<tx_id> → DeadLetter JSON)
+	QuarantineKey string // e.g. "quarantine:wallet" (+ ":{wN}", HASH <user>:<ms> → Quarantined JSON)
+	// replay: masukkan lagi payload DLQ di head antrian user (per backend)
+	replay func(ctx context.Context, p OperationPayload) (EnqueueResult, error)
+}
+
+func newParking(rdb redis.UniversalClient, keys Keyspace, replay func(context.Context, OperationPayload) (EnqueueResult, error)) parking {
+	return parking{rdb: rdb, keys: keys, DLQKey: "dlq:wallet", QuarantineKey: "quarantine:wallet", replay: replay}
+}
+
+// keyFor: hash parkir shard milik entry id ("<user>:...")
+func (q *parking) keyFor(base, id string) string {
+	user, _, _ := strings.Cut(id, ":")
+	return q.keys.Global(base, q.keys.Shard(user))
+}
+
+// scanParked: HSCAN hash parkir di semua shard, berhenti setelah limit entry
+func (q *parking) scanParked(ctx context.Context, base string, limit int, fn func(field, value string)) error {
+	n := 0
+	for shard := 0; shard < q.keys.Shards; shard++ {
+		var cursor uint64
+		for {
+			kv, next, err := q.rdb.HScan(ctx, q.keys.Global(base, shard), cursor, "*", 100).Result()
+			if err != nil {
+				return err
+			}
+			for i := 1; i < len(kv); i += 2 {
+				fn(kv[i-1], kv[i])
+				if n++; n >= limit {
+					return nil
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// DeadLetterHead: pindahkan head q:{user} ke dlq:wallet lalu promote item berikutnya (atomik)
+func (q *RedisQueue) DeadLetterHead(ctx context.Context, user, token, txID, raw string, reason error, attempts int64) (int64, error) {
+	dl := newDeadLetter(user, txID, raw, reason, attempts)
+	b, _ := json.Marshal(dl)
+	return q.releaseAndPark(ctx, user, token, txID, q.DLQKey, dl.ID, string(b))
+}
+
+// ListDeadLetters: isi DLQ (HSCAN, urutan tidak dijamin), maks limit entry
+func (q *parking) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	var out []DeadLetter
+	err := q.scanParked(ctx, q.DLQKey, limit, func(field, value string) {
+		var dl DeadLetter
+		if err := json.Unmarshal([]byte(value), &dl); err != nil {
+			dl = DeadLetter{ID: field, Payload: value, Error: "undecodable dlq record"}
+		}
+		out = append(out, dl)
+	})
+	return out, err
+}
+
+// GetDeadLetter: satu entry DLQ berdasarkan id
+func (q *parking) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	raw, err := q.rdb.HGet(ctx, q.keyFor(q.DLQKey, id), id).Result()
+	if err == redis.Nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dl DeadLetter
+	if err := json.Unmarshal([]byte(raw), &dl); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// ReplayDeadLetter: masukkan lagi payload DLQ di head antrian user (posisi aslinya, FIFO per
+// user tetap), status kembali PENDING kalau belum berubah sejak diparkir, lalu hapus dari DLQ.
+// Aman diulang: DB tetap idempotent pada (user_id, tx_id).
+func (q *parking) ReplayDeadLetter(ctx context.Context, id string) (EnqueueResult, error) {
+	dl, err := q.GetDeadLetter(ctx, id)
+	if err != nil {
+		return EnqueueResult{}, err
+	}
+	var p OperationPayload
+	if err := json.Unmarshal([]byte(dl.Payload), &p); err != nil {
+		return EnqueueResult{}, err
+	}
+	res, err := q.replay(ctx, p)
+	if err != nil {
+		return res, err
+	}
+	return res, q.rdb.HDel(ctx, q.keyFor(q.DLQKey, id), id).Err()
+}
+
+// DiscardDeadLetter: hapus entry DLQ tanpa replay (status operasi tetap FAILED)
+func (q *parking) DiscardDeadLetter(ctx context.Context, id string) error {
+	n, err := q.rdb.HDel(ctx, q.keyFor(q.DLQKey, id), id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
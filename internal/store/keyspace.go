@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Keyspace: setiap user dipetakan ke satu shard, dan semua key memakai hash tag
+// shard {wN} — baik key milik user (q:{w3}:42) maupun key global shard
+// (ready:wallet:{w3}). Dengan begitu semua key dalam satu script berada di slot
+// Redis Cluster yang sama.
+type Keyspace struct {
+	Shards int
+}
+
+func NewKeyspace(shards int) Keyspace {
+	return Keyspace{Shards: max(shards, 1)}
+}
+
+// Shard: jump consistent hash user → [0, Shards)
+func (k Keyspace) Shard(user string) int {
+	if k.Shards <= 1 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(user))
+	return jumpHash(h.Sum64(), k.Shards)
+}
+
+func (k Keyspace) tag(shard int) string { return fmt.Sprintf("{w%d}", shard) }
+
+// User: "<prefix>:{wN}:<user>"
+func (k Keyspace) User(prefix, user string) string {
+	return prefix + ":" + k.tag(k.Shard(user)) + ":" + user
+}
+
+// Global: "<name>:{wN}"
+func (k Keyspace) Global(name string, shard int) string {
+	return name + ":" + k.tag(shard)
+}
+
+// UserFromKey: kebalikan User ("q:{w3}:42" → "42")
+func UserFromKey(key string) (string, bool) {
+	_, user, ok := strings.Cut(key, "}:")
+	if !ok || user == "" {
+		return "", false
+	}
+	return user, true
+}
+
+// jumpHash: Lamping & Veach, "A Fast, Minimal Memory, Consistent Hash Algorithm"
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func hashTag(key string) string {
+	i := strings.Index(key, "{")
+	j := strings.Index(key[i:], "}")
+	return key[i : i+j+1]
+}
+
+// Semua key yang dipakai satu script harus punya hash tag sama (satu slot cluster)
+func TestKeyspaceScriptKeysShareSlot(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 8)
+	for _, user := range []string{"1", "2", "42", "user:with:colon"} {
+		shard := q.Keys.Shard(user)
+		keys := []string{
+			q.keyQueue(user), q.keyLock(user), q.keyOwn(user), q.keyOp(user), q.keyAttempts(user),
+			q.keyReady(shard), q.keyLease(shard), q.keyRetry(shard), q.keyProcessing(shard, "w"),
+			q.Keys.Global(q.DLQKey, shard),
+		}
+		for _, k := range keys {
+			if hashTag(k) != hashTag(keys[0]) {
+				t.Fatalf("user %s: key %s tag %s, want %s", user, k, hashTag(k), hashTag(keys[0]))
+			}
+		}
+		if got, ok := q.UserFromQueueKey(q.keyQueue(user)); !ok || got != user {
+			t.Fatalf("UserFromQueueKey(%s) = %q, %v", q.keyQueue(user), got, ok)
+		}
+	}
+}
+
+func TestKeyspaceShardIsStableAndSpread(t *testing.T) {
+	ks := NewKeyspace(4)
+	seen := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		u := strconv.Itoa(i)
+		s := ks.Shard(u)
+		if s != ks.Shard(u) || s < 0 || s >= 4 {
+			t.Fatalf("shard(%s) = %d unstable or out of range", u, s)
+		}
+		seen[s]++
+	}
+	if len(seen) != 4 {
+		t.Fatalf("users spread over %d shards, want 4", len(seen))
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ClaimResult: hasil ClaimLease atas q:{user} yang di-pop dari ready
+type ClaimResult int64
+
+const (
+	ClaimStale    ClaimResult = -1 // tidak ada kerja (duplikat ready / antrian sudah habis)
+	ClaimBusy     ClaimResult = 0  // lease dipegang worker lain
+	ClaimAcquired ClaimResult = 1  // lease milik token ini
+)
+
+// ClaimLease: ambil lease own:{user} (PX LeaseTTL) setelah q:{user} di-pop dari ready.
+// Hanya pemegang lease yang boleh memproses head & memanggil ReleaseAndPromote.
+func (q *RedisQueue) ClaimLease(ctx context.Context, user, token string) (ClaimResult, error) {
+	keys := []string{q.keyQueue(user), q.keyLock(user), q.keyOwn(user), q.keyLease(q.Keys.Shard(user))}
+	res, err := q.scrClaim.Run(ctx, q.rdb, keys, token, q.LeaseTTL.Milliseconds(), nowMillis()).Int64()
+	return ClaimResult(res), err
+}
+
+// RenewLease: perpanjang lease selama head masih diproses. false = lease sudah hilang.
+func (q *RedisQueue) RenewLease(ctx context.Context, user, token string) (bool, error) {
+	res, err := q.ClaimLease(ctx, user, token)
+	return res == ClaimAcquired, err
+}
+
+// Requeue: lepas lease tanpa LPOP head dan dorong lagi q:{user} ke ready (error sementara)
+func (q *RedisQueue) Requeue(ctx context.Context, user, token string) error {
+	shard := q.Keys.Shard(user)
+	keys := []string{q.keyQueue(user), q.keyReady(shard), q.keyOwn(user), q.keyLease(shard)}
+	return q.scrRequeue.Run(ctx, q.rdb, keys, token, nowMillis(), q.ReadyGrace.Milliseconds()).Err()
+}
+
+// ReapExpired: promosikan ulang q:{user} yang deadline lease-nya lewat (worker mati setelah
+// BLMOVE / saat memproses) dan bersihkan lock yang antriannya sudah kosong.
+// Return jumlah q:{user} yang dipromosikan ulang.
+func (q *RedisQueue) ReapExpired(ctx context.Context, limit int64) (int, error) {
+	promoted := 0
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		n, err := q.reapShard(ctx, shard, limit)
+		promoted += n
+		if err != nil {
+			return promoted, err
+		}
+	}
+	return promoted, nil
+}
+
+func (q *RedisQueue) reapShard(ctx context.Context, shard int, limit int64) (int, error) {
+	now := time.Now().UnixMilli()
+	leaseKey, readyKey := q.keyLease(shard), q.keyReady(shard)
+	expired, err := q.rdb.ZRangeByScore(ctx, leaseKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, qKey := range expired {
+		user, ok := q.UserFromQueueKey(qKey)
+		if !ok {
+			_ = q.rdb.ZRem(ctx, leaseKey, qKey).Err()
+			continue
+		}
+		keys := []string{qKey, q.keyLock(user), q.keyOwn(user), readyKey, leaseKey}
+		res, err := q.scrReap.Run(ctx, q.rdb, keys, now, q.ReadyGrace.Milliseconds()).Int64()
+		if err != nil {
+			return promoted, err
+		}
+		if res == 1 {
+			promoted++
+		}
+	}
+	return promoted, nil
+}
@@ -0,0 +1,38 @@
+-- KEYS[1] = q:{user}
+-- KEYS[2] = lock:{user}
+-- KEYS[3] = own:{user}
+-- KEYS[4] = lease:wallet:{wN} (ZSET member=q:{user}, score=deadline ms)
+-- ARGV[1] = owner token (worker id)
+-- ARGV[2] = ttl_ms
+-- ARGV[3] = now_ms
+-- return 1 = owner (baru acquire / perpanjang), 0 = dipegang worker lain, -1 = tidak ada kerja (entry ready basi)
+
+local q      = KEYS[1]
+local lock   = KEYS[2]
+local own    = KEYS[3]
+local leases = KEYS[4]
+local token  = ARGV[1]
+local ttl    = tonumber(ARGV[2])
+local now    = tonumber(ARGV[3])
+
+local cur = redis.call('GET', own)
+
+-- jika sudah owner, perpanjang TTL (renewal)
+if cur == token then
+  redis.call('PEXPIRE', own, ttl)
+  redis.call('ZADD', leases, now + ttl, q)
+  return 1
+end
+
+if cur then
+  return 0
+end
+
+-- duplikat di ready (mis. hasil reaper) setelah antrian selesai → abaikan
+if redis.call('EXISTS', lock) == 0 or redis.call('LLEN', q) == 0 then
+  return -1
+end
+
+redis.call('SET', own, token, 'PX', ttl)
+redis.call('ZADD', leases, now + ttl, q)
+return 1
@@ -1,6 +1,6 @@
 -- KEYS[1] = balance:{user}:{CUR}
 -- KEYS[2] = tx:{user}
--- KEYS[3] = stream:wallet
+-- KEYS[3] = stream:wallet:{wN}
 -- ARGV[1] = txId
 -- ARGV[2] = amount (minor units, int)
 -- ARGV[3] = ts_millis
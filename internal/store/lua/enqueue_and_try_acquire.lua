@@ -1,15 +1,33 @@
 -- KEYS[1] = q:{user}
 -- KEYS[2] = lock:{user}
--- KEYS[3] = ready:wallet
--- ARGV[1] = payload JSON (user_id, currency, amount, tx_id)
+-- KEYS[3] = ready:wallet:{wN}
+-- KEYS[4] = op:{user}
+-- KEYS[5] = lease:wallet:{wN} (ZSET member=q:{user}, score=deadline ms)
+-- ARGV[1] = payload JSON (type, user_id, currency, amount, tx_id)
+-- ARGV[2] = tx_id
+-- ARGV[3] = status JSON (PENDING)
+-- ARGV[4] = status ttl_ms
+-- ARGV[5] = now_ms
+-- ARGV[6] = ready_grace_ms (batas tunggu di ready sebelum dianggap yatim oleh reaper)
+-- return {1 acquired | 0 queued | -1 duplicate, posisi di antrian (1 = head), status asli jika duplicate}
 
-local q     = KEYS[1]
-local lock  = KEYS[2]
-local ready = KEYS[3]
+local q       = KEYS[1]
+local lock    = KEYS[2]
+local ready   = KEYS[3]
+local op      = KEYS[4]
+local leases  = KEYS[5]
 local payload = ARGV[1]
 
--- Enqueue payload ke antrian user
-redis.call('RPUSH', q, payload)
+-- Idempotensi: tx_id ini sudah pernah diterima untuk user ini → jangan enqueue ulang
+local prev = redis.call('HGET', op, ARGV[2])
+if prev then
+  return {-1, 0, prev}
+end
+
+-- Enqueue payload ke antrian user + catat status PENDING
+local pos = redis.call('RPUSH', q, payload)
+redis.call('HSET', op, ARGV[2], ARGV[3])
+redis.call('PEXPIRE', op, tonumber(ARGV[4]))
 
 -- Jika belum ada lock dan head ada → acquire + tandai siap diproses
 if redis.call('EXISTS', lock) == 0 then
@@ -17,8 +35,9 @@ if redis.call('EXISTS', lock) == 0 then
   if head ~= false then
     redis.call('SET', lock, '1')
     redis.call('LPUSH', ready, q)  -- dorong queue user ke daftar 'ready'
-    return 1
+    redis.call('ZADD', leases, tonumber(ARGV[5]) + tonumber(ARGV[6]), q)
+    return {1, pos}
   end
 end
 
-return 0
+return {0, pos}
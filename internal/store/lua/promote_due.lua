@@ -0,0 +1,20 @@
+-- KEYS[1] = retry:wallet:{wN}
+-- KEYS[2] = ready:wallet:{wN}
+-- KEYS[3] = lease:wallet:{wN}
+-- ARGV[1] = now_ms
+-- ARGV[2] = limit
+-- ARGV[3] = ready_grace_ms
+-- return jumlah q:{user} yang jatuh tempo dan didorong ke ready
+
+local retry  = KEYS[1]
+local ready  = KEYS[2]
+local leases = KEYS[3]
+local now    = tonumber(ARGV[1])
+
+local due = redis.call('ZRANGEBYSCORE', retry, '-inf', now, 'LIMIT', 0, tonumber(ARGV[2]))
+for _, q in ipairs(due) do
+  redis.call('ZREM', retry, q)
+  redis.call('LPUSH', ready, q)
+  redis.call('ZADD', leases, now + tonumber(ARGV[3]), q)
+end
+return #due
@@ -0,0 +1,40 @@
+-- KEYS[1] = q:{user}
+-- KEYS[2] = lock:{user}
+-- KEYS[3] = own:{user}
+-- KEYS[4] = ready:wallet:{wN}
+-- KEYS[5] = lease:wallet:{wN}
+-- ARGV[1] = now_ms
+-- ARGV[2] = ready_grace_ms
+-- return 1 = dipromosikan ulang, 0 = masih hidup / sudah diurus reaper lain, -1 = dibersihkan (tidak ada kerja)
+
+local q      = KEYS[1]
+local lock   = KEYS[2]
+local own    = KEYS[3]
+local ready  = KEYS[4]
+local leases = KEYS[5]
+local now    = tonumber(ARGV[1])
+local grace  = tonumber(ARGV[2])
+
+-- reaper lain sudah memperbarui deadline
+local score = redis.call('ZSCORE', leases, q)
+if not score or tonumber(score) > now then
+  return 0
+end
+
+-- owner masih hidup (TTL belum habis): sinkronkan deadline saja
+local pttl = redis.call('PTTL', own)
+if pttl > 0 then
+  redis.call('ZADD', leases, now + pttl, q)
+  return 0
+end
+
+if redis.call('EXISTS', lock) == 0 or redis.call('LLEN', q) == 0 then
+  redis.call('DEL', lock)
+  redis.call('ZREM', leases, q)
+  return -1
+end
+
+-- yatim: worker mati setelah BLMOVE / saat proses → promosikan ulang (RPUSH = diambil BLMOVE paling dulu)
+redis.call('RPUSH', ready, q)
+redis.call('ZADD', leases, now + grace, q)
+return 1
@@ -0,0 +1,19 @@
+-- KEYS[1] = processing:wallet:{wN}:<worker>
+-- KEYS[2] = ready:wallet:{wN}
+-- return jumlah entry yang dikembalikan ke ready
+-- (cek heartbeat & SREM workers:wallet dilakukan caller; key itu global, beda slot)
+
+local proc  = KEYS[1]
+local ready = KEYS[2]
+
+-- Kembalikan semua q:{user} yang sedang dipegang worker mati (RPUSH = diambil paling dulu)
+local n = 0
+while true do
+  local v = redis.call('RPOP', proc)
+  if not v then break end
+  redis.call('RPUSH', ready, v)
+  n = n + 1
+end
+
+redis.call('DEL', proc)
+return n
@@ -0,0 +1,51 @@
+-- KEYS[1] = q:{user}
+-- KEYS[2] = lock:{user}
+-- KEYS[3] = ready:wallet:{wN}
+-- KEYS[4] = own:{user}
+-- KEYS[5] = lease:wallet:{wN}
+-- KEYS[6] = op:{user}
+-- ARGV[1] = payload JSON hasil perbaikan
+-- ARGV[2] = tx_id
+-- ARGV[3] = status JSON (PENDING)
+-- ARGV[4] = status ttl_ms
+-- ARGV[5] = now_ms
+-- ARGV[6] = ready_grace_ms
+-- ARGV[7] = prefix error status dead-letter (replay DLQ); '' = status selalu ditimpa (karantina)
+-- return 1 = jadi head & didorong ke ready, 0 = jadi head (queue sudah locked),
+--        -2 = head sedang diproses worker (LPOP release bisa membuang item ini, coba lagi nanti)
+
+local q      = KEYS[1]
+local lock   = KEYS[2]
+local ready  = KEYS[3]
+local own    = KEYS[4]
+local leases = KEYS[5]
+local op     = KEYS[6]
+
+-- status lama hanya ditimpa kalau masih hasil DLQ (FAILED "<prefix>...") atau sudah expired
+local function overwritable(raw, prefix)
+  if prefix == '' or not raw then
+    return true
+  end
+  local ok, st = pcall(cjson.decode, raw)
+  return ok and type(st) == 'table' and st.state == 'FAILED'
+    and type(st.error) == 'string' and string.sub(st.error, 1, #prefix) == prefix
+end
+
+if redis.call('EXISTS', own) == 1 then
+  return -2
+end
+
+-- Sisipkan di depan: item ini dulunya head sebelum dikarantina / masuk DLQ
+redis.call('LPUSH', q, ARGV[1])
+if overwritable(redis.call('HGET', op, ARGV[2]), ARGV[7]) then
+  redis.call('HSET', op, ARGV[2], ARGV[3])
+  redis.call('PEXPIRE', op, tonumber(ARGV[4]))
+end
+
+if redis.call('EXISTS', lock) == 0 then
+  redis.call('SET', lock, '1')
+  redis.call('LPUSH', ready, q)
+  redis.call('ZADD', leases, tonumber(ARGV[5]) + tonumber(ARGV[6]), q)
+  return 1
+end
+return 0
@@ -1,26 +1,58 @@
 -- KEYS[1] = q:{user}
 -- KEYS[2] = lock:{user}
--- KEYS[3] = ready:wallet
+-- KEYS[3] = ready:wallet:{wN}
+-- KEYS[4] = own:{user}
+-- KEYS[5] = lease:wallet:{wN}
+-- KEYS[6] = attempts:{user}
+-- KEYS[7] = hash parkir head (dlq:wallet:{wN}), dipakai jika ARGV[5] tidak kosong
+-- ARGV[1] = owner token
+-- ARGV[2] = now_ms
+-- ARGV[3] = ready_grace_ms
+-- ARGV[4] = tx_id head ('' jika tidak diketahui)
+-- ARGV[5] = field parkir ('' = head dibuang biasa setelah sukses)
+-- ARGV[6] = record parkir (JSON)
 
-local q     = KEYS[1]
-local lock  = KEYS[2]
-local ready = KEYS[3]
+local q        = KEYS[1]
+local lock     = KEYS[2]
+local ready    = KEYS[3]
+local own      = KEYS[4]
+local leases   = KEYS[5]
+local attempts = KEYS[6]
+local park     = KEYS[7]
+local now      = tonumber(ARGV[2])
+local grace    = tonumber(ARGV[3])
 
 -- Harus ada lock (kalau tidak, biarkan aplikasi yang repair)
 if redis.call('EXISTS', lock) == 0 then
   return -1
 end
 
--- Buang head item yang barusan diproses
+-- Lease sudah diambil worker lain (lease kita expire): jangan sentuh head miliknya
+local cur = redis.call('GET', own)
+if cur and cur ~= ARGV[1] then
+  return -2
+end
+redis.call('DEL', own)
+
+-- Buang head item yang barusan diproses (atau parkir ke DLQ)
 redis.call('LPOP', q)
+if ARGV[4] ~= '' then
+  redis.call('HDEL', attempts, ARGV[4])
+end
+if ARGV[5] ~= '' then
+  redis.call('HSET', park, ARGV[5], ARGV[6])
+end
 
 local llen = redis.call('LLEN', q)
 if llen > 0 then
   -- Masih ada antrian: tetap locked & tandai siap lagi
   redis.call('LPUSH', ready, q)
+  redis.call('ZADD', leases, now + grace, q)
   return 1
 else
   -- Habis: buka lock
   redis.call('DEL', lock)
+  redis.call('DEL', attempts)
+  redis.call('ZREM', leases, q)
   return 0
 end
@@ -0,0 +1,21 @@
+-- KEYS[1] = q:{user}
+-- KEYS[2] = ready:wallet:{wN}
+-- KEYS[3] = own:{user}
+-- KEYS[4] = lease:wallet:{wN}
+-- ARGV[1] = owner token
+-- ARGV[2] = now_ms
+-- ARGV[3] = ready_grace_ms
+-- Head gagal diproses (error sementara): lepas lease TANPA LPOP, dorong lagi ke ready.
+
+local q      = KEYS[1]
+local ready  = KEYS[2]
+local own    = KEYS[3]
+local leases = KEYS[4]
+
+if redis.call('GET', own) ~= ARGV[1] then
+  return -2
+end
+redis.call('DEL', own)
+redis.call('LPUSH', ready, q)
+redis.call('ZADD', leases, tonumber(ARGV[2]) + tonumber(ARGV[3]), q)
+return 1
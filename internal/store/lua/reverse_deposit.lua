@@ -0,0 +1,18 @@
+-- KEYS[1] = balance:{user}:{CUR}
+-- KEYS[2] = tx:{user}
+-- ARGV[1] = txId
+-- ARGV[2] = amount (minor units, int)
+--
+-- Batalkan kredit deposit.lua yang ternyata duplikat di Postgres (tx_id di luar jendela
+-- warmup setelah Redis kosong). Sekali saja: tx_id ditandai 'reversed', marker tetap ada
+-- supaya retry client berikutnya tetap dianggap duplikat.
+
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= '1' then
+  -- sudah dibatalkan, atau Redis kosong lagi (saldo sudah diisi ulang dari Postgres)
+  local cur = redis.call('HGET', KEYS[1], 'amount') or '0'
+  return {0, cur}
+end
+
+local newBal = redis.call('HINCRBY', KEYS[1], 'amount', -tonumber(ARGV[2]))
+redis.call('HSET', KEYS[2], ARGV[1], 'reversed')
+return {1, tostring(newBal)}
@@ -0,0 +1,41 @@
+-- KEYS[1] = q:{user}
+-- KEYS[2] = own:{user}
+-- KEYS[3] = lease:wallet:{wN}
+-- KEYS[4] = retry:wallet:{wN} (ZSET member=q:{user}, score=jatuh tempo ms)
+-- KEYS[5] = attempts:{user}
+-- ARGV[1] = owner token
+-- ARGV[2] = tx_id head
+-- ARGV[3] = now_ms
+-- ARGV[4] = max_attempts
+-- ARGV[5] = base_backoff_ms
+-- ARGV[6] = max_backoff_ms
+-- ARGV[7] = ready_grace_ms
+-- return {1, attempts, backoff_ms} = dijadwalkan ulang,
+--        {0, attempts, 0} = jatah habis (caller harus parkir ke DLQ, lease tetap dipegang),
+--        {-2, 0, 0} = lease bukan milik token ini
+
+local q        = KEYS[1]
+local own      = KEYS[2]
+local leases   = KEYS[3]
+local retry    = KEYS[4]
+local attempts = KEYS[5]
+local now      = tonumber(ARGV[3])
+
+if redis.call('GET', own) ~= ARGV[1] then
+  return {-2, 0, 0}
+end
+
+local n = redis.call('HINCRBY', attempts, ARGV[2], 1)
+if n >= tonumber(ARGV[4]) then
+  return {0, n, 0}
+end
+
+-- exponential backoff: base * 2^(n-1), dibatasi max
+local backoff = math.min(tonumber(ARGV[5]) * (2 ^ (n - 1)), tonumber(ARGV[6]))
+backoff = math.floor(backoff)
+
+-- lepas lease; q:{user} tetap locked (FIFO user menunggu) sampai jatuh tempo
+redis.call('DEL', own)
+redis.call('ZADD', retry, now + backoff, q)
+redis.call('ZADD', leases, now + backoff + tonumber(ARGV[7]), q)
+return {1, n, backoff}
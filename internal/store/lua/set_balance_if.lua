@@ -0,0 +1,16 @@
+-- KEYS[1] = balance:{wN}:<user>:<CUR>
+-- ARGV[1] = saldo yang diharapkan saat ini (minor units, '' = key belum ada)
+-- ARGV[2] = saldo baru (minor units)
+-- return 1 = diset, 0 = saldo sudah berubah sejak dibaca (deposit masuk di tengah)
+
+local cur = redis.call('HGET', KEYS[1], 'amount')
+if ARGV[1] == '' then
+  if cur then
+    return 0
+  end
+elseif cur ~= ARGV[1] then
+  return 0
+end
+
+redis.call('HSET', KEYS[1], 'amount', ARGV[2])
+return 1
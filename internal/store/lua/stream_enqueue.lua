@@ -0,0 +1,53 @@
+-- KEYS[1] = sq:{user} (stream operasi user, FIFO)
+-- KEYS[2] = slock:{user} (ada = user sudah terjadwal / sedang diproses)
+-- KEYS[3] = sched:wallet:{wN} (stream jadwal, dibaca consumer group worker)
+-- KEYS[4] = op:{user}
+-- ARGV[1] = payload JSON
+-- ARGV[2] = tx_id
+-- ARGV[3] = status JSON (PENDING)
+-- ARGV[4] = status ttl_ms
+-- ARGV[5] = user
+-- ARGV[6] = '1' replay DLQ: lewati cek duplikat, hanya kalau sq kosong (stream tidak bisa
+--           disisipi di depan, jadi replay di belakang op lain akan membalik urutan), selain itu '0'
+-- ARGV[7] = prefix error status dead-letter; replay hanya menimpa status yang masih dead-letter
+-- return {1 scheduled | 0 queued | -1 duplicate | -3 antrian tidak kosong (replay),
+--         posisi di antrian (1 = head), status asli jika duplicate}
+
+local s     = KEYS[1]
+local lock  = KEYS[2]
+local sched = KEYS[3]
+local op    = KEYS[4]
+local replay = ARGV[6] == '1'
+
+-- status lama hanya ditimpa kalau masih hasil DLQ (FAILED "<prefix>...") atau sudah expired
+local function overwritable(raw, prefix)
+  if prefix == '' or not raw then
+    return true
+  end
+  local ok, st = pcall(cjson.decode, raw)
+  return ok and type(st) == 'table' and st.state == 'FAILED'
+    and type(st.error) == 'string' and string.sub(st.error, 1, #prefix) == prefix
+end
+
+local prev = redis.call('HGET', op, ARGV[2])
+if prev and not replay then
+  return {-1, 0, prev}
+end
+if replay and redis.call('XLEN', s) > 0 then
+  return {-3, redis.call('XLEN', s)}
+end
+
+redis.call('XADD', s, '*', 'p', ARGV[1])
+local pos = redis.call('XLEN', s)
+if not replay or overwritable(prev, ARGV[7]) then
+  redis.call('HSET', op, ARGV[2], ARGV[3])
+  redis.call('PEXPIRE', op, tonumber(ARGV[4]))
+end
+
+-- Satu entry sched per user: worker hanya melihat user yang belum dipegang siapa pun
+if redis.call('EXISTS', lock) == 0 then
+  redis.call('SET', lock, '1')
+  redis.call('XADD', sched, '*', 'user', ARGV[5])
+  return {1, pos}
+end
+return {0, pos}
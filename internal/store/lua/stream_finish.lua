@@ -0,0 +1,50 @@
+-- KEYS[1] = sq:{user}
+-- KEYS[2] = slock:{user}
+-- KEYS[3] = sched:wallet:{wN}
+-- KEYS[4] = attempts:{user}
+-- KEYS[5] = hash parkir head (dlq:wallet:{wN} / quarantine:wallet:{wN}), dipakai jika ARGV[7] tidak kosong
+-- ARGV[1] = group
+-- ARGV[2] = consumer (worker)
+-- ARGV[3] = id entry sched yang dipegang
+-- ARGV[4] = id head di sq:{user} ('' = tidak ada head, hanya bereskan jadwal)
+-- ARGV[5] = tx_id head ('' jika tidak diketahui)
+-- ARGV[6] = user
+-- ARGV[7] = field parkir ('' = head dibuang biasa)
+-- ARGV[8] = record parkir (JSON)
+-- return 1 = masih ada antrian (dijadwalkan lagi), 0 = habis (unlock),
+--        -2 = entry sched sudah di-XAUTOCLAIM worker lain (lease hilang)
+
+local s        = KEYS[1]
+local lock     = KEYS[2]
+local sched    = KEYS[3]
+local attempts = KEYS[4]
+local park     = KEYS[5]
+
+-- Lease = entry sched ada di PEL consumer ini
+local pending = redis.call('XPENDING', sched, ARGV[1], ARGV[3], ARGV[3], 1, ARGV[2])
+if #pending == 0 then
+  return -2
+end
+
+if ARGV[4] ~= '' then
+  redis.call('XDEL', s, ARGV[4])
+  if ARGV[5] ~= '' then
+    redis.call('HDEL', attempts, ARGV[5])
+  end
+  if ARGV[7] ~= '' then
+    redis.call('HSET', park, ARGV[7], ARGV[8])
+  end
+end
+
+redis.call('XACK', sched, ARGV[1], ARGV[3])
+redis.call('XDEL', sched, ARGV[3])
+
+if redis.call('XLEN', s) > 0 then
+  redis.call('XADD', sched, '*', 'user', ARGV[6])
+  return 1
+end
+-- Habis: buka lock & buang stream kosong (XDEL tidak menghapus key)
+redis.call('DEL', s)
+redis.call('DEL', lock)
+redis.call('DEL', attempts)
+return 0
@@ -0,0 +1,12 @@
+-- KEYS[1] = sretry:wallet:{wN}
+-- KEYS[2] = sched:wallet:{wN}
+-- ARGV[1] = now_ms
+-- ARGV[2] = limit
+-- return jumlah user yang jatuh tempo dan dijadwalkan lagi
+
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[1]), 'LIMIT', 0, tonumber(ARGV[2]))
+for _, user in ipairs(due) do
+  redis.call('ZREM', KEYS[1], user)
+  redis.call('XADD', KEYS[2], '*', 'user', user)
+end
+return #due
@@ -0,0 +1,12 @@
+-- KEYS[1] = sched:wallet:{wN}
+-- ARGV[1] = group
+-- ARGV[2] = consumer (worker)
+-- ARGV[3] = id entry sched yang dipegang
+-- return 1 = idle time di-reset (lease diperpanjang), 0 = lease hilang
+
+local pending = redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[3], ARGV[3], 1, ARGV[2])
+if #pending == 0 then
+  return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[1], ARGV[2], 0, ARGV[3], 'JUSTID')
+return 1
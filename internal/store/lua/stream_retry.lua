@@ -0,0 +1,37 @@
+-- KEYS[1] = sched:wallet:{wN}
+-- KEYS[2] = sretry:wallet:{wN} (ZSET member=user, score=jatuh tempo ms)
+-- KEYS[3] = attempts:{user}
+-- ARGV[1] = group
+-- ARGV[2] = consumer (worker)
+-- ARGV[3] = id entry sched yang dipegang
+-- ARGV[4] = tx_id head
+-- ARGV[5] = user
+-- ARGV[6] = now_ms
+-- ARGV[7] = max_attempts
+-- ARGV[8] = base_backoff_ms
+-- ARGV[9] = max_backoff_ms
+-- return {1, attempts, backoff_ms} = dijadwalkan ulang,
+--        {0, attempts, 0} = jatah habis (caller parkir ke DLQ, entry sched tetap dipegang),
+--        {-2, 0, 0} = lease hilang
+
+local sched    = KEYS[1]
+local retry    = KEYS[2]
+local attempts = KEYS[3]
+
+local pending = redis.call('XPENDING', sched, ARGV[1], ARGV[3], ARGV[3], 1, ARGV[2])
+if #pending == 0 then
+  return {-2, 0, 0}
+end
+
+local n = redis.call('HINCRBY', attempts, ARGV[4], 1)
+if n >= tonumber(ARGV[7]) then
+  return {0, n, 0}
+end
+
+local backoff = math.floor(math.min(tonumber(ARGV[8]) * (2 ^ (n - 1)), tonumber(ARGV[9])))
+
+-- lepas entry sched; slock:{user} tetap ada (FIFO user menunggu) sampai jatuh tempo
+redis.call('XACK', sched, ARGV[1], ARGV[3])
+redis.call('XDEL', sched, ARGV[3])
+redis.call('ZADD', retry, tonumber(ARGV[6]) + backoff, ARGV[5])
+return {1, n, backoff}
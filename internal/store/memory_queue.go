@@ -0,0 +1,331 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryQueue: implementasi Queue di dalam proses untuk test hermetic. Semantiknya
+// mengikuti enqueue_and_try_acquire.lua / release_and_promote.lua:
+//   - satu antrian FIFO per user, hanya head yang diserahkan ke worker;
+//   - user "locked" selama ada antrian: ada di ready, dipegang worker, atau menunggu retry;
+//   - lease dengan deadline; Reap mempromosikan ulang user yang lease-nya lewat.
+type MemoryQueue struct {
+	LeaseTTL         time.Duration
+	MaxAttempts      int64
+	RetryBaseBackoff time.Duration
+	RetryMaxBackoff  time.Duration
+	Now              func() time.Time
+
+	mu          sync.Mutex
+	changed     chan struct{} // ditutup & diganti setiap ada user baru di ready
+	queues      map[string][]string
+	locked      map[string]bool
+	ready       []string
+	owner       map[string]string
+	deadline    map[string]time.Time
+	retryAt     map[string]time.Time
+	attempts    map[string]int64 // "<user>:<tx_id>"
+	ops         map[string]OpStatus
+	deadLetters map[string]DeadLetter
+	quarantined map[string]Quarantined
+	workers     map[string]bool
+}
+
+var _ Queue = (*MemoryQueue)(nil)
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		LeaseTTL:         10 * time.Second,
+		MaxAttempts:      5,
+		RetryBaseBackoff: 200 * time.Millisecond,
+		RetryMaxBackoff:  30 * time.Second,
+		Now:              time.Now,
+		changed:          make(chan struct{}),
+		queues:           map[string][]string{},
+		locked:           map[string]bool{},
+		owner:            map[string]string{},
+		deadline:         map[string]time.Time{},
+		retryAt:          map[string]time.Time{},
+		attempts:         map[string]int64{},
+		ops:              map[string]OpStatus{},
+		deadLetters:      map[string]DeadLetter{},
+		quarantined:      map[string]Quarantined{},
+		workers:          map[string]bool{},
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, p OperationPayload) (EnqueueResult, error) {
+	b, _ := json.Marshal(p)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if prev, ok := q.ops[p.UserID+":"+p.TxID]; ok {
+		return EnqueueResult{Duplicate: true, Existing: &prev}, nil
+	}
+	q.ops[p.UserID+":"+p.TxID] = newOpStatus(p, OpPending, "")
+	return q.pushLocked(p.UserID, string(b)), nil
+}
+
+// PushRaw: taruh payload mentah (mis. JSON rusak) di ekor antrian user, tanpa validasi
+func (q *MemoryQueue) PushRaw(user, raw string) EnqueueResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pushLocked(user, raw)
+}
+
+func (q *MemoryQueue) pushLocked(user, raw string) EnqueueResult {
+	q.queues[user] = append(q.queues[user], raw)
+	pos := int64(len(q.queues[user]))
+	if q.locked[user] {
+		return EnqueueResult{Position: pos}
+	}
+	q.locked[user] = true
+	q.markReadyLocked(user)
+	return EnqueueResult{Acquired: true, Position: pos}
+}
+
+func (q *MemoryQueue) markReadyLocked(user string) {
+	q.ready = append(q.ready, user)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *MemoryQueue) SetOpStatus(ctx context.Context, p OperationPayload, state OpState, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ops[p.UserID+":"+p.TxID] = newOpStatus(p, state, reason)
+	return nil
+}
+
+func (q *MemoryQueue) GetOpStatus(ctx context.Context, user, txID string) (*OpStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st, ok := q.ops[user+":"+txID]
+	if !ok {
+		return nil, ErrOpNotFound
+	}
+	return &st, nil
+}
+
+func (q *MemoryQueue) Claim(ctx context.Context, worker string, block time.Duration) (*Delivery, error) {
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		q.mu.Lock()
+		if len(q.ready) > 0 {
+			user := q.ready[0]
+			q.ready = q.ready[1:]
+			if len(q.queues[user]) == 0 {
+				// jadwal basi: antrian sudah kosong
+				q.unlockLocked(user)
+				q.mu.Unlock()
+				continue
+			}
+			q.owner[user] = worker
+			q.deadline[user] = q.Now().Add(q.LeaseTTL)
+			d := &Delivery{UserID: user, Worker: worker, Raw: q.queues[user][0]}
+			q.mu.Unlock()
+			return d, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, ErrNoDelivery
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *MemoryQueue) Renew(ctx context.Context, d *Delivery) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.owner[d.UserID] != d.Worker {
+		return false, nil
+	}
+	q.deadline[d.UserID] = q.Now().Add(q.LeaseTTL)
+	return true, nil
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, d *Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.owner[d.UserID] != d.Worker {
+		return ErrLeaseLost
+	}
+	q.releaseLocked(d)
+	return nil
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, d *Delivery, cause error) (NackResult, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.owner[d.UserID] != d.Worker {
+		return NackResult{}, ErrLeaseLost
+	}
+
+	k := d.UserID + ":" + d.TxID
+	q.attempts[k]++
+	n := q.attempts[k]
+	if n >= q.MaxAttempts {
+		dl := newDeadLetter(d.UserID, d.TxID, d.Raw, cause, n)
+		q.deadLetters[dl.ID] = dl
+		q.releaseLocked(d)
+		return NackResult{Attempts: n, DeadLettered: true}, nil
+	}
+
+	backoff := min(q.RetryBaseBackoff<<(n-1), q.RetryMaxBackoff)
+	delete(q.owner, d.UserID)
+	delete(q.deadline, d.UserID)
+	q.retryAt[d.UserID] = q.Now().Add(backoff)
+	return NackResult{Attempts: n, Backoff: backoff}, nil
+}
+
+func (q *MemoryQueue) Quarantine(ctx context.Context, d *Delivery, cause error) (*Quarantined, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.owner[d.UserID] != d.Worker {
+		return nil, ErrLeaseLost
+	}
+	rec := newQuarantined(d.UserID, d.Raw, cause)
+	if _, exists := q.quarantined[rec.ID]; exists {
+		// dua karantina di milidetik yang sama
+		rec.ID = fmt.Sprintf("%s#%d", rec.ID, len(q.quarantined))
+	}
+	q.quarantined[rec.ID] = rec
+	q.releaseLocked(d)
+	return &rec, nil
+}
+
+// releaseLocked: buang head, lalu promote item berikutnya atau unlock
+func (q *MemoryQueue) releaseLocked(d *Delivery) {
+	delete(q.owner, d.UserID)
+	delete(q.deadline, d.UserID)
+	if d.TxID != "" {
+		delete(q.attempts, d.UserID+":"+d.TxID)
+	}
+	if len(q.queues[d.UserID]) > 0 {
+		q.queues[d.UserID] = q.queues[d.UserID][1:]
+	}
+	if len(q.queues[d.UserID]) > 0 {
+		q.markReadyLocked(d.UserID)
+		return
+	}
+	q.unlockLocked(d.UserID)
+}
+
+func (q *MemoryQueue) unlockLocked(user string) {
+	delete(q.queues, user)
+	delete(q.locked, user)
+}
+
+func (q *MemoryQueue) Heartbeat(ctx context.Context, workers ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, w := range workers {
+		q.workers[w] = true
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Unregister(ctx context.Context, worker string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.workers, worker)
+	return nil
+}
+
+// Reap: promosikan ulang user yang lease-nya lewat deadline (worker mati)
+func (q *MemoryQueue) Reap(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.Now()
+	n := 0
+	for user, dl := range q.deadline {
+		if now.Before(dl) {
+			continue
+		}
+		delete(q.owner, user)
+		delete(q.deadline, user)
+		q.markReadyLocked(user)
+		n++
+	}
+	return n, nil
+}
+
+func (q *MemoryQueue) PromoteDue(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.Now()
+	n := 0
+	for user, at := range q.retryAt {
+		if now.Before(at) {
+			continue
+		}
+		delete(q.retryAt, user)
+		q.markReadyLocked(user)
+		n++
+	}
+	return n, nil
+}
+
+// Len: jumlah item di antrian user (termasuk head yang sedang diproses)
+func (q *MemoryQueue) Len(user string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[user])
+}
+
+// Locked: user masih punya antrian aktif (ready / diproses / menunggu retry)
+func (q *MemoryQueue) Locked(user string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.locked[user]
+}
+
+// Workers: worker yang masih terdaftar (belum Unregister)
+func (q *MemoryQueue) Workers() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]string, 0, len(q.workers))
+	for w := range q.workers {
+		out = append(out, w)
+	}
+	return out
+}
+
+// DeadLetters: isi DLQ, key = <user>:<tx_id>
+func (q *MemoryQueue) DeadLetters() map[string]DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make(map[string]DeadLetter, len(q.deadLetters))
+	for k, v := range q.deadLetters {
+		out[k] = v
+	}
+	return out
+}
+
+// QuarantinedItems: isi karantina
+func (q *MemoryQueue) QuarantinedItems() []Quarantined {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]Quarantined, 0, len(q.quarantined))
+	for _, v := range q.quarantined {
+		out = append(out, v)
+	}
+	return out
+}
+
+// Attempts: jumlah percobaan gagal yang tercatat untuk head (0 setelah di-ack)
+func (q *MemoryQueue) Attempts(user, txID string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.attempts[user+":"+txID]
+}
@@ -0,0 +1,431 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyspaceMetaKey: jumlah shard layout data di Redis. Tanpa hash tag karena hanya dibaca
+// saat start / migrasi, tidak pernah di dalam script.
+const KeyspaceMetaKey = "meta:wallet:keyspace"
+
+var (
+	ErrKeyspaceMismatch = errors.New("redis keyspace layout mismatch")
+	ErrKeyspaceBusy     = errors.New("redis keyspace not drained")
+)
+
+// Layout: susunan key di Redis. Legacy = sebelum shard tag: user sendiri jadi hash tag
+// (q:{42}, balance:{42}:USD) dan key global tanpa tag (ready:wallet, dlq:wallet).
+type Layout struct {
+	Legacy bool
+	Shards int
+}
+
+func ShardedLayout(shards int) Layout { return Layout{Shards: NewKeyspace(shards).Shards} }
+
+func (l Layout) String() string {
+	if l.Legacy {
+		return "legacy"
+	}
+	return strconv.Itoa(l.Shards)
+}
+
+// ParseLayout: "legacy" atau jumlah shard
+func ParseLayout(s string) (Layout, error) {
+	if s == "legacy" {
+		return Layout{Legacy: true, Shards: 1}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return Layout{}, fmt.Errorf("invalid layout %q (legacy|<shards>)", s)
+	}
+	return ShardedLayout(n), nil
+}
+
+func (l Layout) user(prefix, user string) string {
+	if l.Legacy {
+		return prefix + ":{" + user + "}"
+	}
+	return NewKeyspace(l.Shards).User(prefix, user)
+}
+
+func (l Layout) global(name string, shard int) string {
+	if l.Legacy {
+		return name
+	}
+	return NewKeyspace(l.Shards).Global(name, shard)
+}
+
+func (l Layout) shardOf(user string) int {
+	if l.Legacy {
+		return 0
+	}
+	return NewKeyspace(l.Shards).Shard(user)
+}
+
+func (l Layout) userPattern(prefix string) string {
+	if l.Legacy {
+		return prefix + ":{[^w]*}*"
+	}
+	return prefix + ":{w*}:*"
+}
+
+// parseUser: "balance:{w3}:42:USD" → ("42", ":USD"); legacy "balance:{42}:USD" → ("42", ":USD")
+func (l Layout) parseUser(prefix, key string) (user, suffix string, ok bool) {
+	rest, ok := strings.CutPrefix(key, prefix+":{")
+	if !ok {
+		return "", "", false
+	}
+	if l.Legacy {
+		user, suffix, ok = strings.Cut(rest, "}")
+		return user, suffix, ok && user != "" && !strings.HasPrefix(user, "w")
+	}
+	_, rest, ok = strings.Cut(rest, "}:")
+	if !ok {
+		return "", "", false
+	}
+	user, suffix, _ = strings.Cut(rest, ":")
+	if suffix != "" {
+		suffix = ":" + suffix
+	}
+	return user, suffix, user != ""
+}
+
+// Nama key bawaan RedisQueue/StreamQueue/RedisWalletStore yang disentuh migrasi
+var (
+	movedUserPrefixes = []string{"balance", "tx", "op", "attempts"}           // HASH, isi dipindah
+	queueUserPrefixes = []string{"q", "sq"}                                   // harus kosong (drain dulu)
+	lockUserPrefixes  = []string{"lock", "own", "slock"}                      // sisa lock antrian kosong: dihapus
+	parkedGlobals     = []string{"dlq:wallet", "quarantine:wallet", eventDLQ} // HASH <user>:... per shard, dibagi ulang
+	staleGlobals      = []string{"ready:wallet", "lease:wallet", "retry:wallet", "sretry:wallet", "sched:wallet"}
+)
+
+// CheckKeyspace: dipanggil sebelum store apa pun dibuat. Data Redis dengan jumlah shard lain
+// (atau layout legacy) tidak boleh dipakai: user akan dipetakan ke key kosong dan antrian,
+// lock, saldo, serta dedupe tx_id lama jadi yatim. Instance pertama mencatat shards.
+func CheckKeyspace(ctx context.Context, rdb redis.UniversalClient, shards int) error {
+	want := ShardedLayout(shards)
+	for range 2 {
+		got, found, err := ReadLayout(ctx, rdb)
+		if err != nil {
+			return err
+		}
+		if found {
+			if got != want {
+				return fmt.Errorf("%w: redis data uses %s shards, QUEUE_SHARDS=%d; stop all instances and run `admin keyspace-migrate -to %d`",
+					ErrKeyspaceMismatch, got, shards, want.Shards)
+			}
+			return nil
+		}
+		legacy, err := hasKeys(ctx, rdb, Layout{Legacy: true, Shards: 1})
+		if err != nil {
+			return err
+		}
+		if legacy {
+			return fmt.Errorf("%w: pre-shard keys found (q:{user}, balance:{user}:CUR, ...); stop all instances and run `admin keyspace-migrate -from legacy -to %d`",
+				ErrKeyspaceMismatch, want.Shards)
+		}
+		if ok, err := rdb.SetNX(ctx, KeyspaceMetaKey, want.Shards, 0).Result(); err != nil || ok {
+			return err
+		}
+		// instance lain baru saja mencatat: cek ulang nilainya
+	}
+	return fmt.Errorf("%w: %s changed concurrently", ErrKeyspaceMismatch, KeyspaceMetaKey)
+}
+
+// ReadLayout: layout yang tercatat di KeyspaceMetaKey; found=false kalau belum ada
+func ReadLayout(ctx context.Context, rdb redis.UniversalClient) (Layout, bool, error) {
+	v, err := rdb.Get(ctx, KeyspaceMetaKey).Result()
+	if err == redis.Nil {
+		return Layout{}, false, nil
+	}
+	if err != nil {
+		return Layout{}, false, err
+	}
+	l, err := ParseLayout(v)
+	return l, err == nil, err
+}
+
+// hasKeys: ada key per user milik layout l (cukup satu)
+func hasKeys(ctx context.Context, rdb redis.UniversalClient, l Layout) (bool, error) {
+	errFound := errors.New("found")
+	for _, prefix := range append(append([]string{}, movedUserPrefixes...), queueUserPrefixes...) {
+		err := scanKeys(ctx, rdb, l.userPattern(prefix), func(key string) error {
+			if _, _, ok := l.parseUser(prefix, key); ok {
+				return errFound
+			}
+			return nil
+		})
+		if errors.Is(err, errFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// MigrateReport: hasil MigrateKeyspace
+type MigrateReport struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	DryRun    bool     `json:"dry_run"`
+	Moved     int      `json:"moved"`     // key per user (balance/tx/op/attempts) dipindah
+	Parked    int      `json:"parked"`    // entry dlq/quarantine dipindah ke shard baru
+	Removed   int      `json:"removed"`   // lock/ready/lease/retry sisa yang dihapus
+	Conflicts []string `json:"conflicts"` // key tujuan sudah ada: sumber dibiarkan, layout tidak dicatat
+}
+
+// MigrateKeyspace: pindahkan state tahan lama dari layout from ke to (legacy → shard tag,
+// atau ganti QUEUE_SHARDS). Offline: semua instance harus mati, antrian (q/sq) kosong dan
+// stream:wallet sudah habis dipersist — yang di jalan tidak dipindah, jadi ditolak.
+// Sukses tanpa konflik → KeyspaceMetaKey = to.
+func MigrateKeyspace(ctx context.Context, rdb redis.UniversalClient, from, to Layout, dryRun bool) (*MigrateReport, error) {
+	rep := &MigrateReport{From: from.String(), To: to.String(), DryRun: dryRun}
+	if err := checkDrained(ctx, rdb, from); err != nil {
+		return rep, err
+	}
+
+	for _, prefix := range movedUserPrefixes {
+		err := scanKeys(ctx, rdb, from.userPattern(prefix), func(key string) error {
+			user, suffix, ok := from.parseUser(prefix, key)
+			if !ok {
+				return nil
+			}
+			dst := to.user(prefix, user) + suffix
+			if dst == key {
+				return nil
+			}
+			moved, err := moveHash(ctx, rdb, key, dst, dryRun)
+			if err != nil {
+				return err
+			}
+			if moved {
+				rep.Moved++
+			} else {
+				rep.Conflicts = append(rep.Conflicts, key+" -> "+dst)
+			}
+			return nil
+		})
+		if err != nil {
+			return rep, fmt.Errorf("move %s: %w", prefix, err)
+		}
+	}
+
+	for _, base := range parkedGlobals {
+		n, conflicts, err := moveParked(ctx, rdb, base, from, to, dryRun)
+		rep.Parked += n
+		rep.Conflicts = append(rep.Conflicts, conflicts...)
+		if err != nil {
+			return rep, fmt.Errorf("move %s: %w", base, err)
+		}
+	}
+
+	// antrian kosong: lock/lease/ready yang tersisa hanya menunjuk key lama
+	var stale []string
+	for _, prefix := range lockUserPrefixes {
+		err := scanKeys(ctx, rdb, from.userPattern(prefix), func(key string) error {
+			if _, _, ok := from.parseUser(prefix, key); ok {
+				stale = append(stale, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return rep, err
+		}
+	}
+	for shard := 0; shard < from.Shards; shard++ {
+		for _, name := range staleGlobals {
+			stale = append(stale, from.global(name, shard))
+		}
+	}
+	err := scanKeys(ctx, rdb, "processing:wallet*", func(key string) error {
+		stale = append(stale, key)
+		return nil
+	})
+	if err != nil {
+		return rep, err
+	}
+	for _, key := range stale {
+		n, err := rdb.Exists(ctx, key).Result()
+		if err != nil {
+			return rep, err
+		}
+		if n == 0 {
+			continue
+		}
+		if !dryRun {
+			if err := rdb.Del(ctx, key).Err(); err != nil {
+				return rep, err
+			}
+		}
+		rep.Removed++
+	}
+
+	if len(rep.Conflicts) > 0 {
+		return rep, fmt.Errorf("%w: %d destination keys already exist, layout not recorded", ErrKeyspaceMismatch, len(rep.Conflicts))
+	}
+	if !dryRun {
+		if err := rdb.Set(ctx, KeyspaceMetaKey, to.Shards, 0).Err(); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// checkDrained: tidak ada worker hidup, antrian user kosong, stream:wallet habis dipersist
+func checkDrained(ctx context.Context, rdb redis.UniversalClient, l Layout) error {
+	var live []string
+	err := scanKeys(ctx, rdb, "hb:wallet:*", func(key string) error {
+		live = append(live, strings.TrimPrefix(key, "hb:wallet:"))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(live) > 0 {
+		return fmt.Errorf("%w: %d live workers (%s); stop all instances first", ErrKeyspaceBusy, len(live), strings.Join(live, ","))
+	}
+
+	pending := 0
+	for _, prefix := range queueUserPrefixes {
+		err := scanKeys(ctx, rdb, l.userPattern(prefix), func(key string) error {
+			if _, _, ok := l.parseUser(prefix, key); !ok {
+				return nil
+			}
+			// list (q) atau stream (sq); key yang ada = masih berisi
+			pending++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d user queues still hold operations; drain them with the old layout first", ErrKeyspaceBusy, pending)
+	}
+
+	for shard := 0; shard < l.Shards; shard++ {
+		key := l.global(streamWallet, shard)
+		groups, err := rdb.XInfoGroups(ctx, key).Result()
+		if err != nil {
+			if strings.Contains(err.Error(), "no such key") {
+				continue
+			}
+			return err
+		}
+		for _, g := range groups {
+			if g.Name != EventGroup {
+				continue
+			}
+			tail, err := rdb.XRangeN(ctx, key, "("+g.LastDeliveredID, "+", 1).Result()
+			if err != nil {
+				return err
+			}
+			if g.Pending > 0 || len(tail) > 0 {
+				return fmt.Errorf("%w: %s not persisted yet (pending=%d)", ErrKeyspaceBusy, key, g.Pending)
+			}
+		}
+	}
+	return nil
+}
+
+// moveHash: salin HASH src ke dst (termasuk TTL) lalu hapus src. dst sudah ada = konflik (false).
+func moveHash(ctx context.Context, rdb redis.UniversalClient, src, dst string, dryRun bool) (bool, error) {
+	n, err := rdb.Exists(ctx, dst).Result()
+	if err != nil || n > 0 {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+	fields, err := rdb.HGetAll(ctx, src).Result()
+	if err != nil || len(fields) == 0 {
+		return err == nil, err
+	}
+	ttl, err := rdb.PTTL(ctx, src).Result()
+	if err != nil {
+		return false, err
+	}
+	// src & dst bisa beda slot di cluster: bukan MULTI, tapi urutan salin → hapus aman diulang
+	if err := rdb.HSet(ctx, dst, fields).Err(); err != nil {
+		return false, err
+	}
+	if ttl > 0 {
+		if err := rdb.PExpire(ctx, dst, ttl).Err(); err != nil {
+			return false, err
+		}
+	}
+	return true, rdb.Del(ctx, src).Err()
+}
+
+// moveParked: bagi ulang entry hash parkir (field "<user>:...") ke shard tujuan
+func moveParked(ctx context.Context, rdb redis.UniversalClient, base string, from, to Layout, dryRun bool) (int, []string, error) {
+	moved := 0
+	var conflicts []string
+	for shard := 0; shard < from.Shards; shard++ {
+		src := from.global(base, shard)
+		entries, err := rdb.HGetAll(ctx, src).Result()
+		if err != nil {
+			return moved, conflicts, err
+		}
+		for field, value := range entries {
+			user, _, _ := strings.Cut(field, ":")
+			dst := to.global(base, to.shardOf(user))
+			if dst == src {
+				continue
+			}
+			if dryRun {
+				moved++
+				continue
+			}
+			ok, err := rdb.HSetNX(ctx, dst, field, value).Result()
+			if err != nil {
+				return moved, conflicts, err
+			}
+			if !ok {
+				conflicts = append(conflicts, src+"/"+field+" -> "+dst)
+				continue
+			}
+			if err := rdb.HDel(ctx, src, field).Err(); err != nil {
+				return moved, conflicts, err
+			}
+			moved++
+		}
+	}
+	return moved, conflicts, nil
+}
+
+// scanKeys: SCAN MATCH pattern di semua master (cluster) atau satu node
+func scanKeys(ctx context.Context, rdb redis.UniversalClient, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, c redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := c.Scan(ctx, cursor, pattern, 500).Result()
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := fn(k); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, rdb)
+}
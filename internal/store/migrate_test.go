@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCheckKeyspaceRecordsShards(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	if err := CheckKeyspace(ctx, rdb, 2); err != nil {
+		t.Fatalf("fresh redis: %v", err)
+	}
+	if got, _ := rdb.Get(ctx, KeyspaceMetaKey).Result(); got != "2" {
+		t.Fatalf("meta = %q, want 2", got)
+	}
+	if err := CheckKeyspace(ctx, rdb, 2); err != nil {
+		t.Fatalf("same shards: %v", err)
+	}
+	if err := CheckKeyspace(ctx, rdb, 4); !errors.Is(err, ErrKeyspaceMismatch) {
+		t.Fatalf("shards changed: err = %v, want ErrKeyspaceMismatch", err)
+	}
+}
+
+func TestCheckKeyspaceRejectsLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	rdb.HSet(ctx, "balance:{42}:USD", "amount", 150)
+
+	if err := CheckKeyspace(ctx, rdb, 1); !errors.Is(err, ErrKeyspaceMismatch) {
+		t.Fatalf("err = %v, want ErrKeyspaceMismatch", err)
+	}
+	if n, _ := rdb.Exists(ctx, KeyspaceMetaKey).Result(); n != 0 {
+		t.Fatal("meta must not be recorded over legacy data")
+	}
+}
+
+func TestMigrateKeyspaceFromLegacy(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	// data versi sebelum shard tag
+	rdb.HSet(ctx, "balance:{42}:USD", "amount", 150)
+	rdb.HSet(ctx, "tx:{42}", "t1", 1)
+	rdb.HSet(ctx, "op:{42}", "t1", "done")
+	rdb.PExpire(ctx, "op:{42}", time.Hour)
+	rdb.Set(ctx, "lock:{42}", "tok", 0)
+	rdb.RPush(ctx, "ready:wallet", "q:{42}")
+	dl, _ := json.Marshal(newDeadLetter("42", "t9", `{"tx_id":"t9"}`, errors.New("boom"), 5))
+	rdb.HSet(ctx, "dlq:wallet", "42:t9", dl)
+
+	to := ShardedLayout(2)
+	rep, err := MigrateKeyspace(ctx, rdb, Layout{Legacy: true, Shards: 1}, to, false)
+	if err != nil {
+		t.Fatalf("migrate: %v (%+v)", err, rep)
+	}
+	if rep.Moved != 3 || rep.Parked != 1 || rep.Removed != 2 {
+		t.Fatalf("report = %+v", rep)
+	}
+
+	s := NewRedisWalletStore(rdb, 2)
+	if bal, found, _ := s.GetBalance(ctx, "42", "USD"); !found || bal != 150 {
+		t.Fatalf("balance = %d found=%v, want 150", bal, found)
+	}
+	// dedupe tx_id ikut pindah: replay tidak menambah saldo
+	if res, err := s.Deposit(ctx, "42", "USD", "t1", 10, nil); err != nil || res.Balance != 150 {
+		t.Fatalf("replay t1: %+v err=%v", res, err)
+	}
+	if ttl, _ := rdb.PTTL(ctx, to.user("op", "42")).Result(); ttl <= 0 {
+		t.Fatalf("op TTL = %v, want kept", ttl)
+	}
+	q := NewRedisQueue(rdb, 2)
+	if d, err := q.GetDeadLetter(ctx, "42:t9"); err != nil || d.TxID != "t9" {
+		t.Fatalf("dead letter: %+v err=%v", d, err)
+	}
+	for _, k := range []string{"balance:{42}:USD", "tx:{42}", "op:{42}", "lock:{42}", "ready:wallet", "dlq:wallet"} {
+		if n, _ := rdb.Exists(ctx, k).Result(); n != 0 {
+			t.Fatalf("legacy key %s still exists", k)
+		}
+	}
+	if err := CheckKeyspace(ctx, rdb, 2); err != nil {
+		t.Fatalf("after migrate: %v", err)
+	}
+}
+
+func TestMigrateKeyspaceReshard(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	if err := CheckKeyspace(ctx, rdb, 2); err != nil {
+		t.Fatal(err)
+	}
+	old := NewRedisWalletStore(rdb, 2)
+	users := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+	for _, u := range users {
+		if _, err := old.Deposit(ctx, u, "USD", "d-"+u, 100, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// persister sudah menyusul: semua event ter-ACK
+	for shard := range 2 {
+		key := old.Keys.Global(streamWallet, shard)
+		rdb.XGroupCreateMkStream(ctx, key, EventGroup, "$")
+	}
+
+	// dry-run tidak mengubah apa pun
+	if _, err := MigrateKeyspace(ctx, rdb, ShardedLayout(2), ShardedLayout(4), true); err != nil {
+		t.Fatalf("dry-run: %v", err)
+	}
+	if err := CheckKeyspace(ctx, rdb, 4); !errors.Is(err, ErrKeyspaceMismatch) {
+		t.Fatalf("dry-run recorded layout: %v", err)
+	}
+
+	if _, err := MigrateKeyspace(ctx, rdb, ShardedLayout(2), ShardedLayout(4), false); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	s := NewRedisWalletStore(rdb, 4)
+	for _, u := range users {
+		if bal, found, _ := s.GetBalance(ctx, u, "USD"); !found || bal != 100 {
+			t.Fatalf("user %s balance = %d found=%v", u, bal, found)
+		}
+	}
+	if err := CheckKeyspace(ctx, rdb, 4); err != nil {
+		t.Fatalf("after reshard: %v", err)
+	}
+}
+
+func TestMigrateKeyspaceRefusesUndrained(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	legacy := Layout{Legacy: true, Shards: 1}
+	rdb.HSet(ctx, "balance:{42}:USD", "amount", 150)
+	rdb.RPush(ctx, "q:{42}", `{"tx_id":"t2"}`)
+
+	if _, err := MigrateKeyspace(ctx, rdb, legacy, ShardedLayout(2), false); !errors.Is(err, ErrKeyspaceBusy) {
+		t.Fatalf("queued op: err = %v, want ErrKeyspaceBusy", err)
+	}
+	if n, _ := rdb.Exists(ctx, "balance:{42}:USD").Result(); n != 1 {
+		t.Fatal("nothing may move while queues hold operations")
+	}
+
+	rdb.Del(ctx, "q:{42}")
+	rdb.Set(ctx, "hb:wallet:w-1", 1, time.Minute)
+	if _, err := MigrateKeyspace(ctx, rdb, legacy, ShardedLayout(2), false); !errors.Is(err, ErrKeyspaceBusy) {
+		t.Fatalf("live worker: err = %v, want ErrKeyspaceBusy", err)
+	}
+
+	rdb.Del(ctx, "hb:wallet:w-1")
+	rdb.XAdd(ctx, &redis.XAddArgs{Stream: "stream:wallet", Values: map[string]any{"tx_id": "t1"}})
+	rdb.XGroupCreate(ctx, "stream:wallet", EventGroup, "0")
+	if _, err := MigrateKeyspace(ctx, rdb, legacy, ShardedLayout(2), false); !errors.Is(err, ErrKeyspaceBusy) {
+		t.Fatalf("unpersisted events: err = %v, want ErrKeyspaceBusy", err)
+	}
+}
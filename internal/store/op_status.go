@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OpState: status operasi di op:{user} (field = tx_id)
+type OpState string
+
+const (
+	OpPending OpState = "PENDING"
+	OpApplied OpState = "APPLIED"
+	OpFailed  OpState = "FAILED"
+)
+
+var ErrOpNotFound = errors.New("operation not found")
+
+type OpStatus struct {
+	TxID      string  `json:"tx_id"`
+	Type      OpType  `json:"type"`
+	State     OpState `json:"state"`
+	Error     string  `json:"error,omitempty"`
+	UpdatedAt int64   `json:"updated_at"` // unix millis
+}
+
+func newOpStatus(p OperationPayload, state OpState, reason string) OpStatus {
+	return OpStatus{
+		TxID:      p.TxID,
+		Type:      p.Op(),
+		State:     state,
+		Error:     reason,
+		UpdatedAt: time.Now().UnixMilli(),
+	}
+}
+
+// SetOpStatus: update status operasi (dipanggil processor setelah apply)
+func (q *RedisQueue) SetOpStatus(ctx context.Context, p OperationPayload, state OpState, reason string) error {
+	return setOpStatus(ctx, q.rdb, q.keyOp(p.UserID), q.OpStatusTTL, p, state, reason)
+}
+
+// GetOpStatus: baca status operasi; ErrOpNotFound jika tidak ada / sudah expire
+func (q *RedisQueue) GetOpStatus(ctx context.Context, user, txID string) (*OpStatus, error) {
+	return getOpStatus(ctx, q.rdb, q.keyOp(user), txID)
+}
+
+// setOpStatus/getOpStatus: dipakai bersama semua backend Queue (layout op:{user} sama)
+func setOpStatus(ctx context.Context, rdb redis.UniversalClient, key string, ttl time.Duration, p OperationPayload, state OpState, reason string) error {
+	b, _ := json.Marshal(newOpStatus(p, state, reason))
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, key, p.TxID, string(b))
+	pipe.PExpire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func getOpStatus(ctx context.Context, rdb redis.UniversalClient, key, txID string) (*OpStatus, error) {
+	raw, err := rdb.HGet(ctx, key, txID).Result()
+	if err == redis.Nil {
+		return nil, ErrOpNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st OpStatus
+	if err := json.Unmarshal([]byte(raw), &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reliable handoff (pola BLMOVE): q:{user} dipindah atomik dari ready:wallet:{wN} ke
+// processing:wallet:{wN}:<worker> (slot yang sama), baru dihapus (AckReady) setelah diproses.
+// Worker yang mati meninggalkan entry di processing list-nya; RecoverDeadWorkers
+// mengembalikannya ke ready begitu heartbeat worker tersebut expire.
+
+func (q *RedisQueue) keyProcessing(shard int, token string) string {
+	return fmt.Sprintf("%s:%s", q.Keys.Global(q.ProcessingPrefix, shard), token)
+}
+func (q *RedisQueue) keyHeartbeat(token string) string {
+	return fmt.Sprintf("%s:%s", q.HeartbeatPrefix, token)
+}
+
+// PopReady: BLMOVE ready shard → processing list shard. redis.Nil jika timeout.
+// block = 0 → LMOVE tanpa menunggu.
+func (q *RedisQueue) PopReady(ctx context.Context, shard int, token string, block time.Duration) (string, error) {
+	if block <= 0 {
+		return q.rdb.LMove(ctx, q.keyReady(shard), q.keyProcessing(shard, token), "RIGHT", "LEFT").Result()
+	}
+	return q.rdb.BLMove(ctx, q.keyReady(shard), q.keyProcessing(shard, token), "RIGHT", "LEFT", block).Result()
+}
+
+// AckReady: hapus qKey dari processing list setelah release/requeue/skip
+func (q *RedisQueue) AckReady(ctx context.Context, shard int, token, qKey string) error {
+	return q.rdb.LRem(ctx, q.keyProcessing(shard, token), 1, qKey).Err()
+}
+
+// Heartbeat: tandai worker masih hidup (hb:wallet:<token> PX HeartbeatTTL), lalu
+// hitung ulang pembagian shard (jumlah worker hidup bisa berubah sejak heartbeat terakhir)
+func (q *RedisQueue) Heartbeat(ctx context.Context, tokens ...string) error {
+	pipe := q.rdb.Pipeline()
+	for _, t := range tokens {
+		pipe.Set(ctx, q.keyHeartbeat(t), 1, q.HeartbeatTTL)
+		pipe.SAdd(ctx, q.WorkerSetKey, t)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	_, err := q.Rebalance(ctx, tokens...)
+	return err
+}
+
+// Unregister: worker berhenti normal; sisa processing list (kalau ada) dikembalikan ke ready
+func (q *RedisQueue) Unregister(ctx context.Context, token string) error {
+	if err := q.rdb.Del(ctx, q.keyHeartbeat(token)).Err(); err != nil {
+		return err
+	}
+	q.assignMu.Lock()
+	delete(q.assigned, token)
+	q.assignMu.Unlock()
+	_, err := q.recover(ctx, token)
+	return err
+}
+
+// RecoverDeadWorkers: kembalikan processing list milik worker yang heartbeat-nya expire.
+// Return jumlah q:{user} yang dikembalikan ke ready.
+func (q *RedisQueue) RecoverDeadWorkers(ctx context.Context) (int, error) {
+	tokens, err := q.rdb.SMembers(ctx, q.WorkerSetKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, t := range tokens {
+		n, err := q.recover(ctx, t)
+		if err != nil {
+			return total, err
+		}
+		total += max(n, 0)
+	}
+	return total, nil
+}
+
+// recover: -1 jika worker masih hidup; selain itu jumlah entry yang dikembalikan (semua shard)
+func (q *RedisQueue) recover(ctx context.Context, token string) (int, error) {
+	alive, err := q.rdb.Exists(ctx, q.keyHeartbeat(token)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if alive == 1 {
+		return -1, nil
+	}
+	total := 0
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		keys := []string{q.keyProcessing(shard, token), q.keyReady(shard)}
+		n, err := q.scrRecover.Run(ctx, q.rdb, keys).Int()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, q.rdb.SRem(ctx, q.WorkerSetKey, token).Err()
+}
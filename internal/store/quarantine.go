@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrQuarantineNotFound = errors.New("quarantined payload not found")
+	// ErrHeadBusy: head q:{user} sedang diproses worker, reinject harus diulang
+	ErrHeadBusy = errors.New("queue head is being processed")
+)
+
+// Quarantined: head q:{user} yang tidak bisa di-decode, disimpan mentah di quarantine:wallet
+type Quarantined struct {
+	ID            string `json:"id"` // <user>:<unix ms>
+	UserID        string `json:"user_id"`
+	Raw           string `json:"raw"`
+	Error         string `json:"error"`
+	QuarantinedAt int64  `json:"quarantined_at"` // unix millis
+}
+
+func newQuarantined(user, raw string, reason error) Quarantined {
+	now := time.Now().UnixMilli()
+	return Quarantined{
+		ID:            user + ":" + strconv.FormatInt(now, 10),
+		UserID:        user,
+		Raw:           raw,
+		Error:         reason.Error(),
+		QuarantinedAt: now,
+	}
+}
+
+// QuarantineHead: pindahkan head q:{user} (apa adanya) ke quarantine:wallet lalu promote
+// item berikutnya, atomik. Return record yang disimpan + kode release.
+func (q *RedisQueue) QuarantineHead(ctx context.Context, user, token, raw string, reason error) (Quarantined, int64, error) {
+	rec := newQuarantined(user, raw, reason)
+	b, _ := json.Marshal(rec)
+	res, err := q.releaseAndPark(ctx, user, token, "", q.QuarantineKey, rec.ID, string(b))
+	return rec, res, err
+}
+
+// ListQuarantined: isi quarantine:wallet (HSCAN, urutan tidak dijamin), maks limit entry
+func (q *parking) ListQuarantined(ctx context.Context, limit int) ([]Quarantined, error) {
+	var out []Quarantined
+	err := q.scanParked(ctx, q.QuarantineKey, limit, func(field, value string) {
+		var rec Quarantined
+		if err := json.Unmarshal([]byte(value), &rec); err != nil {
+			rec = Quarantined{ID: field, Raw: value, Error: "undecodable quarantine record"}
+		}
+		out = append(out, rec)
+	})
+	return out, err
+}
+
+// GetQuarantined: satu entry karantina berdasarkan id
+func (q *parking) GetQuarantined(ctx context.Context, id string) (*Quarantined, error) {
+	raw, err := q.rdb.HGet(ctx, q.keyFor(q.QuarantineKey, id), id).Result()
+	if err == redis.Nil {
+		return nil, ErrQuarantineNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec Quarantined
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ReinjectQuarantined: sisipkan payload hasil perbaikan di head q:{user} (posisi aslinya),
+// status tx_id jadi PENDING, lalu hapus entry karantina. Return true jika langsung didorong ke ready.
+func (q *RedisQueue) ReinjectQuarantined(ctx context.Context, id string, p OperationPayload) (bool, error) {
+	rec, err := q.GetQuarantined(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if p.UserID != rec.UserID {
+		return false, fmt.Errorf("payload user_id %q does not match quarantined user %q", p.UserID, rec.UserID)
+	}
+	if p.TxID == "" {
+		return false, errors.New("payload tx_id is required")
+	}
+
+	acquired, err := q.reinjectHead(ctx, p, "")
+	if err != nil {
+		return false, err
+	}
+	return acquired, q.rdb.HDel(ctx, q.keyFor(q.QuarantineKey, id), id).Err()
+}
+
+// reinjectHead: LPUSH payload ke head q:{user} (status PENDING) + dorong ke ready kalau belum
+// locked. keepPrefix != "": status hanya ditimpa kalau error-nya masih berawalan keepPrefix.
+func (q *RedisQueue) reinjectHead(ctx context.Context, p OperationPayload, keepPrefix string) (bool, error) {
+	b, _ := json.Marshal(p)
+	st, _ := json.Marshal(newOpStatus(p, OpPending, ""))
+	shard := q.Keys.Shard(p.UserID)
+	keys := []string{q.keyQueue(p.UserID), q.keyLock(p.UserID), q.keyReady(shard), q.keyOwn(p.UserID), q.keyLease(shard), q.keyOp(p.UserID)}
+	args := []any{string(b), p.TxID, string(st), q.OpStatusTTL.Milliseconds(), nowMillis(), q.ReadyGrace.Milliseconds(), keepPrefix}
+	res, err := q.scrReinject.Run(ctx, q.rdb, keys, args...).Int64()
+	if err != nil {
+		return false, err
+	}
+	if res == -2 {
+		return false, ErrHeadBusy
+	}
+	return res == 1, nil
+}
+
+// replayHead: replay DLQ di head q:{user}, posisi aslinya sebelum gagal
+func (q *RedisQueue) replayHead(ctx context.Context, p OperationPayload) (EnqueueResult, error) {
+	acquired, err := q.reinjectHead(ctx, p, DeadLetterReasonPrefix)
+	if err != nil {
+		return EnqueueResult{}, err
+	}
+	return EnqueueResult{Acquired: acquired, Position: 1}, nil
+}
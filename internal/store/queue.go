@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	BackendList   = "list"   // RedisQueue: q:{user} list + Lua + ready:wallet
+	BackendStream = "stream" // StreamQueue: Redis Streams + consumer group
+)
+
+var (
+	// ErrNoDelivery: tidak ada head yang siap selama waktu block
+	ErrNoDelivery = errors.New("no delivery")
+	// ErrLeaseLost: lease head sudah diambil worker lain; jangan sentuh head-nya
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// Queue: antrian operasi FIFO per user. Dalam satu user hanya head yang diproses,
+// oleh satu worker pemegang lease; antar user diproses paralel.
+type Queue interface {
+	Enqueue(ctx context.Context, p OperationPayload) (EnqueueResult, error)
+	GetOpStatus(ctx context.Context, user, txID string) (*OpStatus, error)
+	SetOpStatus(ctx context.Context, p OperationPayload, state OpState, reason string) error
+
+	// Claim: tunggu maks block sampai ada head user mana pun yang siap, lalu pegang lease-nya.
+	// ErrNoDelivery jika tidak ada.
+	Claim(ctx context.Context, worker string, block time.Duration) (*Delivery, error)
+	// Renew: perpanjang lease selama head masih diproses. false = lease sudah hilang.
+	Renew(ctx context.Context, d *Delivery) (bool, error)
+	// Ack: head selesai (applied / ditolak permanen) → buang & promote item berikutnya
+	Ack(ctx context.Context, d *Delivery) error
+	// Nack: head gagal sementara → retry dengan backoff, atau DLQ jika jatah habis
+	Nack(ctx context.Context, d *Delivery, cause error) (NackResult, error)
+	// Quarantine: head tidak bisa di-decode → simpan mentah, promote item berikutnya
+	Quarantine(ctx context.Context, d *Delivery, cause error) (*Quarantined, error)
+
+	// Heartbeat/Unregister: liveness worker (no-op jika backend tidak butuh)
+	Heartbeat(ctx context.Context, workers ...string) error
+	Unregister(ctx context.Context, worker string) error
+	// Reap: pulihkan head milik worker mati; PromoteDue: dorong retry yang jatuh tempo
+	Reap(ctx context.Context) (int, error)
+	PromoteDue(ctx context.Context) (int, error)
+}
+
+// Delivery: satu head q:{user} yang sedang dipegang worker
+type Delivery struct {
+	UserID string
+	Worker string
+	Raw    string // payload JSON apa adanya
+	TxID   string // diisi processor setelah payload berhasil di-decode
+
+	ref    string // list: q:{user} di processing list; stream: id entry di sched
+	headID string // stream: id head di stream user
+	shard  int    // shard asal ref (processing list / sched)
+}
+
+// NackResult: hasil Nack
+type NackResult struct {
+	Attempts     int64
+	Backoff      time.Duration // jeda sampai retry berikutnya (0 jika DeadLettered)
+	DeadLettered bool          // jatah percobaan habis, head dipindah ke dlq:wallet
+}
+
+// NewQueue: pilih backend sesuai config QUEUE_BACKEND; shards = QUEUE_SHARDS
+func NewQueue(rdb redis.UniversalClient, backend string, shards int) (Queue, error) {
+	switch backend {
+	case "", BackendList:
+		return NewRedisQueue(rdb, shards), nil
+	case BackendStream:
+		return NewStreamQueue(rdb, shards), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", backend)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,67 +20,274 @@ var luaEnqueue string
 //go:embed lua/release_and_promote.lua
 var luaRelease string
 
+//go:embed lua/claim_lease.lua
+var luaClaim string
+
+//go:embed lua/requeue.lua
+var luaRequeue string
+
+//go:embed lua/reap_lease.lua
+var luaReap string
+
+//go:embed lua/recover_processing.lua
+var luaRecover string
+
+//go:embed lua/schedule_retry.lua
+var luaRetry string
+
+//go:embed lua/promote_due.lua
+var luaPromoteDue string
+
+//go:embed lua/reinject_head.lua
+var luaReinject string
+
 type RedisQueue struct {
-	rdb            redis.UniversalClient
-	scrEnqueue     *redis.Script
-	scrRelease     *redis.Script
-	ReadyKey       string // e.g. "ready:wallet"
-	KeyQueuePrefix string // e.g. "q"
-	KeyLockPrefix  string // e.g. "lock"
+	parking
+	rdb               redis.UniversalClient
+	Keys              Keyspace // hash tag shard {wN}; key global di bawah diberi suffix ":{wN}"
+	pollCursor        atomic.Uint64
+	assignMu          sync.RWMutex
+	assigned          map[string][]int // worker lokal → shard ready yang di-poll (lihat Rebalance)
+	scrEnqueue        *redis.Script
+	scrRelease        *redis.Script
+	scrClaim          *redis.Script
+	scrRequeue        *redis.Script
+	scrReap           *redis.Script
+	scrRecover        *redis.Script
+	scrRetry          *redis.Script
+	scrPromoteDue     *redis.Script
+	scrReinject       *redis.Script
+	ReadyKey          string // e.g. "ready:wallet" (+ ":{wN}", satu ready list per shard)
+	LeaseKey          string // e.g. "lease:wallet" (+ ":{wN}", ZSET q key → deadline ms)
+	WorkerSetKey      string // e.g. "workers:wallet" (SET token worker terdaftar, tanpa shard)
+	RetryKey          string // e.g. "retry:wallet" (+ ":{wN}", ZSET q key → jatuh tempo retry ms)
+	ProcessingPrefix  string // e.g. "processing:wallet" (+ ":{wN}:<token>")
+	HeartbeatPrefix   string // e.g. "hb:wallet" (+ ":<token>", tanpa shard)
+	KeyQueuePrefix    string // e.g. "q"
+	KeyLockPrefix     string // e.g. "lock"
+	KeyOwnPrefix      string // e.g. "own" (lease worker, PX LeaseTTL)
+	KeyOpPrefix       string // e.g. "op" (status operasi per user)
+	KeyAttemptsPrefix string // e.g. "attempts" (HASH tx_id → jumlah percobaan gagal)
+	OpStatusTTL       time.Duration
+	LeaseTTL          time.Duration // umur own:{user}, diperpanjang selama diproses
+	ReadyGrace        time.Duration // batas tunggu q:{user} di ready sebelum dianggap yatim
+	HeartbeatTTL      time.Duration // worker dianggap mati jika hb tidak diperbarui selama ini
+	MaxAttempts       int64         // percobaan gagal maksimum sebelum head diparkir ke DLQ
+	RetryBaseBackoff  time.Duration // backoff retry ke-1, berlipat dua tiap percobaan
+	RetryMaxBackoff   time.Duration
 }
 
-func NewRedisQueue(rdb redis.UniversalClient) *RedisQueue {
+// NewRedisQueue: shards = jumlah ready list (QUEUE_SHARDS); harus sama di semua instance
+func NewRedisQueue(rdb redis.UniversalClient, shards int) *RedisQueue {
 	q := &RedisQueue{
-		rdb:            rdb,
-		scrEnqueue:     redis.NewScript(luaEnqueue),
-		scrRelease:     redis.NewScript(luaRelease),
-		ReadyKey:       "ready:wallet",
-		KeyQueuePrefix: "q",
-		KeyLockPrefix:  "lock",
+		rdb:               rdb,
+		Keys:              NewKeyspace(shards),
+		scrEnqueue:        redis.NewScript(luaEnqueue),
+		scrRelease:        redis.NewScript(luaRelease),
+		scrClaim:          redis.NewScript(luaClaim),
+		scrRequeue:        redis.NewScript(luaRequeue),
+		scrReap:           redis.NewScript(luaReap),
+		scrRecover:        redis.NewScript(luaRecover),
+		scrRetry:          redis.NewScript(luaRetry),
+		scrPromoteDue:     redis.NewScript(luaPromoteDue),
+		scrReinject:       redis.NewScript(luaReinject),
+		ReadyKey:          "ready:wallet",
+		LeaseKey:          "lease:wallet",
+		WorkerSetKey:      "workers:wallet",
+		RetryKey:          "retry:wallet",
+		ProcessingPrefix:  "processing:wallet",
+		HeartbeatPrefix:   "hb:wallet",
+		KeyQueuePrefix:    "q",
+		KeyLockPrefix:     "lock",
+		KeyOwnPrefix:      "own",
+		KeyOpPrefix:       "op",
+		KeyAttemptsPrefix: "attempts",
+		OpStatusTTL:       24 * time.Hour,
+		LeaseTTL:          10 * time.Second,
+		ReadyGrace:        30 * time.Second,
+		HeartbeatTTL:      15 * time.Second,
+		MaxAttempts:       5,
+		RetryBaseBackoff:  200 * time.Millisecond,
+		RetryMaxBackoff:   30 * time.Second,
 	}
+	q.parking = newParking(rdb, q.Keys, q.replayHead)
 	// Preload scripts (best effort)
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 		_ = q.scrEnqueue.Load(ctx, rdb).Err()
 		_ = q.scrRelease.Load(ctx, rdb).Err()
+		_ = q.scrClaim.Load(ctx, rdb).Err()
+		_ = q.scrRequeue.Load(ctx, rdb).Err()
+		_ = q.scrReap.Load(ctx, rdb).Err()
+		_ = q.scrRecover.Load(ctx, rdb).Err()
+		_ = q.scrRetry.Load(ctx, rdb).Err()
+		_ = q.scrPromoteDue.Load(ctx, rdb).Err()
+		_ = q.scrReinject.Load(ctx, rdb).Err()
 	}()
 	return q
 }
 
-func (q *RedisQueue) keyQueue(user string) string {
-	return fmt.Sprintf("%s:{%s}", q.KeyQueuePrefix, user)
+func (q *RedisQueue) keyQueue(user string) string    { return q.Keys.User(q.KeyQueuePrefix, user) }
+func (q *RedisQueue) keyLock(user string) string     { return q.Keys.User(q.KeyLockPrefix, user) }
+func (q *RedisQueue) keyOwn(user string) string      { return q.Keys.User(q.KeyOwnPrefix, user) }
+func (q *RedisQueue) keyOp(user string) string       { return q.Keys.User(q.KeyOpPrefix, user) }
+func (q *RedisQueue) keyAttempts(user string) string { return q.Keys.User(q.KeyAttemptsPrefix, user) }
+
+func (q *RedisQueue) keyReady(shard int) string { return q.Keys.Global(q.ReadyKey, shard) }
+func (q *RedisQueue) keyLease(shard int) string { return q.Keys.Global(q.LeaseKey, shard) }
+func (q *RedisQueue) keyRetry(shard int) string { return q.Keys.Global(q.RetryKey, shard) }
+
+// OpType: jenis operasi di dalam envelope q:{user}
+type OpType string
+
+const (
+	OpDeposit  OpType = "DEPOSIT"
+	OpWithdraw OpType = "WITHDRAW"
+	// OpTransfer di-enqueue ke q:{from} saja. Urutan transfer mengikuti FIFO pengirim;
+	// sisi penerima tidak ikut di-lock di Redis (menghindari deadlock antar lock:{user}),
+	// credit ke penerima terjadi pada saat commit di DB dalam transaksi yang sama dengan debit.
+	OpTransfer OpType = "TRANSFER"
+)
+
+// OperationPayload: envelope operasi per user. Processor dispatch berdasarkan Type,
+// urutan tetap FIFO per user karena semua operasi lewat q:{user} yang sama.
+type OperationPayload struct {
+	Type     OpType            `json:"type"`
+	UserID   string            `json:"user_id"`
+	Currency string            `json:"currency"`
+	Amount   int64             `json:"amount"`
+	TxID     string            `json:"tx_id"`
+	ToUserID string            `json:"to_user_id,omitempty"` // hanya untuk TRANSFER
+	Meta     map[string]string `json:"meta,omitempty"`       // dicatat di ledger_entries
+	Trace    map[string]string `json:"trace,omitempty"`      // W3C trace context dari RPC asal (traceparent)
+}
+
+// Op: payload lama (sebelum ada field type) dianggap DEPOSIT
+func (p OperationPayload) Op() OpType {
+	if p.Type == "" {
+		return OpDeposit
+	}
+	return p.Type
 }
-func (q *RedisQueue) keyLock(user string) string {
-	return fmt.Sprintf("%s:{%s}", q.KeyLockPrefix, user)
+
+// ErrInvalidUserID: user_id bukan int64 positif dalam bentuk kanonik
+var ErrInvalidUserID = errors.New("invalid user_id")
+
+// ParseUserID: user_id harus int64 > 0 tanpa spasi/tanda/nol di depan. String yang sama
+// dipakai di key Redis, jadi "07" dan "7" tidak boleh lolos sebagai dua user berbeda.
+func ParseUserID(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || strconv.FormatInt(n, 10) != s {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidUserID, s)
+	}
+	return n, nil
 }
 
-type DepositPayload struct {
-	UserID   string `json:"user_id"`
-	Currency string `json:"currency"`
-	Amount   int64  `json:"amount"`
-	TxID     string `json:"tx_id"`
+// EnqueueResult: hasil enqueue untuk dikembalikan ke caller
+type EnqueueResult struct {
+	Acquired  bool      // langsung jadi head & didorong ke ready
+	Position  int64     // posisi di q:{user} saat enqueue (1 = head)
+	Duplicate bool      // tx_id sudah pernah diterima, tidak di-enqueue ulang
+	Existing  *OpStatus // status asli jika Duplicate
 }
 
-// EnqueueDeposit: push payload ke q:{user}; jika acquire head → dorong ke ready
-func (q *RedisQueue) EnqueueDeposit(ctx context.Context, p DepositPayload) (acquired bool, err error) {
+// Enqueue: push payload ke q:{user} + status PENDING; jika acquire head → dorong ke ready.
+// tx_id yang masih tercatat di op:{user} dianggap duplicate dan tidak di-enqueue.
+func (q *RedisQueue) Enqueue(ctx context.Context, p OperationPayload) (EnqueueResult, error) {
 	b, _ := json.Marshal(p)
-	keys := []string{q.keyQueue(p.UserID), q.keyLock(p.UserID), q.ReadyKey}
-	res, err := q.scrEnqueue.Run(ctx, q.rdb, keys, string(b)).Int()
+	st, _ := json.Marshal(newOpStatus(p, OpPending, ""))
+	shard := q.Keys.Shard(p.UserID)
+	keys := []string{q.keyQueue(p.UserID), q.keyLock(p.UserID), q.keyReady(shard), q.keyOp(p.UserID), q.keyLease(shard)}
+	args := []any{string(b), p.TxID, string(st), q.OpStatusTTL.Milliseconds(), nowMillis(), q.ReadyGrace.Milliseconds()}
+	raw, err := q.scrEnqueue.Run(ctx, q.rdb, keys, args...).Slice()
 	if err != nil {
-		return false, err
+		return EnqueueResult{}, err
 	}
-	return res == 1, nil
+	code, _ := raw[0].(int64)
+	pos, _ := raw[1].(int64)
+	if code == -1 {
+		var prev OpStatus
+		if s, ok := raw[2].(string); ok {
+			_ = json.Unmarshal([]byte(s), &prev)
+		}
+		return EnqueueResult{Duplicate: true, Existing: &prev}, nil
+	}
+	return EnqueueResult{Acquired: code == 1, Position: pos}, nil
+}
+
+// ReleaseAndPromote: dipanggil setelah DB sukses oleh pemegang lease (token).
+// Return 1 = masih ada antrian (dipromosikan), 0 = habis (unlock), -1 = tidak ada lock,
+// -2 = lease sudah dipegang worker lain (head tidak di-pop).
+func (q *RedisQueue) ReleaseAndPromote(ctx context.Context, user, token, txID string) (int64, error) {
+	return q.releaseAndPark(ctx, user, token, txID, q.DLQKey, "", "")
+}
+
+// releaseAndPark: LPOP head + promote; jika field tidak kosong head disimpan dulu ke hash parkir
+// (parkKey = nama dasar dlq:wallet / quarantine:wallet, shard user ditambahkan di sini)
+func (q *RedisQueue) releaseAndPark(ctx context.Context, user, token, txID, parkKey, field, record string) (int64, error) {
+	shard := q.Keys.Shard(user)
+	keys := []string{q.keyQueue(user), q.keyLock(user), q.keyReady(shard), q.keyOwn(user), q.keyLease(shard), q.keyAttempts(user), q.Keys.Global(parkKey, shard)}
+	args := []any{token, nowMillis(), q.ReadyGrace.Milliseconds(), txID, field, record}
+	return q.scrRelease.Run(ctx, q.rdb, keys, args...).Int64()
+}
+
+// Head: baca head q:{user} tanpa pop
+func (q *RedisQueue) Head(ctx context.Context, user string) (string, error) {
+	return q.rdb.LIndex(ctx, q.keyQueue(user), 0).Result()
+}
+
+// ReadyKeyName: expose nama ready list untuk shard
+func (q *RedisQueue) ReadyKeyName(shard int) string { return q.keyReady(shard) }
+
+// ReadyLen: jumlah q:{user} yang menunggu di ready list shard
+func (q *RedisQueue) ReadyLen(ctx context.Context, shard int) (int64, error) {
+	return q.rdb.LLen(ctx, q.keyReady(shard)).Result()
 }
 
-// ReleaseAndPromote: dipanggil setelah DB sukses
-func (q *RedisQueue) ReleaseAndPromote(ctx context.Context, user string) (int64, error) {
-	keys := []string{q.keyQueue(user), q.keyLock(user), q.ReadyKey}
-	return q.scrRelease.Run(ctx, q.rdb, keys).Int64()
+// QueueSample: snapshot panjang antrian untuk metrics
+type QueueSample struct {
+	Ready  []int64 // panjang ready list per shard
+	Depths []int64 // panjang antrian user (q / sq) yang sedang menunggu di ready (sampel)
 }
 
-// ReadyKeyName: expose nama ready list (untuk BRPOP)
-func (q *RedisQueue) ReadyKeyName() string { return q.ReadyKey }
+// Sample: LLEN ready tiap shard + LLEN q:{user} untuk maks perShard entry teratas tiap ready list
+func (q *RedisQueue) Sample(ctx context.Context, perShard int64) (QueueSample, error) {
+	out := QueueSample{Ready: make([]int64, q.Keys.Shards)}
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		n, err := q.ReadyLen(ctx, shard)
+		if err != nil {
+			return out, err
+		}
+		out.Ready[shard] = n
+		if n == 0 || perShard <= 0 {
+			continue
+		}
+		qKeys, err := q.rdb.LRange(ctx, q.keyReady(shard), -perShard, -1).Result()
+		if err != nil {
+			return out, err
+		}
+		pipe := q.rdb.Pipeline()
+		lens := make([]*redis.IntCmd, len(qKeys))
+		for i, k := range qKeys {
+			lens[i] = pipe.LLen(ctx, k)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return out, err
+		}
+		for _, c := range lens {
+			if c.Val() > 0 {
+				out.Depths = append(out.Depths, c.Val())
+			}
+		}
+	}
+	return out, nil
+}
 
-// QueueKeyForUser: expose nama q:{user} (untuk LINDEX head)
+// QueueKeyForUser: expose nama q:{wN}:<user> (untuk LINDEX head)
 func (q *RedisQueue) QueueKeyForUser(user string) string { return q.keyQueue(user) }
+
+// UserFromQueueKey: kebalikan QueueKeyForUser ("q:{wN}:<user>" → user)
+func (q *RedisQueue) UserFromQueueKey(qKey string) (string, bool) {
+	return UserFromKey(qKey)
+}
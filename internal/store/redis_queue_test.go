@@ -0,0 +1,424 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+func testPayload(user, txID string) OperationPayload {
+	return OperationPayload{Type: OpDeposit, UserID: user, Currency: "USD", Amount: 1, TxID: txID}
+}
+
+// processHead: satu langkah worker list backend; return tx_id head yang di-release
+func processHead(t *testing.T, q *RedisQueue, token string) (string, bool) {
+	t.Helper()
+	ctx := context.Background()
+	shard, qKey, err := q.popAnyReady(ctx, token, 0)
+	if err == redis.Nil {
+		return "", false
+	}
+	if err != nil {
+		t.Errorf("LMOVE: %v", err)
+		return "", false
+	}
+	defer func() { _ = q.AckReady(ctx, shard, token, qKey) }()
+
+	user, _ := q.UserFromQueueKey(qKey)
+	claim, err := q.ClaimLease(ctx, user, token)
+	if err != nil || claim != ClaimAcquired {
+		t.Errorf("claim %s: %v %v", user, claim, err)
+		return "", false
+	}
+	head, err := q.Head(ctx, user)
+	if err != nil {
+		t.Errorf("head %s: %v", user, err)
+		return "", false
+	}
+	var p OperationPayload
+	if err := json.Unmarshal([]byte(head), &p); err != nil {
+		t.Errorf("decode head: %v", err)
+		return "", false
+	}
+	if _, err := q.ReleaseAndPromote(ctx, user, token, p.TxID); err != nil {
+		t.Errorf("release %s: %v", user, err)
+	}
+	return user + "/" + p.TxID, true
+}
+
+func TestEnqueueKeepsFIFOPerUser(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 3)
+	ctx := context.Background()
+
+	for _, op := range []OperationPayload{
+		testPayload("1", "a"), testPayload("2", "x"), testPayload("1", "b"),
+		testPayload("2", "y"), testPayload("1", "c"),
+	} {
+		if _, err := q.Enqueue(ctx, op); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	perUser := map[string][]string{}
+	for {
+		got, ok := processHead(t, q, "w")
+		if !ok {
+			break
+		}
+		user, tx, _ := strings.Cut(got, "/")
+		perUser[user] = append(perUser[user], tx)
+	}
+
+	if fmt.Sprint(perUser["1"]) != "[a b c]" || fmt.Sprint(perUser["2"]) != "[x y]" {
+		t.Fatalf("processed = %v, want 1:[a b c] 2:[x y]", perUser)
+	}
+}
+
+func TestEnqueueAndReleaseLockTransitions(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 1)
+	ctx := context.Background()
+
+	res, err := q.Enqueue(ctx, testPayload("1", "a"))
+	if err != nil || !res.Acquired || res.Position != 1 {
+		t.Fatalf("first enqueue = %+v, %v; want acquired at position 1", res, err)
+	}
+	if v, _ := mr.Get(q.keyLock("1")); v != "1" {
+		t.Fatalf("lock after acquire = %q, want 1", v)
+	}
+	if ready, _ := mr.List(q.keyReady(0)); fmt.Sprint(ready) != "["+q.keyQueue("1")+"]" {
+		t.Fatalf("ready = %v, want [%s]", ready, q.keyQueue("1"))
+	}
+	if !mr.Exists(q.keyLease(0)) {
+		t.Fatal("lease deadline not recorded on acquire")
+	}
+
+	res, err = q.Enqueue(ctx, testPayload("1", "b"))
+	if err != nil || res.Acquired || res.Position != 2 {
+		t.Fatalf("second enqueue = %+v, %v; want queued at position 2", res, err)
+	}
+	if ready, _ := mr.List(q.keyReady(0)); len(ready) != 1 {
+		t.Fatalf("ready = %v, want user pushed once", ready)
+	}
+
+	// release dengan antrian tersisa → tetap locked, dipromosikan lagi
+	mr.Del(q.keyReady(0))
+	if _, err := q.ClaimLease(ctx, "1", "w"); err != nil {
+		t.Fatal(err)
+	}
+	code, err := q.ReleaseAndPromote(ctx, "1", "w", "a")
+	if err != nil || code != 1 {
+		t.Fatalf("release with remaining items = %d, %v; want 1", code, err)
+	}
+	if !mr.Exists(q.keyLock("1")) || mr.Exists(q.keyOwn("1")) {
+		t.Fatal("want lock kept and lease dropped after promote")
+	}
+	if ready, _ := mr.List(q.keyReady(0)); fmt.Sprint(ready) != "["+q.keyQueue("1")+"]" {
+		t.Fatalf("ready after promote = %v, want [%s]", ready, q.keyQueue("1"))
+	}
+
+	// release item terakhir → unlock
+	if _, err := q.ClaimLease(ctx, "1", "w"); err != nil {
+		t.Fatal(err)
+	}
+	code, err = q.ReleaseAndPromote(ctx, "1", "w", "b")
+	if err != nil || code != 0 {
+		t.Fatalf("release of last item = %d, %v; want 0", code, err)
+	}
+	if mr.Exists(q.keyLock("1")) || mr.Exists(q.keyQueue("1")) {
+		t.Fatal("want lock and queue removed once drained")
+	}
+	if score, err := rdb.ZScore(ctx, q.keyLease(0), q.keyQueue("1")).Result(); err != redis.Nil {
+		t.Fatalf("lease entry still present (score=%v, err=%v)", score, err)
+	}
+}
+
+func TestReleaseReturnCodes(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 1)
+	ctx := context.Background()
+
+	// -1: tidak ada lock
+	if code, err := q.ReleaseAndPromote(ctx, "nobody", "w", ""); err != nil || code != -1 {
+		t.Fatalf("release without lock = %d, %v; want -1", code, err)
+	}
+
+	for _, tx := range []string{"a", "b"} {
+		if _, err := q.Enqueue(ctx, testPayload("1", tx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if claim, err := q.ClaimLease(ctx, "1", "owner"); err != nil || claim != ClaimAcquired {
+		t.Fatalf("claim = %d, %v", claim, err)
+	}
+	if claim, _ := q.ClaimLease(ctx, "1", "other"); claim != ClaimBusy {
+		t.Fatalf("second claim = %d, want busy", claim)
+	}
+
+	// -2: lease milik worker lain, head tidak boleh di-pop
+	if code, err := q.ReleaseAndPromote(ctx, "1", "other", "a"); err != nil || code != -2 {
+		t.Fatalf("release by non-owner = %d, %v; want -2", code, err)
+	}
+	if n, _ := rdb.LLen(ctx, q.keyQueue("1")).Result(); n != 2 {
+		t.Fatalf("queue length after rejected release = %d, want 2", n)
+	}
+
+	// 1: masih ada antrian, 0: habis
+	if code, _ := q.ReleaseAndPromote(ctx, "1", "owner", "a"); code != 1 {
+		t.Fatalf("release with remaining = %d, want 1", code)
+	}
+	if _, err := q.ClaimLease(ctx, "1", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := q.ReleaseAndPromote(ctx, "1", "owner", "b"); code != 0 {
+		t.Fatalf("release of last = %d, want 0", code)
+	}
+	if claim, _ := q.ClaimLease(ctx, "1", "owner"); claim != ClaimStale {
+		t.Fatalf("claim on drained queue = %d, want stale", claim)
+	}
+}
+
+func TestEnqueueDedupesTxID(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 1)
+	ctx := context.Background()
+
+	if _, err := q.Enqueue(ctx, testPayload("1", "a")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := q.Enqueue(ctx, testPayload("1", "a"))
+	if err != nil || !res.Duplicate || res.Existing == nil || res.Existing.State != OpPending {
+		t.Fatalf("duplicate enqueue = %+v, %v; want duplicate of PENDING", res, err)
+	}
+	if n, _ := rdb.LLen(ctx, q.keyQueue("1")).Result(); n != 1 {
+		t.Fatalf("queue length = %d, want 1", n)
+	}
+}
+
+// Properti: enqueue & release yang berjalan bersamaan dari banyak goroutine tetap
+// memproses setiap tx tepat sekali, urut per user, dan meninggalkan state bersih.
+func TestQueueInterleavingProperty(t *testing.T) {
+	prop := func(seed int64) bool {
+		rng := rand.New(rand.NewSource(seed))
+		users := 1 + rng.Intn(4)
+		perUser := 1 + rng.Intn(15)
+		workers := 1 + rng.Intn(4)
+
+		mr, rdb := newTestRedis(t)
+		q := NewRedisQueue(rdb, 1+rng.Intn(3))
+		ctx := context.Background()
+
+		var (
+			mu   sync.Mutex
+			seen = map[string][]string{}
+			done int
+		)
+		total := users * perUser
+
+		var wg sync.WaitGroup
+		for u := 0; u < users; u++ {
+			wg.Add(1)
+			go func(user string, jitter int64) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(jitter))
+				for i := 0; i < perUser; i++ {
+					if _, err := q.Enqueue(ctx, testPayload(user, fmt.Sprintf("%03d", i))); err != nil {
+						t.Errorf("enqueue: %v", err)
+						return
+					}
+					time.Sleep(time.Duration(r.Intn(200)) * time.Microsecond)
+				}
+			}(strconv.Itoa(u), seed+int64(u))
+		}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				deadline := time.Now().Add(10 * time.Second)
+				for time.Now().Before(deadline) {
+					mu.Lock()
+					finished := done == total
+					mu.Unlock()
+					if finished {
+						return
+					}
+					got, ok := processHead(t, q, token)
+					if !ok {
+						time.Sleep(100 * time.Microsecond)
+						continue
+					}
+					mu.Lock()
+					user, tx, _ := strings.Cut(got, "/")
+					seen[user] = append(seen[user], tx)
+					done++
+					mu.Unlock()
+				}
+			}(fmt.Sprintf("w%d", w))
+		}
+		wg.Wait()
+
+		if done != total {
+			t.Logf("seed %d: processed %d of %d", seed, done, total)
+			return false
+		}
+		for u := 0; u < users; u++ {
+			txs := seen[strconv.Itoa(u)]
+			for i, tx := range txs {
+				if tx != fmt.Sprintf("%03d", i) {
+					t.Logf("seed %d: user %d order %v", seed, u, txs)
+					return false
+				}
+			}
+		}
+		for _, k := range mr.Keys() {
+			if strings.HasPrefix(k, "op:") {
+				continue
+			}
+			t.Logf("seed %d: leftover key %s", seed, k)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(prop, &quick.Config{MaxCount: 15}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamQueueSample(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	q := NewStreamQueue(rdb, 1)
+
+	for _, p := range []OperationPayload{testPayload("1", "a"), testPayload("1", "b"), testPayload("1", "c"), testPayload("2", "d")} {
+		if _, err := q.Enqueue(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := q.Sample(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(s.Depths)
+	if s.Ready[0] != 2 || !slices.Equal(s.Depths, []int64{1, 3}) {
+		t.Fatalf("sample = %+v, want ready 2 depths [1 3]", s)
+	}
+
+	// user yang sudah diambil worker tidak lagi dihitung menunggu
+	if _, err := q.Claim(ctx, "w1", -1); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = q.Sample(ctx, 100); err != nil || s.Ready[0] != 1 || len(s.Depths) != 1 {
+		t.Fatalf("after claim = %+v, %v; want ready 1", s, err)
+	}
+}
+
+// parkDeadLetter: head yang gagal → DLQ + status FAILED dead-lettered (seperti Processor)
+func parkDeadLetter(t *testing.T, rdb redis.UniversalClient, keys Keyspace, setStatus func(OperationPayload, OpState, string) error, p OperationPayload) string {
+	t.Helper()
+	ctx := context.Background()
+	raw, _ := json.Marshal(p)
+	dl := newDeadLetter(p.UserID, p.TxID, string(raw), errors.New("db down"), 5)
+	b, _ := json.Marshal(dl)
+	if err := rdb.HSet(ctx, keys.Global("dlq:wallet", keys.Shard(p.UserID)), dl.ID, b).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setStatus(p, OpFailed, DeadLetterReasonPrefix+"db down"); err != nil {
+		t.Fatal(err)
+	}
+	return dl.ID
+}
+
+func TestReplayDeadLetterKeepsFIFO(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 1)
+	setStatus := func(p OperationPayload, s OpState, r string) error { return q.SetOpStatus(ctx, p, s, r) }
+
+	a, b := testPayload("1", "a"), testPayload("1", "b")
+	id := parkDeadLetter(t, rdb, q.Keys, setStatus, a)
+	// op lebih baru masuk setelah a diparkir
+	if _, err := q.Enqueue(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.ReplayDeadLetter(ctx, id); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	head, _ := q.Head(ctx, "1")
+	var got OperationPayload
+	_ = json.Unmarshal([]byte(head), &got)
+	if got.TxID != "a" {
+		t.Fatalf("head = %s, want replayed a before b", got.TxID)
+	}
+	if st, _ := q.GetOpStatus(ctx, "1", "a"); st == nil || st.State != OpPending {
+		t.Fatalf("status a = %+v, want PENDING", st)
+	}
+	if _, err := q.GetDeadLetter(ctx, id); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("dlq entry still present: %v", err)
+	}
+}
+
+func TestReplayDeadLetterKeepsChangedStatus(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	q := NewRedisQueue(rdb, 1)
+	setStatus := func(p OperationPayload, s OpState, r string) error { return q.SetOpStatus(ctx, p, s, r) }
+
+	a := testPayload("1", "a")
+	id := parkDeadLetter(t, rdb, q.Keys, setStatus, a)
+	// status berubah sejak diparkir (mis. diperbaiki manual): tidak ditimpa PENDING
+	if err := q.SetOpStatus(ctx, a, OpApplied, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.ReplayDeadLetter(ctx, id); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if st, _ := q.GetOpStatus(ctx, "1", "a"); st == nil || st.State != OpApplied {
+		t.Fatalf("status a = %+v, want APPLIED kept", st)
+	}
+}
+
+func TestStreamReplayDeadLetterRequiresEmptyQueue(t *testing.T) {
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	q := NewStreamQueue(rdb, 1)
+	setStatus := func(p OperationPayload, s OpState, r string) error { return q.SetOpStatus(ctx, p, s, r) }
+
+	id := parkDeadLetter(t, rdb, q.Keys, setStatus, testPayload("1", "a"))
+	if _, err := q.Enqueue(ctx, testPayload("1", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.ReplayDeadLetter(ctx, id); !errors.Is(err, ErrReplayQueueNotEmpty) {
+		t.Fatalf("replay behind b: err = %v, want ErrReplayQueueNotEmpty", err)
+	}
+
+	rdb.Del(ctx, q.keyStream("1"), q.keyLock("1"))
+	if res, err := q.ReplayDeadLetter(ctx, id); err != nil || res.Position != 1 {
+		t.Fatalf("replay into empty queue = %+v, %v", res, err)
+	}
+	if st, _ := q.GetOpStatus(ctx, "1", "a"); st == nil || st.State != OpPending {
+		t.Fatalf("status a = %+v, want PENDING", st)
+	}
+}
@@ -15,17 +15,29 @@ import (
 //go:embed lua/deposit.lua
 var luaDeposit string
 
+//go:embed lua/reverse_deposit.lua
+var luaReverseDeposit string
+
+//go:embed lua/set_balance_if.lua
+var luaSetBalanceIf string
+
 const streamWallet = "stream:wallet"
 
 type RedisWalletStore struct {
-	rdb        redis.UniversalClient
-	scrDeposit *redis.Script
+	rdb             redis.UniversalClient
+	Keys            Keyspace // stream:wallet per shard supaya satu slot dengan balance/tx user
+	scrDeposit      *redis.Script
+	scrReverse      *redis.Script // batalkan kredit ganda (lihat async.Persister)
+	scrSetBalanceIf *redis.Script // repair rekonsiliasi (compare-and-set)
 }
 
-func NewRedisWalletStore(rdb redis.UniversalClient) *RedisWalletStore {
+func NewRedisWalletStore(rdb redis.UniversalClient, shards int) *RedisWalletStore {
 	s := &RedisWalletStore{
-		rdb:        rdb,
-		scrDeposit: redis.NewScript(luaDeposit),
+		rdb:             rdb,
+		Keys:            NewKeyspace(shards),
+		scrDeposit:      redis.NewScript(luaDeposit),
+		scrReverse:      redis.NewScript(luaReverseDeposit),
+		scrSetBalanceIf: redis.NewScript(luaSetBalanceIf),
 	}
 
 	// Preload SHA agar call pertama tidak kena EVAL penuh / NOSCRIPT
@@ -38,11 +50,15 @@ func NewRedisWalletStore(rdb redis.UniversalClient) *RedisWalletStore {
 	return s
 }
 
-func keyBalance(userID, currency string) string {
-	return fmt.Sprintf("balance:{%s}:%s", userID, strings.ToUpper(currency))
+// keyBalance: "balance:{wN}:<user>:<CUR>"
+func (s *RedisWalletStore) keyBalance(userID, currency string) string {
+	return fmt.Sprintf("%s:%s", s.Keys.User("balance", userID), strings.ToUpper(currency))
 }
-func keyTx(userID string) string { return fmt.Sprintf("tx:{%s}", userID) }
-func nowMillis() string          { return strconv.FormatInt(time.Now().UnixMilli(), 10) }
+func (s *RedisWalletStore) keyTx(userID string) string { return s.Keys.User("tx", userID) }
+func (s *RedisWalletStore) keyStream(userID string) string {
+	return s.Keys.Global(streamWallet, s.Keys.Shard(userID))
+}
+func nowMillis() string { return strconv.FormatInt(time.Now().UnixMilli(), 10) }
 
 type TxResult struct {
 	Code    int64
@@ -55,9 +71,9 @@ func (s *RedisWalletStore) Deposit(ctx context.Context, userID, currency, txID s
 	metaJSON, _ := json.Marshal(meta)
 
 	keys := []string{
-		keyBalance(userID, cur), // KEYS[1]
-		keyTx(userID),           // KEYS[2]
-		streamWallet,            // KEYS[3]
+		s.keyBalance(userID, cur), // KEYS[1]
+		s.keyTx(userID),           // KEYS[2]
+		s.keyStream(userID),       // KEYS[3]
 	}
 	// ARGV: txId, amount, ts, metaJSON, userID, currency
 	args := []any{txID, amount, nowMillis(), string(metaJSON), userID, cur}
@@ -71,3 +87,28 @@ func (s *RedisWalletStore) Deposit(ctx context.Context, userID, currency, txID s
 	bal, _ := strconv.ParseInt(arr[1].(string), 10, 64)
 	return TxResult{Code: code, Applied: code == 1, Balance: bal}, nil
 }
+
+// ReverseDeposit: batalkan kredit Redis dari event ev tepat sekali (reverse_deposit.lua).
+// reversed=false jika sudah dibatalkan sebelumnya atau tx_id tidak lagi ada di tx:{wN}:<user>.
+func (s *RedisWalletStore) ReverseDeposit(ctx context.Context, ev WalletEvent) (reversed bool, balance int64, err error) {
+	keys := []string{s.keyBalance(ev.UserID, ev.Currency), s.keyTx(ev.UserID)}
+	raw, err := s.scrReverse.Run(ctx, s.rdb, keys, ev.TxID, ev.Amount).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	arr := raw.([]interface{})
+	balance, _ = strconv.ParseInt(arr[1].(string), 10, 64)
+	return arr[0].(int64) == 1, balance, nil
+}
+
+// GetBalance: baca balance:{wN}:<user>:<CUR> (minor units). found=false jika key belum ada.
+func (s *RedisWalletStore) GetBalance(ctx context.Context, userID, currency string) (balance int64, found bool, err error) {
+	balance, err = s.rdb.HGet(ctx, s.keyBalance(userID, currency), "amount").Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return balance, true, nil
+}
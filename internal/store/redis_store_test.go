@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDepositScriptIsIdempotent(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	s := NewRedisWalletStore(rdb, 1)
+	ctx := context.Background()
+
+	res, err := s.Deposit(ctx, "1", "usd", "tx-1", 150, nil)
+	if err != nil || res.Code != 1 || !res.Applied || res.Balance != 150 {
+		t.Fatalf("first deposit = %+v, %v; want applied with balance 150", res, err)
+	}
+
+	res, err = s.Deposit(ctx, "1", "USD", "tx-1", 150, nil)
+	if err != nil || res.Code != 0 || res.Applied || res.Balance != 150 {
+		t.Fatalf("replayed deposit = %+v, %v; want code 0 with unchanged balance", res, err)
+	}
+
+	res, err = s.Deposit(ctx, "1", "USD", "tx-2", 50, nil)
+	if err != nil || res.Balance != 200 {
+		t.Fatalf("second deposit = %+v, %v; want balance 200", res, err)
+	}
+
+	// event hanya untuk deposit yang benar-benar diterapkan
+	entries, err := mr.Stream(s.keyStream("1"))
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("stream:wallet entries = %d, %v; want 2", len(entries), err)
+	}
+
+	bal, found, err := s.GetBalance(ctx, "1", "usd")
+	if err != nil || !found || bal != 200 {
+		t.Fatalf("GetBalance = %d, %v, %v; want 200", bal, found, err)
+	}
+}
+
+func TestDepositScriptRejectsInvalidAmount(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	s := NewRedisWalletStore(rdb, 1)
+
+	for _, amt := range []int64{0, -5} {
+		res, err := s.Deposit(context.Background(), "1", "USD", "bad", amt, nil)
+		if err != nil || res.Code != -2 || res.Applied {
+			t.Fatalf("deposit amount %d = %+v, %v; want code -2", amt, res, err)
+		}
+	}
+	if mr.Exists(s.keyTx("1")) || mr.Exists(s.keyStream("1")) {
+		t.Fatal("rejected deposit must not record tx_id or emit an event")
+	}
+}
+
+func TestEventLagCountsEntriesAfterLastDelivered(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	s := NewRedisWalletStore(rdb, 1)
+	ctx := context.Background()
+
+	for _, tx := range []string{"a", "b", "c"} {
+		if _, err := s.Deposit(ctx, "1", "USD", tx, 1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lag, pending, err := s.EventLag(ctx, 0); err != nil || lag != 3 || pending != 0 {
+		t.Fatalf("lag before group = %d/%d, %v; want 3/0", lag, pending, err)
+	}
+
+	if err := s.EnsureEventGroup(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	evs, err := s.ReadEvents(ctx, 0, "c1", 1, -1)
+	if err != nil || len(evs) != 1 || evs[0].TxID != "a" || evs[0].Amount != 1 || evs[0].Type != "DEPOSIT" {
+		t.Fatalf("read = %+v, %v; want DEPOSIT a", evs, err)
+	}
+	if lag, pending, err := s.EventLag(ctx, 0); err != nil || lag != 2 || pending != 1 {
+		t.Fatalf("lag after read = %d/%d, %v; want 2/1", lag, pending, err)
+	}
+
+	if err := s.AckEvents(ctx, 0, evs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending, _ := s.EventLag(ctx, 0); pending != 0 {
+		t.Fatalf("pending after ack = %d, want 0", pending)
+	}
+}
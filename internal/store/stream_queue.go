@@ -0,0 +1,399 @@
+package store
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamQueue: backend Queue di atas Redis Streams.
+//   - sq:{wN}:<user>     stream operasi user (FIFO, head = entry tertua)
+//   - slock:{wN}:<user>  ada = user sudah terjadwal/diproses; menjamin satu entry sched per user
+//   - sched:wallet:{wN}  stream jadwal per shard, dibaca worker lewat consumer group
+//
+// Lease = entry sched ada di PEL worker. Worker mati → entry idle > LeaseTTL
+// di-XAUTOCLAIM worker lain saat Claim. Renew me-reset idle time (XCLAIM JUSTID).
+
+//go:embed lua/stream_enqueue.lua
+var luaStreamEnqueue string
+
+//go:embed lua/stream_finish.lua
+var luaStreamFinish string
+
+//go:embed lua/stream_retry.lua
+var luaStreamRetry string
+
+//go:embed lua/stream_promote_due.lua
+var luaStreamPromoteDue string
+
+//go:embed lua/stream_renew.lua
+var luaStreamRenew string
+
+type StreamQueue struct {
+	parking
+	rdb               redis.UniversalClient
+	Keys              Keyspace
+	pollCursor        atomic.Uint64
+	scrEnqueue        *redis.Script
+	scrFinish         *redis.Script
+	scrRetry          *redis.Script
+	scrPromoteDue     *redis.Script
+	scrRenew          *redis.Script
+	SchedKey          string // e.g. "sched:wallet" (+ ":{wN}")
+	Group             string // consumer group worker di tiap SchedKey shard
+	RetryKey          string // e.g. "sretry:wallet" (+ ":{wN}", ZSET user → jatuh tempo retry ms)
+	KeyStreamPrefix   string // e.g. "sq"
+	KeyLockPrefix     string // e.g. "slock"
+	KeyOpPrefix       string // e.g. "op" (sama dengan RedisQueue)
+	KeyAttemptsPrefix string // e.g. "attempts"
+	OpStatusTTL       time.Duration
+	LeaseTTL          time.Duration // idle maksimum entry sched sebelum di-XAUTOCLAIM
+	MaxAttempts       int64
+	RetryBaseBackoff  time.Duration
+	RetryMaxBackoff   time.Duration
+}
+
+var _ Queue = (*StreamQueue)(nil)
+
+func NewStreamQueue(rdb redis.UniversalClient, shards int) *StreamQueue {
+	q := &StreamQueue{
+		rdb:               rdb,
+		Keys:              NewKeyspace(shards),
+		scrEnqueue:        redis.NewScript(luaStreamEnqueue),
+		scrFinish:         redis.NewScript(luaStreamFinish),
+		scrRetry:          redis.NewScript(luaStreamRetry),
+		scrPromoteDue:     redis.NewScript(luaStreamPromoteDue),
+		scrRenew:          redis.NewScript(luaStreamRenew),
+		SchedKey:          "sched:wallet",
+		Group:             "wallet-workers",
+		RetryKey:          "sretry:wallet",
+		KeyStreamPrefix:   "sq",
+		KeyLockPrefix:     "slock",
+		KeyOpPrefix:       "op",
+		KeyAttemptsPrefix: "attempts",
+		OpStatusTTL:       24 * time.Hour,
+		LeaseTTL:          10 * time.Second,
+		MaxAttempts:       5,
+		RetryBaseBackoff:  200 * time.Millisecond,
+		RetryMaxBackoff:   30 * time.Second,
+	}
+	q.parking = newParking(rdb, q.Keys, q.replay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		_ = q.ensureGroup(ctx, shard)
+	}
+	// Preload scripts (best effort)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = q.scrEnqueue.Load(ctx, rdb).Err()
+		_ = q.scrFinish.Load(ctx, rdb).Err()
+		_ = q.scrRetry.Load(ctx, rdb).Err()
+		_ = q.scrPromoteDue.Load(ctx, rdb).Err()
+		_ = q.scrRenew.Load(ctx, rdb).Err()
+	}()
+	return q
+}
+
+func (q *StreamQueue) keyStream(user string) string   { return q.Keys.User(q.KeyStreamPrefix, user) }
+func (q *StreamQueue) keyLock(user string) string     { return q.Keys.User(q.KeyLockPrefix, user) }
+func (q *StreamQueue) keyOp(user string) string       { return q.Keys.User(q.KeyOpPrefix, user) }
+func (q *StreamQueue) keyAttempts(user string) string { return q.Keys.User(q.KeyAttemptsPrefix, user) }
+
+func (q *StreamQueue) keySched(shard int) string { return q.Keys.Global(q.SchedKey, shard) }
+func (q *StreamQueue) keyRetry(shard int) string { return q.Keys.Global(q.RetryKey, shard) }
+
+// ensureGroup: buat consumer group (dan stream) jika belum ada; mulai dari awal supaya
+// jadwal yang ditulis sebelum group dibuat tidak terlewat
+func (q *StreamQueue) ensureGroup(ctx context.Context, shard int) error {
+	err := q.rdb.XGroupCreateMkStream(ctx, q.keySched(shard), q.Group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (q *StreamQueue) Enqueue(ctx context.Context, p OperationPayload) (EnqueueResult, error) {
+	return q.enqueue(ctx, p, false)
+}
+
+// replay: replay DLQ. Stream tidak bisa disisipi di depan, jadi hanya kalau sq:{user} kosong
+// (op yang gagal memang head terakhir); selain itu ErrReplayQueueNotEmpty.
+func (q *StreamQueue) replay(ctx context.Context, p OperationPayload) (EnqueueResult, error) {
+	return q.enqueue(ctx, p, true)
+}
+
+func (q *StreamQueue) enqueue(ctx context.Context, p OperationPayload, replay bool) (EnqueueResult, error) {
+	replayArg, keepPrefix := "0", ""
+	if replay {
+		replayArg, keepPrefix = "1", DeadLetterReasonPrefix
+	}
+	b, _ := json.Marshal(p)
+	st, _ := json.Marshal(newOpStatus(p, OpPending, ""))
+	keys := []string{q.keyStream(p.UserID), q.keyLock(p.UserID), q.keySched(q.Keys.Shard(p.UserID)), q.keyOp(p.UserID)}
+	args := []any{string(b), p.TxID, string(st), q.OpStatusTTL.Milliseconds(), p.UserID, replayArg, keepPrefix}
+	raw, err := q.scrEnqueue.Run(ctx, q.rdb, keys, args...).Slice()
+	if err != nil {
+		return EnqueueResult{}, err
+	}
+	code, _ := raw[0].(int64)
+	pos, _ := raw[1].(int64)
+	if code == -1 {
+		var prev OpStatus
+		if s, ok := raw[2].(string); ok {
+			_ = json.Unmarshal([]byte(s), &prev)
+		}
+		return EnqueueResult{Duplicate: true, Existing: &prev}, nil
+	}
+	if code == -3 {
+		return EnqueueResult{}, fmt.Errorf("%w (%d queued)", ErrReplayQueueNotEmpty, pos)
+	}
+	return EnqueueResult{Acquired: code == 1, Position: pos}, nil
+}
+
+func (q *StreamQueue) SetOpStatus(ctx context.Context, p OperationPayload, state OpState, reason string) error {
+	return setOpStatus(ctx, q.rdb, q.keyOp(p.UserID), q.OpStatusTTL, p, state, reason)
+}
+
+func (q *StreamQueue) GetOpStatus(ctx context.Context, user, txID string) (*OpStatus, error) {
+	return getOpStatus(ctx, q.rdb, q.keyOp(user), txID)
+}
+
+// Claim: ambil alih dulu entry sched yang idle > LeaseTTL (worker mati), baru baca entry baru.
+// Dengan >1 shard, shard disapu bergiliran tanpa blok; blok hanya di shard cursor (maks maxShardBlock).
+func (q *StreamQueue) Claim(ctx context.Context, worker string, block time.Duration) (*Delivery, error) {
+	n := q.Keys.Shards
+	start := int(q.pollCursor.Add(1) % uint64(n))
+
+	msgs, _, err := q.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   q.keySched(start),
+		Group:    q.Group,
+		Consumer: worker,
+		MinIdle:  q.LeaseTTL,
+		Start:    "0-0",
+		Count:    1,
+	}).Result()
+	if err != nil && !isNoGroup(err) {
+		return nil, err
+	}
+	shard := start
+
+	if len(msgs) == 0 && n > 1 {
+		for i := 0; i < n && len(msgs) == 0; i++ {
+			shard = (start + i) % n
+			if msgs, err = q.readShard(ctx, shard, worker, -1); err != nil {
+				return nil, err
+			}
+		}
+		if len(msgs) == 0 {
+			block = min(block, maxShardBlock)
+		}
+	}
+	if len(msgs) == 0 {
+		shard = start
+		if msgs, err = q.readShard(ctx, shard, worker, block); err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			return nil, ErrNoDelivery
+		}
+	}
+
+	msg := msgs[0]
+	user, _ := msg.Values["user"].(string)
+	d := &Delivery{UserID: user, Worker: worker, ref: msg.ID, shard: shard}
+
+	head, err := q.rdb.XRangeN(ctx, q.keyStream(user), "-", "+", 1).Result()
+	if err != nil {
+		// biarkan di PEL: di-XAUTOCLAIM lagi setelah LeaseTTL
+		return nil, err
+	}
+	if len(head) == 0 {
+		// jadwal basi (antrian sudah kosong): bereskan & unlock
+		if _, err := q.finish(ctx, d); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoDelivery
+	}
+	d.headID = head[0].ID
+	d.Raw, _ = head[0].Values["p"].(string)
+	return d, nil
+}
+
+// readShard: XREADGROUP satu entry baru dari sched shard; block < 0 = tanpa blok
+func (q *StreamQueue) readShard(ctx context.Context, shard int, worker string, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.Group,
+		Consumer: worker,
+		Streams:  []string{q.keySched(shard), ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if isNoGroup(err) {
+		_ = q.ensureGroup(ctx, shard)
+		return nil, nil
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+func (q *StreamQueue) Renew(ctx context.Context, d *Delivery) (bool, error) {
+	res, err := q.scrRenew.Run(ctx, q.rdb, []string{q.keySched(d.shard)}, q.Group, d.Worker, d.ref).Int64()
+	return res == 1, err
+}
+
+func (q *StreamQueue) Ack(ctx context.Context, d *Delivery) error {
+	_, err := q.finish(ctx, d)
+	return err
+}
+
+func (q *StreamQueue) Nack(ctx context.Context, d *Delivery, cause error) (NackResult, error) {
+	keys := []string{q.keySched(d.shard), q.keyRetry(d.shard), q.keyAttempts(d.UserID)}
+	args := []any{q.Group, d.Worker, d.ref, d.TxID, d.UserID, nowMillis(), q.MaxAttempts, q.RetryBaseBackoff.Milliseconds(), q.RetryMaxBackoff.Milliseconds()}
+	res, err := q.scrRetry.Run(ctx, q.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return NackResult{}, err
+	}
+	switch res[0] {
+	case -2:
+		return NackResult{}, ErrLeaseLost
+	case 1:
+		return NackResult{Attempts: res[1], Backoff: time.Duration(res[2]) * time.Millisecond}, nil
+	}
+
+	dl := newDeadLetter(d.UserID, d.TxID, d.Raw, cause, res[1])
+	b, _ := json.Marshal(dl)
+	if _, err := q.finishPark(ctx, d, q.DLQKey, dl.ID, string(b)); err != nil {
+		return NackResult{Attempts: res[1]}, err
+	}
+	return NackResult{Attempts: res[1], DeadLettered: true}, nil
+}
+
+func (q *StreamQueue) Quarantine(ctx context.Context, d *Delivery, cause error) (*Quarantined, error) {
+	rec := newQuarantined(d.UserID, d.Raw, cause)
+	b, _ := json.Marshal(rec)
+	if _, err := q.finishPark(ctx, d, q.QuarantineKey, rec.ID, string(b)); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Heartbeat: tidak perlu, liveness dilihat dari idle time PEL
+func (q *StreamQueue) Heartbeat(ctx context.Context, workers ...string) error { return nil }
+
+// Unregister: hapus consumer dari group tiap shard jika tidak ada entry pending miliknya
+func (q *StreamQueue) Unregister(ctx context.Context, worker string) error {
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		pending, err := q.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   q.keySched(shard),
+			Group:    q.Group,
+			Start:    "-",
+			End:      "+",
+			Count:    1,
+			Consumer: worker,
+		}).Result()
+		if err != nil && !isNoGroup(err) {
+			return err
+		}
+		if err != nil || len(pending) > 0 {
+			continue
+		}
+		if err := q.rdb.XGroupDelConsumer(ctx, q.keySched(shard), q.Group, worker).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reap: tidak perlu sweep terpisah, entry worker mati diambil lewat XAUTOCLAIM di Claim
+func (q *StreamQueue) Reap(ctx context.Context) (int, error) { return 0, nil }
+
+func (q *StreamQueue) PromoteDue(ctx context.Context) (int, error) {
+	total := 0
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		n, err := q.scrPromoteDue.Run(ctx, q.rdb, []string{q.keyRetry(shard), q.keySched(shard)}, nowMillis(), 100).Int()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Sample: padanan RedisQueue.Sample. Ready = lag group sched:wallet:{wN} (user terjadwal yang
+// belum diambil worker), Depths = XLEN sq:{wN}:<user> untuk maks perShard jadwal tertua itu.
+func (q *StreamQueue) Sample(ctx context.Context, perShard int64) (QueueSample, error) {
+	out := QueueSample{Ready: make([]int64, q.Keys.Shards)}
+	for shard := 0; shard < q.Keys.Shards; shard++ {
+		lag, _, delivered, err := groupLag(ctx, q.rdb, q.keySched(shard), q.Group)
+		if err != nil {
+			return out, err
+		}
+		out.Ready[shard] = lag
+		if lag == 0 || perShard <= 0 {
+			continue
+		}
+		start := "-"
+		if delivered != "" {
+			start = "(" + delivered
+		}
+		msgs, err := q.rdb.XRangeN(ctx, q.keySched(shard), start, "+", perShard).Result()
+		if err != nil {
+			return out, err
+		}
+		pipe := q.rdb.Pipeline()
+		lens := make([]*redis.IntCmd, 0, len(msgs))
+		for _, m := range msgs {
+			if user, _ := m.Values["user"].(string); user != "" {
+				lens = append(lens, pipe.XLen(ctx, q.keyStream(user)))
+			}
+		}
+		if len(lens) == 0 {
+			continue
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return out, err
+		}
+		for _, c := range lens {
+			if c.Val() > 0 {
+				out.Depths = append(out.Depths, c.Val())
+			}
+		}
+	}
+	return out, nil
+}
+
+func (q *StreamQueue) finish(ctx context.Context, d *Delivery) (int64, error) {
+	return q.finishPark(ctx, d, q.DLQKey, "", "")
+}
+
+// finishPark: XDEL head (opsional diparkir dulu), XACK entry sched, jadwalkan user lagi jika masih ada antrian
+func (q *StreamQueue) finishPark(ctx context.Context, d *Delivery, parkKey, field, record string) (int64, error) {
+	keys := []string{q.keyStream(d.UserID), q.keyLock(d.UserID), q.keySched(d.shard), q.keyAttempts(d.UserID), q.Keys.Global(parkKey, d.shard)}
+	args := []any{q.Group, d.Worker, d.ref, d.headID, d.TxID, d.UserID, field, record}
+	res, err := q.scrFinish.Run(ctx, q.rdb, keys, args...).Int64()
+	if err != nil {
+		return res, err
+	}
+	if res == -2 {
+		return res, ErrLeaseLost
+	}
+	return res, nil
+}
+
+func isNoGroup(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOGROUP")
+}
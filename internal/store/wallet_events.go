@@ -0,0 +1,392 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EventGroup: consumer group persister di tiap stream:wallet:{wN}
+const EventGroup = "persister"
+
+// WalletEvent: satu entry stream:wallet hasil deposit.lua
+type WalletEvent struct {
+	ID       string // stream entry ID
+	Shard    int
+	Type     string // DEPOSIT
+	UserID   string
+	Currency string
+	TxID     string
+	Amount   int64 // minor units
+	TS       int64 // unix millis saat diterapkan di Redis
+	Meta     map[string]string
+}
+
+// StreamKeyName: nama stream:wallet untuk shard
+func (s *RedisWalletStore) StreamKeyName(shard int) string {
+	return s.Keys.Global(streamWallet, shard)
+}
+
+// EnsureEventGroup: buat group (dan stream) jika belum ada; mulai dari "0" supaya
+// event yang ditulis sebelum persister pertama jalan tetap ikut tersimpan
+func (s *RedisWalletStore) EnsureEventGroup(ctx context.Context, shard int) error {
+	err := s.rdb.XGroupCreateMkStream(ctx, s.StreamKeyName(shard), EventGroup, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadEvents: XREADGROUP event baru (">") untuk consumer; block < 0 = tanpa blok
+func (s *RedisWalletStore) ReadEvents(ctx context.Context, shard int, consumer string, count int64, block time.Duration) ([]WalletEvent, error) {
+	return s.readEvents(ctx, shard, consumer, ">", count, block)
+}
+
+// PendingEvents: event yang sudah dikirim ke consumer ini tapi belum di-ACK (PEL),
+// mis. gagal commit atau hasil ClaimStaleEvents. after = cursor (ID terakhir halaman
+// sebelumnya, "0" = dari awal) supaya PEL lebih besar dari count tetap terjelajah semua.
+func (s *RedisWalletStore) PendingEvents(ctx context.Context, shard int, consumer, after string, count int64) ([]WalletEvent, error) {
+	return s.readEvents(ctx, shard, consumer, after, count, -1)
+}
+
+// EventDeliveries: jumlah delivery (XPENDING) tiap id yang masih di PEL; id yang sudah
+// di-ACK tidak muncul
+func (s *RedisWalletStore) EventDeliveries(ctx context.Context, shard int, ids ...string) (map[string]int64, error) {
+	out := make(map[string]int64, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	lo, hi := ids[0], ids[0]
+	for _, id := range ids[1:] {
+		if compareStreamID(id, lo) < 0 {
+			lo = id
+		}
+		if compareStreamID(id, hi) > 0 {
+			hi = id
+		}
+	}
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.StreamKeyName(shard),
+		Group:  EventGroup,
+		Start:  lo,
+		End:    hi,
+		Count:  max(int64(len(ids)), 100),
+	}).Result()
+	if err != nil {
+		return out, err
+	}
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	for _, pe := range pending {
+		if want[pe.ID] {
+			out[pe.ID] = pe.RetryCount
+		}
+	}
+	return out, nil
+}
+
+func (s *RedisWalletStore) readEvents(ctx context.Context, shard int, consumer, id string, count int64, block time.Duration) ([]WalletEvent, error) {
+	streams, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    EventGroup,
+		Consumer: consumer,
+		Streams:  []string{s.StreamKeyName(shard), id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if isNoGroup(err) {
+		return nil, s.EnsureEventGroup(ctx, shard)
+	}
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	msgs := streams[0].Messages
+	// COUNT dijaga juga di sini: cursor PendingEvents bergantung pada halaman <= count
+	// (miniredis mengabaikan COUNT untuk bacaan PEL)
+	if count > 0 && int64(len(msgs)) > count {
+		msgs = msgs[:count]
+	}
+	return parseWalletEvents(shard, msgs), nil
+}
+
+// ClaimStaleEvents: XAUTOCLAIM event yang idle > minIdle di PEL consumer lain (persister
+// mati/hang) ke consumer ini. Event hasil klaim ikut masuk PEL consumer ini.
+func (s *RedisWalletStore) ClaimStaleEvents(ctx context.Context, shard int, consumer string, minIdle time.Duration, count int64) ([]WalletEvent, error) {
+	var out []WalletEvent
+	start := "0-0"
+	for {
+		msgs, next, err := s.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.StreamKeyName(shard),
+			Group:    EventGroup,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    start,
+			Count:    count,
+		}).Result()
+		if isNoGroup(err) {
+			return out, s.EnsureEventGroup(ctx, shard)
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, parseWalletEvents(shard, msgs)...)
+		if next == "0-0" || next == "" || int64(len(out)) >= count {
+			return out, nil
+		}
+		start = next
+	}
+}
+
+// PruneEventConsumers: hapus consumer lain yang sudah idle > idle dan PEL-nya kosong
+// (nama consumer = host:pid, jadi tiap restart menambah satu)
+func (s *RedisWalletStore) PruneEventConsumers(ctx context.Context, shard int, self string, idle time.Duration) (int, error) {
+	key := s.StreamKeyName(shard)
+	consumers, err := s.rdb.XInfoConsumers(ctx, key, EventGroup).Result()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range consumers {
+		if c.Name == self || c.Pending > 0 || c.Idle < idle {
+			continue
+		}
+		if err := s.rdb.XGroupDelConsumer(ctx, key, EventGroup, c.Name).Err(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// lagScanCap: batas hitung manual lag (fallback jika Redis tidak memberi lag)
+const lagScanCap = 10000
+
+// EventLag: lag = entry stream:wallet setelah last-delivered-id group (belum pernah dibaca),
+// pending = sudah dibaca tapi belum di-ACK
+func (s *RedisWalletStore) EventLag(ctx context.Context, shard int) (lag, pending int64, err error) {
+	lag, pending, _, err = groupLag(ctx, s.rdb, s.StreamKeyName(shard), EventGroup)
+	return lag, pending, err
+}
+
+// groupLag: lag & pending consumer group di stream key, plus last-delivered-id ("" kalau group
+// belum ada). Lag dari XINFO GROUPS (Redis 7) dipakai kalau tersedia; kalau tidak (mis. setelah
+// XDEL) dihitung XRANGE (last-delivered-id, +], maks lagScanCap.
+func groupLag(ctx context.Context, rdb redis.UniversalClient, key, group string) (lag, pending int64, lastDelivered string, err error) {
+	groups, err := rdb.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return 0, 0, "", nil
+		}
+		return 0, 0, "", err
+	}
+	for _, g := range groups {
+		if g.Name != group {
+			continue
+		}
+		if g.EntriesRead > 0 && g.Lag >= 0 {
+			return g.Lag, g.Pending, g.LastDeliveredID, nil
+		}
+		msgs, err := rdb.XRangeN(ctx, key, "("+g.LastDeliveredID, "+", lagScanCap).Result()
+		if err != nil {
+			return 0, g.Pending, g.LastDeliveredID, err
+		}
+		return int64(len(msgs)), g.Pending, g.LastDeliveredID, nil
+	}
+	// group belum dibuat: semua entry belum terkirim
+	lag, err = rdb.XLen(ctx, key).Result()
+	return lag, 0, "", err
+}
+
+// StreamTip: ID entry terakhir stream:wallet shard ("0-0" kalau kosong). deposit.lua mengubah
+// saldo dan XADD dalam satu script, jadi tip yang dibaca SETELAH saldo mencakup semua event
+// yang sudah masuk ke saldo tersebut.
+func (s *RedisWalletStore) StreamTip(ctx context.Context, shard int) (string, error) {
+	msgs, err := s.rdb.XRevRangeN(ctx, s.StreamKeyName(shard), "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// EventsPersistedThrough: semua event <= upTo sudah di-ACK group persister, yaitu
+// last-delivered-id >= upTo dan tidak ada entry PEL <= upTo. reason diisi kalau belum.
+func (s *RedisWalletStore) EventsPersistedThrough(ctx context.Context, shard int, upTo string) (ok bool, reason string, err error) {
+	if upTo == "0-0" {
+		return true, "", nil
+	}
+	key := s.StreamKeyName(shard)
+	groups, err := s.rdb.XInfoGroups(ctx, key).Result()
+	if err != nil {
+		return false, "", err
+	}
+	delivered := ""
+	for _, g := range groups {
+		if g.Name == EventGroup {
+			delivered = g.LastDeliveredID
+		}
+	}
+	if delivered == "" {
+		return false, fmt.Sprintf("%s not drained (group %s missing)", key, EventGroup), nil
+	}
+	if compareStreamID(delivered, upTo) < 0 {
+		return false, fmt.Sprintf("%s not drained (delivered %s < %s)", key, delivered, upTo), nil
+	}
+	pending, err := s.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  EventGroup,
+		Start:  "-",
+		End:    upTo,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return false, "", err
+	}
+	if len(pending) > 0 {
+		return false, fmt.Sprintf("%s not drained (pending %s <= %s)", key, pending[0].ID, upTo), nil
+	}
+	return true, "", nil
+}
+
+// compareStreamID: bandingkan ID stream "ms-seq" secara numerik
+func compareStreamID(a, b string) int {
+	am, as := splitStreamID(a)
+	bm, bs := splitStreamID(b)
+	if c := cmp.Compare(am, bm); c != 0 {
+		return c
+	}
+	return cmp.Compare(as, bs)
+}
+
+func splitStreamID(id string) (ms, seq uint64) {
+	m, sq, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(sq, 10, 64)
+	return ms, seq
+}
+
+// DeadEvent: event stream:wallet yang gagal dipersist MaxDeliveries kali, disimpan di
+// dlq:events:wallet. Saldo Redis sudah berubah: Postgres disusulkan lewat
+// `admin reconcile -repair postgres` setelah penyebabnya diperbaiki.
+type DeadEvent struct {
+	ID         string            `json:"id"` // <user>:<tx_id>
+	EntryID    string            `json:"entry_id"`
+	Shard      int               `json:"shard"`
+	UserID     string            `json:"user_id"`
+	TxID       string            `json:"tx_id"`
+	Currency   string            `json:"currency"`
+	Amount     int64             `json:"amount"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	Error      string            `json:"error"`
+	Deliveries int64             `json:"deliveries"`
+	FailedAt   int64             `json:"failed_at"` // unix millis
+}
+
+// eventDLQ: HASH <user>:<tx_id> → DeadEvent JSON, per shard (tag sama dengan stream-nya)
+const eventDLQ = "dlq:events:wallet"
+
+// DeadLetterEvent: simpan event ke dlq:events:wallet lalu XACK, satu MULTI (slot sama)
+func (s *RedisWalletStore) DeadLetterEvent(ctx context.Context, ev WalletEvent, reason error, deliveries int64) error {
+	de := DeadEvent{
+		ID:         ev.UserID + ":" + ev.TxID,
+		EntryID:    ev.ID,
+		Shard:      ev.Shard,
+		UserID:     ev.UserID,
+		TxID:       ev.TxID,
+		Currency:   ev.Currency,
+		Amount:     ev.Amount,
+		Meta:       ev.Meta,
+		Error:      reason.Error(),
+		Deliveries: deliveries,
+		FailedAt:   time.Now().UnixMilli(),
+	}
+	b, _ := json.Marshal(de)
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.Keys.Global(eventDLQ, ev.Shard), de.ID, b)
+		pipe.XAck(ctx, s.StreamKeyName(ev.Shard), EventGroup, ev.ID)
+		return nil
+	})
+	return err
+}
+
+// ListDeadEvents: isi dlq:events:wallet semua shard (HSCAN), maks limit entry
+func (s *RedisWalletStore) ListDeadEvents(ctx context.Context, limit int) ([]DeadEvent, error) {
+	var out []DeadEvent
+	for shard := 0; shard < s.Keys.Shards && len(out) < limit; shard++ {
+		var cursor uint64
+		for {
+			kv, next, err := s.rdb.HScan(ctx, s.Keys.Global(eventDLQ, shard), cursor, "*", 100).Result()
+			if err != nil {
+				return out, err
+			}
+			for i := 1; i < len(kv) && len(out) < limit; i += 2 {
+				var de DeadEvent
+				if err := json.Unmarshal([]byte(kv[i]), &de); err != nil {
+					de = DeadEvent{ID: kv[i-1], Error: "undecodable dead event record"}
+				}
+				out = append(out, de)
+			}
+			cursor = next
+			if cursor == 0 || len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// AckEvents: XACK setelah event tersimpan di Postgres
+func (s *RedisWalletStore) AckEvents(ctx context.Context, shard int, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.rdb.XAck(ctx, s.StreamKeyName(shard), EventGroup, ids...).Err()
+}
+
+func parseWalletEvents(shard int, msgs []redis.XMessage) []WalletEvent {
+	out := make([]WalletEvent, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, parseWalletEvent(shard, m))
+	}
+	return out
+}
+
+func parseWalletEvent(shard int, m redis.XMessage) WalletEvent {
+	str := func(k string) string { v, _ := m.Values[k].(string); return v }
+	ev := WalletEvent{
+		ID:       m.ID,
+		Shard:    shard,
+		Type:     str("type"),
+		UserID:   str("user_id"),
+		Currency: str("currency"),
+		TxID:     str("tx_id"),
+	}
+	ev.Amount, _ = strconv.ParseInt(str("amount"), 10, 64)
+	ev.TS, _ = strconv.ParseInt(str("ts"), 10, 64)
+
+	// meta ditulis sebagai map[string]any; nilai non-string di-stringify
+	var meta map[string]any
+	if err := json.Unmarshal([]byte(str("meta")), &meta); err == nil && len(meta) > 0 {
+		ev.Meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			if sv, ok := v.(string); ok {
+				ev.Meta[k] = sv
+			} else {
+				ev.Meta[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	return ev
+}
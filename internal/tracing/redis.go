@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScriptHook: span untuk EVAL/EVALSHA (script Lua antrian & deposit). Command lain tidak
+// di-trace supaya BLMOVE/heartbeat yang sering tidak membanjiri collector.
+type ScriptHook struct{}
+
+var _ redis.Hook = ScriptHook{}
+
+func (ScriptHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (ScriptHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (ScriptHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		name := strings.ToUpper(cmd.Name())
+		if !strings.HasPrefix(name, "EVAL") || !trace.SpanContextFromContext(ctx).IsValid() {
+			return next(ctx, cmd)
+		}
+
+		args := cmd.Args()
+		attrs := []attribute.KeyValue{
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", name),
+		}
+		if len(args) > 1 && name != "EVAL" {
+			attrs = append(attrs, attribute.String("redis.script.sha", toString(args[1])))
+		}
+		if len(args) > 3 {
+			attrs = append(attrs, attribute.String("redis.script.key", toString(args[3]))) // KEYS[1]
+		}
+		ctx, span := Tracer().Start(ctx, "redis.script", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+		defer span.End()
+
+		err := next(ctx, cmd)
+		if err != nil && err != redis.Nil && !isNoScript(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+// NOSCRIPT dari EVALSHA normal: redis.Script.Run langsung fallback ke EVAL
+func isNoScript(err error) bool { return strings.HasPrefix(err.Error(), "NOSCRIPT") }
+
+func toString(v any) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,155 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/model"
+	"grls/internal/store"
+	"grls/pkg/logger"
+)
+
+// Repository: sumber backfill di Postgres.
+// Implementasi: *repository.WalletRepository, *repository.MemoryWalletRepository (test).
+type Repository interface {
+	ScanWallets(ctx context.Context, after repository.WalletKey, limit int) ([]model.Wallet, error)
+	ScanTransactions(ctx context.Context, since time.Time, afterID int64, limit int) ([]model.WalletTransaction, error)
+}
+
+var (
+	_ Repository = (*repository.WalletRepository)(nil)
+	_ Repository = (*repository.MemoryWalletRepository)(nil)
+)
+
+// Warmer: isi ulang balance:{wN}:<user>:<CUR> dan tx:{wN}:<user> dari Postgres setelah
+// Redis kosong (flush / instance baru). Per chunk: satu query keyset + satu pipeline.
+type Warmer struct {
+	Store *store.RedisWalletStore
+	Repo  Repository
+	Batch int
+	// TxWindow: tx_id yang dimuat (created_at dalam jendela ini). Retry tx_id yang lebih tua
+	// dikredit lagi oleh deposit.lua; persister membatalkannya saat Postgres menolak duplikat,
+	// jadi saldo Redis sementara lebih besar sampai event itu di-persist.
+	TxWindow time.Duration
+	// Decimals: jumlah desimal minor unit per currency (sama dengan reconcile.Reconciler).
+	// Default 0: minor unit dianggap sama dengan NUMERIC.
+	Decimals func(currency string) int32
+}
+
+type Stats struct {
+	Wallets  int // wallet Postgres yang dibaca
+	Balances int // key balance yang diisi (yang sudah ada tidak ditimpa)
+	Skipped  int // saldo yang tidak bisa jadi minor unit integer
+	TxIDs    int // tx_id yang ditandai
+	TxSince  time.Time
+	Duration time.Duration
+}
+
+func New(ws *store.RedisWalletStore, repo Repository) *Warmer {
+	return &Warmer{
+		Store:    ws,
+		Repo:     repo,
+		Batch:    1000,
+		TxWindow: 72 * time.Hour,
+		Decimals: func(string) int32 { return 0 },
+	}
+}
+
+// Run: backfill saldo lalu tx_id. Aman diulang: saldo pakai HSETNX, tx_id HSET idempotent.
+// Dipanggil sebelum gRPC SERVING, jadi tidak ada deposit yang masuk di tengah backfill.
+func (w *Warmer) Run(ctx context.Context) (Stats, error) {
+	start := time.Now()
+	st := Stats{TxSince: start.Add(-w.TxWindow)}
+
+	after := repository.WalletKey{}
+	for {
+		ws, err := w.Repo.ScanWallets(ctx, after, w.Batch)
+		if err != nil {
+			return st, fmt.Errorf("scan wallets: %w", err)
+		}
+		if len(ws) == 0 {
+			break
+		}
+		entries := make([]store.BalanceEntry, 0, len(ws))
+		for _, wl := range ws {
+			minor, ok := w.toMinor(wl)
+			if !ok {
+				st.Skipped++
+				continue
+			}
+			entries = append(entries, store.BalanceEntry{
+				BalanceKey: store.BalanceKey{UserID: strconv.FormatInt(wl.UserID, 10), Currency: wl.Currency},
+				Amount:     minor,
+			})
+		}
+		n, err := w.Store.WarmBalances(ctx, entries)
+		if err != nil {
+			return st, fmt.Errorf("warm balances: %w", err)
+		}
+		st.Wallets += len(ws)
+		st.Balances += n
+		last := ws[len(ws)-1]
+		after = repository.WalletKey{UserID: last.UserID, Currency: last.Currency}
+	}
+
+	var afterID int64
+	for {
+		txs, err := w.Repo.ScanTransactions(ctx, st.TxSince, afterID, w.Batch)
+		if err != nil {
+			return st, fmt.Errorf("scan transactions: %w", err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+		refs := make([]store.TxRef, len(txs))
+		for i, t := range txs {
+			refs[i] = store.TxRef{UserID: strconv.FormatInt(t.UserID, 10), TxID: t.TxID}
+		}
+		if err := w.Store.WarmTxIDs(ctx, refs); err != nil {
+			return st, fmt.Errorf("warm tx ids: %w", err)
+		}
+		st.TxIDs += len(txs)
+		afterID = txs[len(txs)-1].ID
+	}
+
+	st.Duration = time.Since(start)
+	return st, nil
+}
+
+// toMinor: NUMERIC → minor unit integer; false jika ada presisi di bawah minor unit
+func (w *Warmer) toMinor(wl model.Wallet) (int64, bool) {
+	bal, err := decimal.NewFromString(wl.Balance)
+	if err != nil {
+		logger.Errorf("warmup skip user=%d cur=%s: bad balance %q", wl.UserID, wl.Currency, wl.Balance)
+		return 0, false
+	}
+	dec := w.Decimals(wl.Currency)
+	if !bal.Equal(bal.Truncate(dec)) {
+		logger.Errorf("warmup skip user=%d cur=%s: balance %s has more than %d decimals", wl.UserID, wl.Currency, bal, dec)
+		return 0, false
+	}
+	return bal.Shift(dec).IntPart(), true
+}
+
+// RunUntilDone: ulangi Run dengan backoff sampai berhasil atau ctx selesai
+func (w *Warmer) RunUntilDone(ctx context.Context) (Stats, error) {
+	backoff := time.Second
+	for {
+		st, err := w.Run(ctx)
+		if err == nil {
+			return st, nil
+		}
+		logger.Errorf("warmup failed, retry in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return st, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
@@ -0,0 +1,77 @@
+package warmup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+
+	"grls/internal/infrastructure/repository"
+	"grls/internal/store"
+)
+
+func TestWarmupRestoresBalancesAndDedupe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ws := store.NewRedisWalletStore(rdb, 2)
+	repo := repository.NewMemoryWalletRepository()
+	ctx := context.Background()
+
+	for user, tx := range map[int64]string{1: "tx-a", 2: "tx-b", 3: "tx-c"} {
+		_, err := repo.UpsertDepositDecimal(ctx, repository.OperationInput{
+			TxID: tx, UserID: user, Currency: "IDR", Amount: decimal.NewFromInt(100 * user),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo.SetBalance(4, "USD", decimal.RequireFromString("1.005")) // tidak bisa jadi sen
+	// key yang sudah ada (deposit belum sampai Postgres) tidak boleh ditimpa
+	if _, err := ws.SetBalanceIf(ctx, "3", "IDR", nil, 999); err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(ws, repo)
+	w.Batch = 2
+	w.Decimals = func(cur string) int32 {
+		if cur == "USD" {
+			return 2
+		}
+		return 0
+	}
+	st, err := w.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Wallets != 4 || st.Balances != 2 || st.Skipped != 1 || st.TxIDs != 3 {
+		t.Fatalf("stats = %+v, want wallets=4 balances=2 skipped=1 tx_ids=3", st)
+	}
+
+	for user, want := range map[string]int64{"1": 100, "2": 200, "3": 999} {
+		bal, found, err := ws.GetBalance(ctx, user, "IDR")
+		if err != nil || !found || bal != want {
+			t.Fatalf("balance %s = %d/%v, %v; want %d", user, bal, found, err, want)
+		}
+	}
+
+	// replay tx_id yang sudah ada di Postgres ditolak deposit.lua, deposit baru lanjut dari saldo lama
+	res, err := ws.Deposit(ctx, "2", "IDR", "tx-b", 200, nil)
+	if err != nil || res.Code != 0 || res.Balance != 200 {
+		t.Fatalf("replay = %+v, %v; want duplicate with balance 200", res, err)
+	}
+	res, err = ws.Deposit(ctx, "2", "IDR", "tx-new", 5, nil)
+	if err != nil || !res.Applied || res.Balance != 205 {
+		t.Fatalf("new deposit = %+v, %v; want balance 205", res, err)
+	}
+
+	// diulang: tidak menimpa apa pun
+	if st, err := w.Run(ctx); err != nil || st.Balances != 0 {
+		t.Fatalf("second run = %+v, %v; want 0 balances set", st, err)
+	}
+	if bal, _, _ := ws.GetBalance(ctx, "2", "IDR"); bal != 205 {
+		t.Fatalf("balance after rerun = %d, want 205", bal)
+	}
+}
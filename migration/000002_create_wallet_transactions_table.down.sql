@@ -0,0 +1,2 @@
+-- 000002_create_wallet_transactions_table.down.sql
+DROP TABLE IF EXISTS wallet_transactions;
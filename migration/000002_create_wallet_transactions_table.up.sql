@@ -0,0 +1,17 @@
+-- Catatan idempotensi per operasi: satu row per (user_id, tx_id)
+CREATE TABLE IF NOT EXISTS wallet_transactions (
+    id          BIGSERIAL     PRIMARY KEY,
+    user_id     BIGINT        NOT NULL,
+    tx_id       VARCHAR(128)  NOT NULL,
+    type        VARCHAR(16)   NOT NULL,
+    currency    VARCHAR(10)   NOT NULL,
+    amount      NUMERIC(20,8) NOT NULL,
+    to_user_id  BIGINT        NULL,
+    status      VARCHAR(16)   NOT NULL,
+    error       TEXT          NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT uq_wallet_tx        UNIQUE (user_id, tx_id),
+    CONSTRAINT ck_wallet_tx_type   CHECK (type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER')),
+    CONSTRAINT ck_wallet_tx_status CHECK (status IN ('APPLIED', 'FAILED'))
+);
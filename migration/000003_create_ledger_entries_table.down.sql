@@ -0,0 +1,7 @@
+-- 000003_create_ledger_entries_table.down.sql
+DROP TRIGGER IF EXISTS trg_ledger_balanced ON ledger_entries;
+DROP FUNCTION IF EXISTS check_ledger_balanced();
+
+DROP INDEX IF EXISTS idx_ledger_wallet_tx;
+DROP INDEX IF EXISTS idx_ledger_wallet;
+DROP TABLE IF EXISTS ledger_entries;
@@ -0,0 +1,57 @@
+-- Jurnal double-entry: setiap operasi = posting DEBIT/CREDIT yang seimbang.
+-- Akun 'wallet' = saldo user (CREDIT menambah, DEBIT mengurangi),
+-- akun 'clearing' = lawan transaksi dari/ke luar sistem (deposit/withdraw).
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id             BIGSERIAL     PRIMARY KEY,
+    wallet_tx_id   BIGINT        NULL REFERENCES wallet_transactions(id),
+    tx_id          VARCHAR(128)  NOT NULL,
+    op_type        VARCHAR(16)   NOT NULL,
+    account        VARCHAR(16)   NOT NULL,
+    user_id        BIGINT        NOT NULL,
+    currency       VARCHAR(10)   NOT NULL,
+    direction      VARCHAR(6)    NOT NULL,
+    amount         NUMERIC(20,8) NOT NULL,
+    balance_after  NUMERIC(20,8) NULL,  -- saldo wallet setelah posting; NULL untuk akun clearing
+    meta           JSONB         NOT NULL DEFAULT '{}',
+    created_at     TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT ck_ledger_amount_pos CHECK (amount > 0),
+    CONSTRAINT ck_ledger_direction  CHECK (direction IN ('DEBIT', 'CREDIT')),
+    CONSTRAINT ck_ledger_account    CHECK (account IN ('wallet', 'clearing')),
+    CONSTRAINT ck_ledger_op_type    CHECK (op_type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER', 'OPENING'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_wallet    ON ledger_entries(user_id, currency, id) WHERE account = 'wallet';
+CREATE INDEX IF NOT EXISTS idx_ledger_wallet_tx ON ledger_entries(wallet_tx_id);
+
+-- Setiap operasi harus seimbang (total DEBIT = total CREDIT), dicek saat commit
+CREATE OR REPLACE FUNCTION check_ledger_balanced() RETURNS TRIGGER AS $$
+DECLARE
+  diff NUMERIC;
+BEGIN
+  SELECT COALESCE(SUM(CASE WHEN direction = 'DEBIT' THEN amount ELSE -amount END), 0)
+    INTO diff
+    FROM ledger_entries
+   WHERE tx_id = NEW.tx_id
+     AND wallet_tx_id IS NOT DISTINCT FROM NEW.wallet_tx_id;
+  IF diff <> 0 THEN
+    RAISE EXCEPTION 'unbalanced ledger for tx_id=% (diff=%)', NEW.tx_id, diff;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_ledger_balanced ON ledger_entries;
+CREATE CONSTRAINT TRIGGER trg_ledger_balanced
+AFTER INSERT ON ledger_entries
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE FUNCTION check_ledger_balanced();
+
+-- Saldo awal: wallet yang sudah ada sebelum ledger dicatat sebagai OPENING,
+-- supaya saldo yang diturunkan dari jurnal sama dengan wallets.balance saat ini.
+INSERT INTO ledger_entries (wallet_tx_id, tx_id, op_type, account, user_id, currency, direction, amount, balance_after)
+SELECT NULL, 'opening:' || w.id, 'OPENING', a.account, w.user_id, w.currency, a.direction, w.balance,
+       CASE WHEN a.account = 'wallet' THEN w.balance END
+  FROM wallets w
+ CROSS JOIN (VALUES ('clearing', 'DEBIT'), ('wallet', 'CREDIT')) AS a(account, direction)
+ WHERE w.balance > 0;
@@ -0,0 +1,3 @@
+-- 000004_create_quarantined_payloads_table.down.sql
+DROP INDEX IF EXISTS idx_quarantine_user;
+DROP TABLE IF EXISTS quarantined_payloads;
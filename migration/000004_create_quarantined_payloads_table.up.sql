@@ -0,0 +1,17 @@
+-- Payload q:{user} yang tidak bisa di-decode: disimpan apa adanya untuk audit & perbaikan manual
+CREATE TABLE IF NOT EXISTS quarantined_payloads (
+    id                BIGSERIAL     PRIMARY KEY,
+    quarantine_id     VARCHAR(160)  NOT NULL,  -- field di quarantine:wallet (<user>:<ms>)
+    user_id           VARCHAR(64)   NOT NULL,  -- dari key q:{user}, belum tentu numerik
+    raw_payload       TEXT          NOT NULL,
+    error             TEXT          NOT NULL,
+    status            VARCHAR(16)   NOT NULL DEFAULT 'QUARANTINED',
+    repaired_payload  TEXT          NULL,
+    created_at        TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    resolved_at       TIMESTAMPTZ   NULL,
+
+    CONSTRAINT uq_quarantine_id     UNIQUE (quarantine_id),
+    CONSTRAINT ck_quarantine_status CHECK (status IN ('QUARANTINED', 'REINJECTED'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_quarantine_user ON quarantined_payloads(user_id, id);
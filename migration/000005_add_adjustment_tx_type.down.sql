@@ -0,0 +1,9 @@
+-- 000005_add_adjustment_tx_type.down.sql
+-- Gagal jika sudah ada row ADJUSTMENT (sengaja: jurnal tidak boleh hilang diam-diam)
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ck_ledger_op_type;
+ALTER TABLE ledger_entries
+    ADD CONSTRAINT ck_ledger_op_type CHECK (op_type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER', 'OPENING'));
+
+ALTER TABLE wallet_transactions DROP CONSTRAINT IF EXISTS ck_wallet_tx_type;
+ALTER TABLE wallet_transactions
+    ADD CONSTRAINT ck_wallet_tx_type CHECK (type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER'));
@@ -0,0 +1,9 @@
+-- ADJUSTMENT: koreksi saldo hasil rekonsiliasi Redis ↔ Postgres (admin reconcile).
+-- Tetap dijurnal seimbang lawan akun clearing.
+ALTER TABLE wallet_transactions DROP CONSTRAINT IF EXISTS ck_wallet_tx_type;
+ALTER TABLE wallet_transactions
+    ADD CONSTRAINT ck_wallet_tx_type CHECK (type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER', 'ADJUSTMENT'));
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ck_ledger_op_type;
+ALTER TABLE ledger_entries
+    ADD CONSTRAINT ck_ledger_op_type CHECK (op_type IN ('DEPOSIT', 'WITHDRAW', 'TRANSFER', 'OPENING', 'ADJUSTMENT'));
@@ -0,0 +1,20 @@
+-- 000006_create_currencies_table.down.sql
+-- Kembalikan ke minor unit as-is sebelum registry dihapus
+UPDATE ledger_entries l
+   SET amount        = l.amount * (10 ^ c.decimals)::NUMERIC,
+       balance_after = l.balance_after * (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = l.currency AND c.decimals > 0;
+
+UPDATE wallet_transactions t
+   SET amount = t.amount * (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = t.currency AND c.decimals > 0;
+
+UPDATE wallets w
+   SET balance = w.balance * (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = w.currency AND c.decimals > 0;
+
+DROP TRIGGER IF EXISTS trg_currencies_updated_at ON currencies;
+DROP TABLE IF EXISTS currencies;
@@ -0,0 +1,49 @@
+-- Registry currency: amount di API = minor unit (integer), disimpan sebagai major unit
+-- NUMERIC(20,8) = minor / 10^decimals. decimals maks 8 mengikuti skala NUMERIC(20,8).
+CREATE TABLE IF NOT EXISTS currencies (
+    code        VARCHAR(10)   PRIMARY KEY,
+    name        VARCHAR(64)   NOT NULL DEFAULT '',
+    decimals    SMALLINT      NOT NULL,
+    network     VARCHAR(32)   NOT NULL DEFAULT '',  -- crypto: TRC20/ERC20/...; '' = fiat
+    enabled     BOOLEAN       NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT ck_currency_code_format CHECK (code = UPPER(code) AND code ~ '^[A-Z0-9]{2,10}$'),
+    CONSTRAINT ck_currency_decimals    CHECK (decimals BETWEEN 0 AND 8)
+);
+
+DROP TRIGGER IF EXISTS trg_currencies_updated_at ON currencies;
+CREATE TRIGGER trg_currencies_updated_at
+BEFORE UPDATE ON currencies
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
+
+-- IDR tanpa sen (1 = 1 rupiah, sama seperti k6); ETH dibatasi 8 desimal oleh NUMERIC(20,8)
+INSERT INTO currencies (code, name, decimals, network) VALUES
+    ('IDR',  'Indonesian Rupiah', 0, ''),
+    ('USD',  'US Dollar',         2, ''),
+    ('SGD',  'Singapore Dollar',  2, ''),
+    ('EUR',  'Euro',              2, ''),
+    ('USDT', 'Tether USD',        6, 'TRC20'),
+    ('BTC',  'Bitcoin',           8, 'BTC'),
+    ('ETH',  'Ether',             8, 'ERC20')
+ON CONFLICT (code) DO NOTHING;
+
+-- Data lama disimpan as-is (minor unit di kolom major unit): bagi 10^decimals.
+-- Currency yang tidak ada di registry dibiarkan (decimals tidak diketahui).
+UPDATE wallets w
+   SET balance = w.balance / (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = w.currency AND c.decimals > 0;
+
+UPDATE wallet_transactions t
+   SET amount = t.amount / (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = t.currency AND c.decimals > 0;
+
+UPDATE ledger_entries l
+   SET amount        = l.amount / (10 ^ c.decimals)::NUMERIC,
+       balance_after = l.balance_after / (10 ^ c.decimals)::NUMERIC
+  FROM currencies c
+ WHERE c.code = l.currency AND c.decimals > 0;
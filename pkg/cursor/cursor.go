@@ -0,0 +1,64 @@
+package cursor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidCursor: token tidak bisa di-decode (rusak / bukan dari server ini)
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrFilterMismatch: token dibuat untuk filter lain; dipakai ulang bisa melompati baris
+	ErrFilterMismatch = errors.New("cursor does not match request filters")
+)
+
+// Cursor: posisi keyset pagination. Dikirim ke client sebagai token opaque,
+// client cukup mengirim balik token apa adanya untuk halaman berikutnya.
+type Cursor struct {
+	LastID int64  `json:"id"`
+	Filter string `json:"f,omitempty"` // FilterHash filter request yang menghasilkan token
+}
+
+// FilterHash: sidik filter request (urutan parts harus tetap)
+func FilterHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Encode: Cursor → token base64url
+func Encode(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode: token → Cursor; token kosong = halaman pertama (LastID 0)
+func Decode(token string) (Cursor, error) {
+	var c Cursor
+	if token == "" {
+		return c, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.LastID <= 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// DecodeFor: Decode + pastikan token dibuat untuk filter yang sama
+func DecodeFor(token, filter string) (Cursor, error) {
+	c, err := Decode(token)
+	if err != nil || token == "" {
+		return c, err
+	}
+	if c.Filter != filter {
+		return Cursor{}, ErrFilterMismatch
+	}
+	return c, nil
+}